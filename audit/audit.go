@@ -0,0 +1,140 @@
+// Package audit records administrative actions in the audit_log table.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Recorded actions. The part before the dot is stored as the entry's log type.
+const (
+	ActionUserCreate    = "user.create"
+	ActionUserUpdate    = "user.update"
+	ActionUserRole      = "user.role"
+	ActionUserOrgUnits  = "user.orgunits"
+	ActionTokenIssue    = "token.issue"
+	ActionTokenRefresh  = "token.refresh"
+	ActionLoginFailed   = "login.failed"
+	ActionRoleCreate    = "roles.create"
+	ActionRoleUpdate    = "roles.update"
+	ActionRoleDelete    = "roles.delete"
+	ActionServerCreate  = "servers.create"
+	ActionServerUpdate  = "servers.update"
+	ActionServerSuspend = "servers.suspend"
+	ActionServerResume  = "servers.resume"
+	ActionServerSources = "servers.sources"
+	ActionServerDelete  = "servers.delete"
+	ActionMappingImport = "mappings.import"
+	ActionLogDelete     = "logs.delete"
+	ActionLogPurge      = "logs.purge"
+	ActionTaskReEnqueue = "tasks.reenqueue"
+	ActionTaskArchive   = "tasks.archive"
+	ActionTaskDelete    = "tasks.delete"
+)
+
+// Event is an action to record. ActorID 0 means the actor is not a known user.
+type Event struct {
+	Action   string
+	Actor    string
+	ActorID  int64
+	RemoteIP string
+	Detail   interface{}
+}
+
+// Entry is a recorded action
+type Entry struct {
+	ID        int64           `db:"id" json:"id" example:"42"`
+	LogType   string          `db:"logtype" json:"logType" example:"logs"`
+	Actor     string          `db:"actor" json:"actor" example:"admin"`
+	Action    string          `db:"action" json:"action" example:"logs.purge"`
+	RemoteIP  *string         `db:"remote_ip" json:"remoteIp,omitempty" example:"10.0.0.12"`
+	Detail    json.RawMessage `db:"detail" json:"detail" swaggertype:"object"`
+	CreatedBy *int64          `db:"created_by" json:"createdBy,omitempty" example:"1"`
+	Created   time.Time       `db:"created" json:"created"`
+}
+
+// Filter selects audit entries; zero values match everything
+type Filter struct {
+	Actor    string
+	Action   string
+	LogType  string
+	From     time.Time
+	To       time.Time
+	Page     int
+	PageSize int
+}
+
+// Record stores an event in the audit log
+func Record(db *sqlx.DB, e Event) error {
+	detail, err := json.Marshal(e.Detail)
+	if err != nil {
+		return err
+	}
+	logType, _, _ := strings.Cut(e.Action, ".")
+	actorID := &e.ActorID
+	if e.ActorID == 0 {
+		actorID = nil
+	}
+	_, err = db.Exec(`
+		INSERT INTO audit_log (logtype, actor, action, remote_ip, detail, created_by)
+		VALUES ($1, $2, $3, NULLIF($4, '')::inet, $5, $6)`,
+		logType, e.Actor, e.Action, e.RemoteIP, string(detail), actorID)
+	return err
+}
+
+// List returns a page of audit entries matching the filter, newest first, and the number of
+// matching entries
+func List(db *sqlx.DB, filter Filter) ([]Entry, int, error) {
+	var (
+		args  []interface{}
+		where []string
+	)
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if filter.Actor != "" {
+		add("actor = $%d", filter.Actor)
+	}
+	if filter.Action != "" {
+		add("action = $%d", filter.Action)
+	}
+	if filter.LogType != "" {
+		add("logtype = $%d", filter.LogType)
+	}
+	if !filter.From.IsZero() {
+		add("created >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		add("created <= $%d", filter.To)
+	}
+	cond := ""
+	if len(where) > 0 {
+		cond = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	page := filter.Page
+	if page < 1 {
+		page = 1
+	}
+	pageSize := filter.PageSize
+	if pageSize <= 0 {
+		pageSize = 20
+	}
+
+	var total int
+	if err := db.Get(&total, "SELECT COUNT(*) FROM audit_log"+cond, args...); err != nil {
+		return nil, 0, err
+	}
+	entries := []Entry{}
+	query := `SELECT id, logtype, actor, action, host(remote_ip) AS remote_ip, detail, created_by, created
+		FROM audit_log` + cond + fmt.Sprintf(" ORDER BY id DESC LIMIT %d OFFSET %d", pageSize, (page-1)*pageSize)
+	if err := db.Select(&entries, query, args...); err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
@@ -1,5 +1,7 @@
 package auth
 
+import "strings"
+
 type Permission struct {
 	Module string
 	Perms  string
@@ -18,6 +20,83 @@ type User struct {
 	ID       int64
 	Username string
 	Role     string
+	IsAdmin  bool
 	Perms    map[string]string
 	OrgUnits []OrgUnit
 }
+
+// Permission letters as stored in user_role_permissions.sys_perms
+const (
+	PermRead   = "r"
+	PermModify = "m"
+	PermAdd    = "a"
+	PermDelete = "d"
+)
+
+// AllPerms grants every permission on a module
+const AllPerms = PermRead + PermModify + PermAdd + PermDelete
+
+// Modules that role permissions are granted on
+const (
+	ModuleUsers     = "Users"
+	ModuleRoles     = "Roles"
+	ModuleAggregate = "Aggregate"
+	ModuleTracker   = "Tracker"
+	ModuleLogs      = "Logs"
+	ModuleMappings  = "Mappings"
+	ModuleServers   = "Servers"
+	ModuleAudit     = "Audit"
+)
+
+// Modules lists every module known to the gateway
+var Modules = []string{ModuleUsers, ModuleRoles, ModuleAggregate, ModuleTracker, ModuleLogs, ModuleMappings,
+	ModuleServers, ModuleAudit}
+
+var permNames = map[rune]string{'r': "read", 'm': "modify", 'a': "add", 'd': "delete"}
+
+// ValidPerms reports whether perms only holds known permission letters
+func ValidPerms(perms string) bool {
+	for _, p := range perms {
+		if _, ok := permNames[p]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// DescribePerms spells out permission letters, e.g. "read, add" for "ra"
+func DescribePerms(perms string) string {
+	names := make([]string, 0, len(perms))
+	for _, p := range perms {
+		if name, ok := permNames[p]; ok {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// Can reports whether the user holds every permission in perms on module. Admin users
+// hold all permissions.
+func (u *User) Can(module, perms string) bool {
+	if u.IsAdmin {
+		return true
+	}
+	granted := u.Perms[module]
+	for _, p := range perms {
+		if !strings.ContainsRune(granted, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// CoversOrgUnitPath reports whether an org unit path is one of the user's assigned org units
+// or descends from one
+func (u *User) CoversOrgUnitPath(path string) bool {
+	for _, ou := range u.OrgUnits {
+		if ou.Path != "" && (path == ou.Path || strings.HasPrefix(path, ou.Path+"/")) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,6 +2,7 @@ package clients
 
 import (
 	"dhis2gw/config"
+	"dhis2gw/metrics"
 	"errors"
 	"fmt"
 	"net/url"
@@ -76,6 +77,10 @@ func (s *Server) NewDhis2Client() (*Client, error) {
 		"User-Agent":   "HIPS-Uganda DHIS2 CLI",
 	})
 	client.SetDisableWarn(true)
+	// these servers carry no name, so calls are labelled with the host they go to
+	if u, err := url.Parse(baseUrl); err == nil {
+		metrics.InstrumentResty(client, u.Host)
+	}
 	switch s.AuthMethod {
 	case "Basic":
 		client.SetBasicAuth(s.Username, s.Password)
@@ -172,7 +177,7 @@ type Event struct {
 	Event        string             `json:"event"`
 	Program      string             `json:"program,omitempty"`
 	ProgramStage string             `json:"programStage,omitempty"`
-	orgUnit      string             `json:"orgUnit,omitempty"`
+	OrgUnit      string             `json:"orgUnit,omitempty"`
 	OccurredAt   string             `json:"occurredAt,omitempty"`
 	ScheduledAt  string             `json:"scheduledAt,omitempty"`
 	DataValues   []schema.DataValue `json:"dataValues,omitempty"`
@@ -5,9 +5,13 @@ import (
 	"dhis2gw/config"
 	"dhis2gw/controllers"
 	"dhis2gw/db"
+	"dhis2gw/metrics"
 	"dhis2gw/middleware"
 	"dhis2gw/models"
+	"dhis2gw/tasks"
+	"dhis2gw/utils"
 	"fmt"
+	sdk "github.com/HISP-Uganda/go-dhis2-sdk"
 	"github.com/gin-gonic/gin"
 	"github.com/hibiken/asynq"
 	log "github.com/sirupsen/logrus"
@@ -53,12 +57,26 @@ func main() {
 		log.WithError(err).Warn("Failed to start config watcher")
 	}
 
-	client = asynq.NewClient(asynq.RedisClientOpt{Addr: cfg.Server.RedisAddress})
+	client = asynq.NewClient(asynq.RedisClientOpt{Addr: cfg.Server.RedisAddress, DB: cfg.Server.RedisDB})
 	defer func(client *asynq.Client) {
 		_ = client.Close()
 	}(client)
 
+	// The default DHIS2 instance client is used when validating requests against DHIS2 metadata
+	dhis2Client := sdk.NewClient(
+		cfg.API.DHIS2BaseURL,
+		cfg.API.DHIS2User,
+		cfg.API.DHIS2Password)
+	tasks.SetClient(dhis2Client)
+
+	// /metrics is kept off the public API router and only served on the metrics address
+	metrics.RegisterQueueCollector(
+		asynq.NewInspector(asynq.RedisClientOpt{Addr: cfg.Server.RedisAddress, DB: cfg.Server.RedisDB}),
+		utils.QueueNames(cfg.Server.QueuePrefix))
+	metrics.ListenAndServe(cfg.Server.MetricsAddress)
+
 	router := gin.Default()
+	router.Use(metrics.GinMiddleware())
 
 	// Define template functions
 	funcMap := template.FuncMap{
@@ -74,6 +92,14 @@ func main() {
 	// Serve static files
 	router.Static("/static", cfg.Server.StaticDirectory)
 
+	authController := &controllers.AuthController{}
+	authGroup := router.Group("/api/auth", middleware.RequireJWTSecret)
+	{
+		authGroup.POST("/login", authController.Login)
+		authGroup.POST("/refresh", authController.RefreshToken)
+		authGroup.POST("/logout", authController.Logout)
+	}
+
 	v2 := router.Group("/api", middleware.BasicAuth(db.GetDB(), client))
 	{
 		v2.GET("/test2", func(c *gin.Context) {
@@ -81,14 +107,49 @@ func main() {
 		})
 
 		userController := &controllers.UserController{}
-		v2.POST("/user", userController.CreateUser)
-		v2.GET("/users/:uid", userController.GetUserByUID)
-		v2.PUT("/users/:uid", userController.UpdateUser)
+		v2.POST("/user", middleware.Require("Users", "a"), userController.CreateUser)
+		v2.GET("/users/:uid", middleware.Require("Users", "r"), userController.GetUserByUID)
+		v2.PUT("/users/:uid", middleware.Require("Users", "m"), userController.UpdateUser)
+		v2.GET("/users/:uid/orgunits", middleware.Require("Users", "r"), userController.GetUserOrgUnits)
+		v2.PUT("/users/:uid/orgunits", middleware.Require("Users", "m"), userController.SetUserOrgUnits)
 		v2.POST("/users/getToken", userController.CreateUserToken)
 		v2.POST("/users/refreshToken", userController.RefreshUserToken)
 
+		roleController := &controllers.RoleController{}
+		v2.GET("/roles", middleware.Require("Roles", "r"), roleController.GetRolesHandler)
+		v2.POST("/roles", middleware.Require("Roles", "a"), roleController.CreateRoleHandler)
+		v2.GET("/roles/:id", middleware.Require("Roles", "r"), roleController.GetRoleHandler)
+		v2.PUT("/roles/:id", middleware.Require("Roles", "m"), roleController.UpdateRoleHandler)
+		v2.DELETE("/roles/:id", middleware.Require("Roles", "d"), roleController.DeleteRoleHandler)
+		v2.PUT("/users/:uid/role", middleware.Require("Users", "m"), roleController.SetUserRoleHandler)
+
+		auditController := &controllers.AuditController{}
+		v2.GET("/audit", middleware.Require("Audit", "r"), auditController.GetAuditHandler)
+
+		serverController := &controllers.ServerController{}
+		v2.GET("/servers", middleware.Require("Servers", "r"), serverController.GetServersHandler)
+		v2.POST("/servers", middleware.Require("Servers", "a"), serverController.CreateServerHandler)
+		v2.GET("/servers/:uid", middleware.Require("Servers", "r"), serverController.GetServerHandler)
+		v2.PUT("/servers/:uid", middleware.Require("Servers", "m"), serverController.UpdateServerHandler)
+		v2.DELETE("/servers/:uid", middleware.Require("Servers", "d"), serverController.DeleteServerHandler)
+		v2.POST("/servers/:uid/suspend", middleware.Require("Servers", "m"), serverController.SuspendServerHandler)
+		v2.POST("/servers/:uid/resume", middleware.Require("Servers", "m"), serverController.ResumeServerHandler)
+		v2.GET("/servers/:uid/sources", middleware.Require("Servers", "r"), serverController.GetServerSourcesHandler)
+		v2.PUT("/servers/:uid/sources", middleware.Require("Servers", "m"), serverController.SetServerSourcesHandler)
+
 		aggregateController := &controllers.AggregateController{}
-		v2.POST("/aggregate", aggregateController.CreateRequest)
+		v2.POST("/aggregate", middleware.Require("Aggregate", "a"), aggregateController.CreateRequest)
+		v2.POST("/aggregate/bulk", middleware.Require("Aggregate", "a"), aggregateController.BulkCreateRequest)
+
+		trackerController := &controllers.TrackerController{}
+		v2.POST("/tracker", middleware.Require("Tracker", "a"), trackerController.CreateRequest)
+
+		failedTaskController := &controllers.FailedTaskController{}
+		v2.GET("/tasks/failed", middleware.Require("Logs", "r"), failedTaskController.GetFailedTasksHandler)
+		// Requeue, archive and delete check Aggregate or Tracker permissions per task
+		v2.POST("/tasks/failed/requeue", failedTaskController.RequeueFailedTasksHandler)
+		v2.POST("/tasks/failed/archive", failedTaskController.ArchiveFailedTasksHandler)
+		v2.POST("/tasks/failed/delete", failedTaskController.DeleteFailedTasksHandler)
 	}
 	// Handle error response when a route is not defined
 	router.NoRoute(func(c *gin.Context) {
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"dhis2gw/config"
+	"dhis2gw/period"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/goccy/go-json"
@@ -13,18 +16,28 @@ func GetIBPData(c *gin.Context) {
 	vote := c.Query("vote")
 	year := c.Query("year")
 	dataSet := c.Query("dataSet")
-	period := c.Query("period")
+	periodID := c.Query("period")
 	// data := ""
 
 	// "https://train.ndpme.go.ug/ndpdb/api/40/dataValueSets?orgUnit=loDwQx7yYgv&dataSet=h4fLQM9G8vr&period=2025July"
 	// r, err := dhis2Client.GetResource("system/info", nil)
-	if vote == "" || year == "" || period == "" {
-
+	// an explicit period wins, otherwise the fiscal year (e.g. 2025-2026) gives the financial year period
+	var pe period.Period
+	var err error
+	if periodID != "" {
+		pe, err = period.Parse(periodID)
+	} else {
+		fiscal := period.FiscalCalendar{StartMonth: time.Month(config.MustGet().Config.Server.FiscalYearStartMonth)}
+		pe, err = fiscal.Year(year)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
 	params := url.Values{}
 	params.Add("dataSet", "h4fLQM9G8vr")
-	params.Add("period", "2025July")
+	params.Add("period", pe.ID)
 	params.Add("orgUnit", "loDwQx7yYgv")
 	r, err := dhis2Client.GetResourceValues("dataValueSets", params)
 	if err != nil {
@@ -45,7 +58,7 @@ func GetIBPData(c *gin.Context) {
 			"vote":    vote,
 			"year":    year,
 			"dataSet": dataSet,
-			"period":  period,
+			"period":  pe.ID,
 			"data":    data,
 		})
 		return
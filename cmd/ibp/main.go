@@ -4,6 +4,7 @@ import (
 	// "dhis2gw/clients"
 	"dhis2gw/config"
 	"dhis2gw/db"
+	"dhis2gw/metrics"
 	"dhis2gw/middleware"
 	"dhis2gw/models"
 	"dhis2gw/utils"
@@ -72,6 +73,7 @@ func main() {
 		utils.CoalesceString(cfg.DHIS2URL, baseCfg.API.DHIS2BaseURL, "https://play.im.dhis2.org/stable-2-42-3/api/"),
 		utils.CoalesceString(cfg.DHIS2User, baseCfg.API.DHIS2User, "admin"),
 		utils.CoalesceString(cfg.DHIS2Password, baseCfg.API.DHIS2Password, "district"))
+	metrics.InstrumentResty(dhis2Client.Resty, cfg.InstanceName)
 	//sdkClient.GetResource()
 
 	//dhis2Server := clients.Server{
@@ -114,6 +116,7 @@ func main() {
 	_, err1 := s.Cron(cfg.Server.DataSyncCronExpression).Do(func() {
 		log.Info("Running sync projects schedule")
 		syncErr := SyncProjects(client, cfg.Server.BaseURL, dhis2Client)
+		metrics.SyncRun("ibp", syncErr)
 		if syncErr != nil {
 			log.Errorf("Failed to sync projects: %v", syncErr)
 		}
@@ -131,6 +134,8 @@ func main() {
 	//fmt.Println("Access:", loginResp.AccessToken)
 	//fmt.Println("Refresh:", loginResp.RefreshToken)
 	router := gin.Default()
+	router.Use(metrics.GinMiddleware())
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
 	v2 := router.Group("/api", middleware.BasicAuth(db.GetDB(), nil))
 	{
 		v2.GET("/test", func(c *gin.Context) {
@@ -8,7 +8,9 @@ import (
 	"dhis2gw/config"
 	"dhis2gw/db"
 	"dhis2gw/mappings"
+	"dhis2gw/metrics"
 	"dhis2gw/models"
+	"dhis2gw/period"
 	"fmt"
 	"os"
 	"os/signal"
@@ -60,6 +62,7 @@ func main() {
 	}); err != nil {
 		log.WithError(err).Warn("Failed to start config watcher")
 	}
+	metrics.ListenAndServe(cfg.Server.MetricsAddress)
 
 	baseURL := cfg.PBS.PBSURL
 	fy := cfg.PBS.FiscalYear
@@ -128,7 +131,9 @@ func main() {
 		runCtx, cancelRun := context.WithTimeout(rootCtx, 10*time.Minute)
 		defer cancelRun()
 
-		if err := fetchPiapIndicatorProjectionsByFiscalYear(runCtx, cfg, mappingCache, client, fy); err != nil {
+		err := fetchPiapIndicatorProjectionsByFiscalYear(runCtx, cfg, mappingCache, client, fy)
+		metrics.SyncRun("pbs", err)
+		if err != nil {
 			log.Fatalf("pbs-sync: fetch error: %v", err)
 		}
 		log.Println("pbs-sync: single run completed (Sync.Once=true)")
@@ -144,7 +149,9 @@ func main() {
 		//	log.Printf("pbs-sync: fetch error: %v", err)
 		//}
 		runCtx, cancelRun := context.WithTimeout(rootCtx, 10*time.Minute)
-		if err := fetchPiapIndicatorProjectionsByFiscalYear(runCtx, cfg, mappingCache, client, fy); err != nil {
+		err := fetchPiapIndicatorProjectionsByFiscalYear(runCtx, cfg, mappingCache, client, fy)
+		metrics.SyncRun("pbs", err)
+		if err != nil {
 			log.Printf("pbs-sync: fetch error: %v", err)
 		}
 
@@ -306,6 +313,11 @@ func BuildPBSDataValues(
 
 	var dvs []schema.DataValue
 	var quarterPattern = regexp.MustCompile(`(?i)^(Q[1-4])[_\-]?(.*)$`)
+	fiscal := fiscalCalendar(cfg)
+	annual, err := fiscal.Year(fy)
+	if err != nil {
+		return nil, err
+	}
 
 	for _, record := range records {
 		voteCode, _ := record["Vote_Code"].(string)
@@ -332,7 +344,11 @@ func BuildPBSDataValues(
 					// fallback to defaultcombo if unknown field
 					combo.UID = cfg.PBS.DefaultCategoryOptionCombo
 				}
-				period := deriveQuarterPeriod(fy, quarter)
+				pe, err := fiscal.Quarter(fy, quarter)
+				if err != nil {
+					return nil, err
+				}
+				period := pe.ID
 				val := fmt.Sprintf("%.0f", rawVal) // convert numeric to string
 				dvs = append(dvs, schema.DataValue{
 					DataElement:          &deMapping.DataElement,
@@ -352,9 +368,8 @@ func BuildPBSDataValues(
 					combo.UID = cfg.PBS.DefaultCategoryOptionCombo
 				}
 
-				// derive full-year period (e.g. 2025 for FY 2025-2026)
-				year := strings.Split(fy, "-")[0]
-				period := strings.TrimSpace(fmt.Sprintf("%sJuly", year))
+				// full fiscal year period (e.g. 2025July for FY 2025-2026)
+				period := annual.ID
 
 				val := fmt.Sprintf("%.0f", rawVal)
 
@@ -429,17 +444,23 @@ func BuildPBSDataValues2(
 		{"Q4", row.Q4Release, row.Q4Expenditure},
 	}
 
+	fiscal := fiscalCalendar(cfg)
 	for _, q := range quarters {
-		period := deriveQuarterPeriod(row.Fiscal_Year, q.qName)
-		appendDV("expenditure", period, q.expenditure)
-		appendDV("release", period, q.release)
+		pe, err := fiscal.Quarter(row.Fiscal_Year, q.qName)
+		if err != nil {
+			return nil, err
+		}
+		appendDV("expenditure", pe.ID, q.expenditure)
+		appendDV("release", pe.ID, q.release)
 	}
 
-	// Approved Budget (annual, July period of first FY year)
+	// Approved Budget (annual, financial year period)
 	if row.ApprovedBudget != 0 {
-		year := strings.Split(row.Fiscal_Year, "-")[0]
-		period := strings.TrimSpace(fmt.Sprintf("%sJuly", year))
-		appendDV("approved", period, row.ApprovedBudget)
+		pe, err := fiscal.Year(row.Fiscal_Year)
+		if err != nil {
+			return nil, err
+		}
+		appendDV("approved", pe.ID, row.ApprovedBudget)
 	}
 
 	return dvs, nil
@@ -489,8 +510,13 @@ func BuildPiapIndicatorProjectsDataValues(
 		{qName: "Q4", actual: row.Q4_Cum_Performance, reasonForVariation: row.Q4_Reason_For_Variation},
 	}
 
+	fiscal := fiscalCalendar(cfg)
 	for _, q := range quarters {
-		period := deriveQuarterPeriod(row.Fiscal_Year, q.qName)
+		pe, err := fiscal.Quarter(row.Fiscal_Year, q.qName)
+		if err != nil {
+			return nil, err
+		}
+		period := pe.ID
 		appendDV(
 			"cg_piap_indicator_projections_actual", period, q.actual, q.reasonForVariation, &dvs, deMapping, ouMapping, getComboUID)
 		//if q.reasonForVariation != "" {
@@ -499,16 +525,18 @@ func BuildPiapIndicatorProjectsDataValues(
 		log.WithFields(log.Fields{"PBS_QUATER": q.qName, "PERIOD": period, "Year": row.Fiscal_Year}).Info("Period Information")
 	}
 
-	// Approved Budget (annual, July period of first FY year)
+	// Target for the first year (annual, financial year period)
 	if row.Target_Y1 != "" {
 		// targetY1, err := strconv.ParseFloat(row.Target_Y1, 64)
 		//if err != nil {
 		//	return nil, err
 		//}
 		//if targetY1 != 0 {
-		year := strings.Split(row.Fiscal_Year, "-")[0]
-		period := strings.TrimSpace(fmt.Sprintf("%sJuly", year))
-		appendDV("cg_piap_indicator_projections_target_y1", period, row.Target_Y1, "", &dvs, deMapping, ouMapping, getComboUID)
+		pe, err := fiscal.Year(row.Fiscal_Year)
+		if err != nil {
+			return nil, err
+		}
+		appendDV("cg_piap_indicator_projections_target_y1", pe.ID, row.Target_Y1, "", &dvs, deMapping, ouMapping, getComboUID)
 		//}
 	}
 	log.WithFields(log.Fields{"DATAVALUES": dvs}).Debug("The data values to push")
@@ -516,59 +544,10 @@ func BuildPiapIndicatorProjectsDataValues(
 	return dvs, nil
 }
 
-// deriveQuarterPeriod converts a fiscal year like "2025-2026" and "Q1"
-// into the correct DHIS2 period, assuming fiscal year starts in July.
-//
-// Quarter mapping (Uganda):
-//
-//	Q1 → July–Sep  (Year = first part of FY)
-//	Q2 → Oct–Dec   (Year = first part of FY)
-//	Q3 → Jan–Mar   (Year = second part of FY)
-//	Q4 → Apr–Jun   (Year = second part of FY)
-//
-// Examples:
-//
-//	deriveQuarterPeriod("2025-2026", "Q1") → "2025Q3"
-//	deriveQuarterPeriod("2025-2026", "Q2") → "2025Q4"
-//	deriveQuarterPeriod("2025-2026", "Q3") → "2026Q1"
-//	deriveQuarterPeriod("2025-2026", "Q4") → "2026Q2"
-func deriveQuarterPeriod(fiscalYear string, quarter string) string {
-	// Split the fiscal year (e.g., "2025-2026")
-	years := strings.FieldsFunc(fiscalYear, func(r rune) bool { return r == '-' || r == '/' })
-	startYear := strings.TrimSpace(years[0])
-	endYear := startYear
-	if len(years) > 1 {
-		endYear = strings.TrimSpace(years[1])
-	}
-
-	if startYear == "" {
-		startYear = fmt.Sprintf("%d", time.Now().Year())
-		endYear = startYear
-	}
-
-	q := strings.ToUpper(strings.TrimSpace(quarter))
-	var year string
-	var dhisQuarter string
-
-	switch q {
-	case "Q1":
-		// Jul–Sep of start year → Calendar Q3
-		year, dhisQuarter = startYear, "Q3"
-	case "Q2":
-		// Oct–Dec of start year → Calendar Q4
-		year, dhisQuarter = startYear, "Q4"
-	case "Q3":
-		// Jan–Mar of next year → Calendar Q1
-		year, dhisQuarter = endYear, "Q1"
-	case "Q4":
-		// Apr–Jun of next year → Calendar Q2
-		year, dhisQuarter = endYear, "Q2"
-	default:
-		// fallback
-		year, dhisQuarter = startYear, q
-	}
-
-	return fmt.Sprintf("%s%s", year, dhisQuarter)
+// fiscalCalendar returns the PBS fiscal calendar. PBS fiscal years are labelled like
+// "2025-2026"; with the default July start their Q1 is the DHIS2 period 2025Q3.
+func fiscalCalendar(cfg *config.Config) period.FiscalCalendar {
+	return period.FiscalCalendar{StartMonth: time.Month(cfg.Server.FiscalYearStartMonth)}
 }
 
 func appendDV[T float64 | string](
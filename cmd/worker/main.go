@@ -4,8 +4,10 @@ import (
 	"dhis2gw/bootstrap"
 	"dhis2gw/config"
 	"dhis2gw/db"
+	"dhis2gw/metrics"
 	"dhis2gw/models"
 	"dhis2gw/tasks"
+	"dhis2gw/utils"
 	"fmt"
 	sdk "github.com/HISP-Uganda/go-dhis2-sdk"
 	"github.com/hibiken/asynq"
@@ -59,21 +61,33 @@ func main() {
 
 	// Set up Asynq server
 	srv := asynq.NewServer(
-		asynq.RedisClientOpt{Addr: cfg.Server.RedisAddress},
+		asynq.RedisClientOpt{Addr: cfg.Server.RedisAddress, DB: cfg.Server.RedisDB},
 		asynq.Config{
 			Concurrency: cfg.Server.MaxConcurrent,
-			Queues: map[string]int{
-				"critical": 6,
-				"default":  3,
-				"low":      1,
-			},
+			// Same queues the API enqueues into
+			Queues:         utils.Queues(cfg.Server.QueuePrefix),
+			RetryDelayFunc: tasks.RetryDelay,
 			// Add any additional config here (timeout, logger, etc)
 		},
 	)
 
+	// Handlers enqueue follow-up tasks, e.g. checks of async DHIS2 imports
+	client := asynq.NewClient(asynq.RedisClientOpt{Addr: cfg.Server.RedisAddress, DB: cfg.Server.RedisDB})
+	defer func() { _ = client.Close() }()
+	tasks.SetTaskClient(client)
+
 	// Register task handlers
 	mux := asynq.NewServeMux()
 	mux.HandleFunc(tasks.TypeAggregate, tasks.HandleAggregateTask)
+	mux.HandleFunc(tasks.TypeAggregateBulk, tasks.HandleBulkAggregateTask)
+	mux.HandleFunc(tasks.TypeAggregateImportCheck, tasks.HandleAggregateImportCheckTask)
+	mux.HandleFunc(tasks.TypeTracker, tasks.HandleTrackerTask)
+	mux.Use(metrics.TaskMiddleware)
+
+	metrics.RegisterQueueCollector(
+		asynq.NewInspector(asynq.RedisClientOpt{Addr: cfg.Server.RedisAddress, DB: cfg.Server.RedisDB}),
+		utils.QueueNames(cfg.Server.QueuePrefix))
+	metrics.ListenAndServe(cfg.Server.MetricsAddress)
 
 	// Start the worker (blocking)
 	if err := srv.Run(mux); err != nil {
@@ -215,6 +215,22 @@ type Config struct {
 		SSLServerCertKeyFile        string `mapstructure:"ssl_server_certkey_file" env:"SSL_SERVER_CERTKEY_FILE" env-default:""`
 		SSLTrustedCAFile            string `mapstructure:"ssl_trusted_cafile" env:"SSL_TRUSTED_CA_FILE" env-default:""`
 		TimeZone                    string `mapstructure:"timezone" env:"DISPATCHER2_TIMEZONE" env-default:"Africa/Kampala" env-description:"The time zone used for this dispatcher2 deployment"`
+		FiscalYearStartMonth        int    `mapstructure:"fiscal_year_start_month" env:"DHIS2GW_FISCAL_YEAR_START_MONTH" env-description:"The month (1-12) source system fiscal years start in" env-default:"7"`
+
+		// Bearer auth with access JWTs and rotating refresh tokens
+		JWTSecret       string        `mapstructure:"jwt_secret" env:"DHIS2GW_JWT_SECRET" env-description:"The secret signing access JWTs; Bearer auth is disabled when empty" env-default:""`
+		AccessTokenTTL  time.Duration `mapstructure:"access_token_ttl" env:"DHIS2GW_ACCESS_TOKEN_TTL" env-description:"How long access JWTs are valid" env-default:"15m"`
+		RefreshTokenTTL time.Duration `mapstructure:"refresh_token_ttl" env:"DHIS2GW_REFRESH_TOKEN_TTL" env-description:"How long refresh tokens are valid" env-default:"720h"`
+
+		// Exponential backoff with jitter between retries of failed DHIS2 imports
+		RetryBaseDelay time.Duration `mapstructure:"retry_base_delay" env:"DHIS2GW_RETRY_BASE_DELAY" env-description:"The delay before the first retry of a failed import" env-default:"30s"`
+		RetryMaxDelay  time.Duration `mapstructure:"retry_max_delay" env:"DHIS2GW_RETRY_MAX_DELAY" env-description:"The longest delay between retries of a failed import" env-default:"1h"`
+
+		// Requests forwarded to destination servers and url schedules verify TLS certificates unless this is set
+		RequestTLSInsecureSkipVerify bool `mapstructure:"request_tls_insecure_skip_verify" env:"DHIS2GW_REQUEST_TLS_INSECURE_SKIP_VERIFY" env-description:"Whether forwarded requests skip TLS certificate verification" env-default:"false"`
+
+		// Every process serves /metrics here only, never on the public API port
+		MetricsAddress string `mapstructure:"metrics_address" env:"DHIS2GW_METRICS_ADDRESS" env-description:"The listen address for /metrics, e.g. 127.0.0.1:9102; disabled when empty" env-default:""`
 	} `yaml:"server"`
 
 	API struct {
@@ -224,6 +240,11 @@ type Config struct {
 		DHIS2Password             string `mapstructure:"dhis2_password" env:"dhis2_password" env-description:"The DIS2GW base DHIS2  user password"`
 		DHIS2PAT                  string `mapstructure:"dhis2_pat" env:"dhis2_pat" env-description:"The DIS2GW base DHIS2  Personal Access Token"`
 		SaveResponse              string `mapstructure:"save_response" env:"save_response" env-description:"Whether to save the response from DHIS2 in the database" env-default:"true"`
+		AsyncAggregateImport      bool   `mapstructure:"async_aggregate_import" env:"async_aggregate_import" env-description:"Whether aggregate submissions are imported asynchronously by DHIS2" env-default:"false"`
+		StrictAggregateMapping    bool   `mapstructure:"strict_aggregate_mapping" env:"strict_aggregate_mapping" env-description:"Whether aggregate submissions with unmapped codes are rejected" env-default:"false"`
+		BulkAggregateMaxBlocks    int    `mapstructure:"bulk_aggregate_max_blocks" env:"bulk_aggregate_max_blocks" env-description:"The maximum number of blocks accepted in one bulk aggregate request" env-default:"1000"`
+		BulkAggregateMaxValues    int    `mapstructure:"bulk_aggregate_max_values" env:"bulk_aggregate_max_values" env-description:"The maximum number of data values sent to DHIS2 in one combined bulk import" env-default:"5000"`
+		OrgUnitScopeExemptRoles   string `mapstructure:"orgunit_scope_exempt_roles" env:"orgunit_scope_exempt_roles" env-description:"Comma-separated roles whose users may submit for any org unit while they have no assigned org units"`
 		AggregateMappingScheme    string `mapstructure:"mapping_scheme" env:"mapping_scheme" env-description:"The Dhis2 Aggregate mapping scheme" env-default:"CODE"`
 		DHIS2DataSet              string `mapstructure:"dhis2_data_set" env:"dhis2_data_set" env-description:"The DIS2GW base DHIS2 DATASET"`
 		DHIS2AttributeOptionCombo string `mapstructure:"dhis2_attribute_option_combo" env:"dhis_2_attribute_option_combo" env-description:"The DIS2GW base DHIS2 Attribute Option Combo"`
@@ -245,7 +266,7 @@ type Config struct {
 		PBSURL       string `mapstructure:"pbsurl" env:"PBS_URL" env-description:"The PBS URL to sync from" env-default:"http://localhost:8080/pbs"`
 		User         string `mapstructure:"user" env:"PBS_USER" env-description:"The user to use for PBS sync" env-default:"admin"`
 		Password     string `mapstructure:"password" env:"PBS_PASSWORD" env-description:"The password to use for PBS sync" env-default:"district"`
-		IPAddress    string `mapstructure:"ipaddress" env:"PBS_IPADDRESS" env-description:"The IP address to use for PBS sync" env-default:"`
+		IPAddress    string `mapstructure:"ipaddress" env:"PBS_IPADDRESS" env-description:"The IP address to use for PBS sync" env-default:""`
 		JWT          string `mapstructure:"jwt" env:"PBS_JWT" env-description:"The JWT token to use for PBS sync" env-default:""`
 		VoteCode     string `mapstructure:"vote_code" env:"PBS_VOTE_CODE" env-description:"The Vote code to fetch outturns for" env-default:""`
 		FiscalYear   string `mapstructure:"fiscal_year" env:"PBS_FISCAL_YEAR" env-description:"The Fiscal year to fetch outturns for" env-default:"2023"`
@@ -455,6 +476,8 @@ func defaultPaths() (configFilePath, configDir, confDDir string, err error) {
 
 func applyDefaults(cfg *Config) {
 	cfg.API.AggregateMappingScheme = "CODE"
+	cfg.API.BulkAggregateMaxBlocks = 1000
+	cfg.API.BulkAggregateMaxValues = 5000
 	cfg.PBS.Sync.Window = 15 * time.Minute
 	cfg.PBS.Sync.Interval = 1 * time.Minute
 	cfg.PBS.Sync.PageSize = 200
@@ -507,6 +530,14 @@ func applyDefaults(cfg *Config) {
 	cfg.PBS.Sync.Until, _ = time.Parse(time.RFC3339, "2023-12-31T23:59:59Z")
 	cfg.PBS.InstanceName = "train.ndpme"
 	cfg.Server.RedisDB = 5
+	cfg.Server.MaxRetries = 3
+	cfg.Server.RequestProcessInterval = 4
+	cfg.Server.Dhis2JobStatusCheckInterval = 30
+	cfg.Server.FiscalYearStartMonth = 7
+	cfg.Server.AccessTokenTTL = 15 * time.Minute
+	cfg.Server.RefreshTokenTTL = 30 * 24 * time.Hour
+	cfg.Server.RetryBaseDelay = 30 * time.Second
+	cfg.Server.RetryMaxDelay = time.Hour
 	cfg.Server.QueuePrefix = ""
 }
 
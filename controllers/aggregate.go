@@ -1,17 +1,24 @@
 package controllers
 
 import (
+	"crypto/sha256"
+	"dhis2gw/audit"
 	"dhis2gw/config"
-	"dhis2gw/db"
 	"dhis2gw/joblog"
+	"dhis2gw/metrics"
 	"dhis2gw/models"
+	"dhis2gw/period"
 	"dhis2gw/tasks"
 	"dhis2gw/utils"
 	_ "embed"
+	"encoding/hex"
 	"encoding/json"
+	goerrors "errors"
 	"fmt"
 	"net/http"
+	"strings"
 
+	"github.com/HISP-Uganda/go-dhis2-sdk/aggregate"
 	"github.com/gin-gonic/gin"
 	"github.com/hibiken/asynq"
 	"github.com/jmoiron/sqlx"
@@ -25,15 +32,23 @@ type AggregateController struct{}
 
 // CreateRequest godoc
 // @Summary Submit aggregate data request
-// @Description Accepts a JSON payload for an aggregate DHIS2 submission. Requires `Authorization: Token <token>` header.
+// @Description Accepts a JSON payload for an aggregate DHIS2 submission. The org unit is either a DHIS2 UID in orgUnit
+// @Description or a source system code in orgUnitCode, resolved through OU mappings or organisationunit code/mflid.
+// @Description A client may send an idempotency key in the Idempotency-Key header or the submissionId field. Repeating
+// @Description a key with the same body returns the original submission without queueing it again. The org unit
+// @Description must be one assigned to the user or below one; refused submissions are kept in the submission log.
+// @Description Requires `Authorization: Token <token>` header.
 // @Tags aggregate
 // @Accept json
 // @Produce json
 // @Security BasicAuth
 // @Security TokenAuth
+// @Param Idempotency-Key header string false "Client key identifying the submission, unique per user"
 // @Param request body models.AggregateRequest true "Aggregate submission payload"
 // @Success 200 {object} models.AggregateResponse
-// @Failure 400 {object} models.ErrorResponse "Invalid JSON or schema validation failed"
+// @Failure 400 {object} models.ErrorResponse "Invalid JSON, schema validation failed, unresolved org unit code, invalid period or unmapped codes in strict mode"
+// @Failure 403 {object} models.ErrorResponse "Org unit outside the user's assigned org units"
+// @Failure 409 {object} models.ErrorResponse "Idempotency key already used for a different request"
 // @Failure 500 {object} models.ErrorResponse "Server-side error"
 // @Router /aggregate [post]
 func (a *AggregateController) CreateRequest(c *gin.Context) {
@@ -44,37 +59,61 @@ func (a *AggregateController) CreateRequest(c *gin.Context) {
 		return
 	}
 
-	valid, errors, err := utils.ValidateJSONAgainstSchemaString(aggregateRequestSchema, req)
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	idemKey, err := idempotencyKeyFor(c, req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Schema validation error: " + err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if idemKey != nil {
+		original, err := joblog.FindIdempotent(db, *idemKey)
+		if original != nil || err != nil {
+			respondToRepeatedSubmission(c, original, err)
+			return
+		}
+	}
 
-	if !valid {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":  "Request does not match required schema",
-			"detail": errors,
-		})
+	request, payload, issues, rejected := prepareAggregateRequest(req)
+	if rejected != nil {
+		c.JSON(rejected.status, rejected.body)
 		return
 	}
-
-	var request models.AggregateRequest
-	jsonBytes, _ := json.Marshal(req)
-	if err := json.Unmarshal(jsonBytes, &request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not parse validated data: " + err.Error()})
+	reason, err := orgUnitScopeError(c, request.OrgUnit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check org unit scope"})
+		return
+	}
+	if reason != "" {
+		logRejectedSubmission(c, db, request, reason)
+		c.JSON(http.StatusForbidden, gin.H{"error": reason})
 		return
 	}
 
-	db := c.MustGet("dbConn").(*sqlx.DB)
 	asynqClient := c.MustGet("asynqClient").(*asynq.Client)
 
 	// Now we have a valid AggregateRequest, we can process it
-	jl, err := joblog.New(db, request)
+	var jl *joblog.JobLog
+	if idemKey != nil {
+		var created bool
+		jl, created, err = joblog.NewIdempotent(db, request, *idemKey)
+		if jl != nil && !created {
+			// A retry of the same submission raced this one
+			respondToRepeatedSubmission(c, jl, err)
+			return
+		}
+	} else {
+		jl, err = joblog.New(db, request)
+	}
 	if err != nil {
 		log.Errorf("Could not create job log: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log submission"})
 		return
 	}
+	if len(issues) > 0 {
+		if err := jl.UpdateConversionIssues(issues); err != nil {
+			log.Errorf("Could not save conversion issues: %v", err)
+		}
+	}
 
 	// 3. Enqueue a background job (pass JobLog ID in payload)
 	taskPayload := tasks.AggregateTaskPayload{
@@ -84,87 +123,419 @@ func (a *AggregateController) CreateRequest(c *gin.Context) {
 	task, err := tasks.NewAggregateTask(taskPayload)
 	if err != nil {
 		log.Errorf("Could not create aggregate task: %v", err)
+		_ = jl.FailUnqueued(err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create task"})
 		return
 	}
 	queue := cfg.Server.QueuePrefix + ":default"
 	taskInfo, err := asynqClient.Enqueue(task, asynq.Queue(queue))
 	if err != nil {
+		_ = jl.FailUnqueued(err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enqueue job"})
 		return
 	}
+	metrics.TaskEnqueued(task.Type())
 
 	// 4. Update job log with the Asynq Task ID
 	_ = jl.UpdateTaskID(taskInfo.ID) // handle error as needed
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":       "Aggregate request queued for processing",
-		"payload":       request.ToDHIS2AggregatePayload(),
+		"payload":       payload,
 		"submission_id": jl.ID,
 		"task_id":       taskInfo.ID,
+		"issues":        issues,
+	})
+}
+
+// BulkCreateRequest godoc
+// @Summary Submit many aggregate data blocks at once
+// @Description Accepts an array of blocks, each an aggregate submission for one org unit, period and data set. Every
+// @Description block is validated on its own and reported in results; blocks cannot carry a submissionId. Accepted
+// @Description blocks are grouped by instance, data set and import mode into combined dataValueSets imports of at
+// @Description most api.bulk_aggregate_max_values data values, and all groups are queued under one batch ID. Each
+// @Description block's data set is registered complete once its values are sent. Blocks for org units outside the user's assigned org
+// @Description units are rejected and kept in the submission log. Requires `Authorization: Token <token>` header.
+// @Tags aggregate
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param request body models.BulkAggregateRequest true "Bulk aggregate submission payload"
+// @Success 200 {object} models.BulkAggregateResponse
+// @Failure 400 {object} models.ErrorResponse "Invalid JSON, no blocks, too many blocks or no block accepted"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /aggregate/bulk [post]
+func (a *AggregateController) BulkCreateRequest(c *gin.Context) {
+	cfg := config.MustGet().Config
+	var req struct {
+		Blocks []map[string]interface{} `json:"blocks"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+	if len(req.Blocks) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "blocks must contain at least one aggregate submission"})
+		return
+	}
+	if limit := cfg.API.BulkAggregateMaxBlocks; limit > 0 && len(req.Blocks) > limit {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Too many blocks: %d, at most %d are accepted per request", len(req.Blocks), limit),
+		})
+		return
+	}
+
+	resp := models.BulkAggregateResponse{
+		Groups:  []models.BulkGroup{},
+		Results: make([]models.BulkBlockResult, len(req.Blocks)),
+	}
+	var (
+		accepted []models.AggregateRequest
+		values   []int
+		indexes  []int // position in req.Blocks of each accepted block
+	)
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	for i, block := range req.Blocks {
+		if _, ok := block["submissionId"]; ok {
+			// idempotency keys belong to one submission log, and a block shares its log with its group
+			resp.Results[i] = models.BulkBlockResult{Index: i, Error: errBulkSubmissionID}
+			continue
+		}
+		request, payload, issues, rejected := prepareAggregateRequest(block)
+		result := models.BulkBlockResult{
+			Index:   i,
+			OrgUnit: request.OrgUnit,
+			Period:  request.Period,
+			DataSet: request.DataSet,
+			Issues:  issues,
+		}
+		if rejected != nil {
+			result.Error, _ = rejected.body["error"].(string)
+			result.Detail = rejected.body["detail"]
+			resp.Results[i] = result
+			continue
+		}
+		reason, err := orgUnitScopeError(c, request.OrgUnit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check org unit scope"})
+			return
+		}
+		if reason != "" {
+			logRejectedSubmission(c, db, request, reason)
+			result.Error = reason
+			resp.Results[i] = result
+			continue
+		}
+		result.Accepted = true
+		resp.Results[i] = result
+		accepted = append(accepted, request)
+		values = append(values, len(payload.DataValues))
+		indexes = append(indexes, i)
+	}
+	if len(accepted) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":  "No block was accepted",
+			"detail": resp.Results,
+		})
+		return
+	}
+
+	asynqClient := c.MustGet("asynqClient").(*asynq.Client)
+	batch, err := joblog.NewBatch(db, currentUserID(c), len(req.Blocks))
+	if err != nil {
+		log.Errorf("Could not create submission batch: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log submission"})
+		return
+	}
+	resp.BatchID = batch.UID
+
+	queue := cfg.Server.QueuePrefix + ":default"
+	for _, group := range models.GroupAggregateBlocks(accepted, values, cfg.API.BulkAggregateMaxValues) {
+		blocks := make([]models.AggregateRequest, len(group))
+		dataValues := 0
+		for j, k := range group {
+			blocks[j] = accepted[k]
+			dataValues += values[k]
+		}
+		info, err := enqueueBulkGroup(batch, asynqClient, queue, blocks)
+		for _, k := range group {
+			result := &resp.Results[indexes[k]]
+			if err != nil {
+				result.Accepted, result.Error = false, "Failed to queue block: "+err.Error()
+				continue
+			}
+			result.SubmissionID = info.SubmissionID
+		}
+		if err != nil {
+			log.WithError(err).WithField("BatchID", batch.UID).Error("Could not queue bulk aggregate group")
+			continue
+		}
+		info.Blocks, info.DataValues = len(blocks), dataValues
+		resp.Groups = append(resp.Groups, info)
+	}
+
+	for _, result := range resp.Results {
+		if result.Accepted {
+			resp.Accepted++
+		} else {
+			resp.Rejected++
+		}
+	}
+	if err := batch.UpdateCounts(resp.Accepted, resp.Rejected); err != nil {
+		log.Errorf("Could not update submission batch counts: %v", err)
+	}
+	if len(resp.Groups) == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enqueue job", "detail": resp})
+		return
+	}
+	resp.Message = "Bulk aggregate request queued for processing"
+	c.JSON(http.StatusOK, resp)
+}
+
+// errBulkSubmissionID rejects bulk blocks that carry an idempotency key
+const errBulkSubmissionID = "submissionId is not supported in bulk blocks; send the block to POST /aggregate to make it idempotent"
+
+// enqueueBulkGroup logs and queues one group of a bulk request
+func enqueueBulkGroup(batch *joblog.Batch, asynqClient *asynq.Client, queue string,
+	blocks []models.AggregateRequest) (models.BulkGroup, error) {
+	jl, err := batch.NewInBatch(blocks)
+	if err != nil {
+		return models.BulkGroup{}, err
+	}
+	task, err := tasks.NewBulkAggregateTask(tasks.BulkAggregateTaskPayload{
+		LogID:   jl.ID,
+		BatchID: batch.ID,
+		Blocks:  blocks,
 	})
+	if err != nil {
+		_ = jl.FailUnqueued(err.Error())
+		return models.BulkGroup{}, err
+	}
+	info, err := asynqClient.Enqueue(task, asynq.Queue(queue))
+	if err != nil {
+		_ = jl.FailUnqueued(err.Error())
+		return models.BulkGroup{}, err
+	}
+	metrics.TaskEnqueued(task.Type())
+	_ = jl.UpdateTaskID(info.ID)
+	return models.BulkGroup{SubmissionID: jl.ID, TaskID: info.ID}, nil
+}
+
+// aggregateRejection is the response to a submission that cannot be accepted
+type aggregateRejection struct {
+	status int
+	body   gin.H
+}
+
+func rejectAggregate(status int, body gin.H) *aggregateRejection {
+	return &aggregateRejection{status: status, body: body}
+}
+
+// prepareAggregateRequest validates a decoded submission against the schema and the
+// gateway's configuration, resolves its org unit and period, and converts its data values.
+func prepareAggregateRequest(req map[string]interface{}) (
+	models.AggregateRequest, aggregate.DataValueSetPayload, []models.ConversionIssue, *aggregateRejection) {
+	var (
+		request models.AggregateRequest
+		payload aggregate.DataValueSetPayload
+	)
+	valid, errors, err := utils.ValidateJSONAgainstSchemaString(aggregateRequestSchema, req)
+	if err != nil {
+		return request, payload, nil, rejectAggregate(http.StatusInternalServerError,
+			gin.H{"error": "Schema validation error: " + err.Error()})
+	}
+	if !valid {
+		return request, payload, nil, rejectAggregate(http.StatusBadRequest, gin.H{
+			"error":  "Request does not match required schema",
+			"detail": errors,
+		})
+	}
+
+	jsonBytes, _ := json.Marshal(req)
+	if err := json.Unmarshal(jsonBytes, &request); err != nil {
+		return request, payload, nil, rejectAggregate(http.StatusBadRequest,
+			gin.H{"error": "Could not parse validated data: " + err.Error()})
+	}
+	if !tasks.KnownInstance(request.InstanceName()) {
+		return request, payload, nil, rejectAggregate(http.StatusBadRequest,
+			gin.H{"error": "Unknown DHIS2 instance: " + request.InstanceName()})
+	}
+
+	if err := request.ResolveOrgUnit(); err != nil {
+		if goerrors.Is(err, models.ErrOrgUnitNotResolved) {
+			return request, payload, nil, rejectAggregate(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return request, payload, nil, rejectAggregate(http.StatusInternalServerError,
+			gin.H{"error": "Failed to resolve org unit code"})
+	}
+
+	if err := checkAggregatePeriod(&request); err != nil {
+		return request, payload, nil, rejectAggregate(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+
+	payload, issues, err := request.BuildDHIS2AggregatePayload()
+	if err != nil {
+		log.Errorf("Could not convert aggregate request: %v", err)
+		return request, payload, nil, rejectAggregate(http.StatusInternalServerError,
+			gin.H{"error": "Failed to load data value mappings"})
+	}
+	if request.StrictMapping() && models.HasUnmapped(issues) {
+		return request, payload, issues, rejectAggregate(http.StatusBadRequest, gin.H{
+			"error":  "Request contains unmapped data value codes",
+			"detail": issues,
+		})
+	}
+	return request, payload, issues, nil
+}
+
+// maxIdempotencyKeyLength bounds client keys, matching the schema's submissionId
+const maxIdempotencyKeyLength = 255
+
+// idempotencyKeyFor returns the idempotency key of a submission, taken from the
+// Idempotency-Key header or else the submissionId field, or nil when the client sent none.
+// The key is bound to a hash of the body without submissionId, so the header and field
+// forms of the same submission are recognised as repeats of each other.
+func idempotencyKeyFor(c *gin.Context, body map[string]interface{}) (*joblog.IdempotencyKey, error) {
+	key := strings.TrimSpace(c.GetHeader("Idempotency-Key"))
+	field, _ := body["submissionId"].(string)
+	switch {
+	case key == "":
+		key = field
+	case field != "" && field != key:
+		return nil, fmt.Errorf("Idempotency-Key header %q does not match submissionId %q", key, field)
+	}
+	if key == "" {
+		return nil, nil
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		return nil, fmt.Errorf("idempotency key is longer than %d characters", maxIdempotencyKeyLength)
+	}
+
+	hashed := make(map[string]interface{}, len(body))
+	for k, v := range body {
+		if k != "submissionId" {
+			hashed[k] = v
+		}
+	}
+	// encoding/json sorts map keys, so equal bodies hash alike whatever their field order
+	raw, err := json.Marshal(hashed)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(raw)
+	return &joblog.IdempotencyKey{
+		UserID:      currentUserID(c),
+		Key:         key,
+		RequestHash: hex.EncodeToString(sum[:]),
+	}, nil
+}
+
+// respondToRepeatedSubmission answers a submission whose idempotency key is already held
+// by the original submission, given the outcome of looking that submission up.
+func respondToRepeatedSubmission(c *gin.Context, original *joblog.JobLog, err error) {
+	switch {
+	case goerrors.Is(err, joblog.ErrIdempotencyConflict):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":         err.Error(),
+			"submission_id": original.ID,
+		})
+	case err != nil:
+		log.WithError(err).Error("Could not look up idempotency key")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check idempotency key"})
+	default:
+		c.JSON(http.StatusOK, gin.H{
+			"message":       "Aggregate request was already submitted with this idempotency key",
+			"submission_id": original.ID,
+			"task_id":       original.TaskID.String,
+			"status":        original.Status,
+			"replayed":      true,
+		})
+	}
+}
+
+// checkAggregatePeriod validates the request period against the period type of its data
+// set. A date is replaced by the period of that type containing it. When DHIS2 cannot be
+// asked for the period type, a valid period is let through unchecked.
+func checkAggregatePeriod(r *models.AggregateRequest) error {
+	if period.IsDate(r.Period) {
+		periodType, err := tasks.DataSetPeriodType(r.InstanceName(), r.DataSet)
+		if err != nil {
+			return fmt.Errorf("cannot convert date %s to a period: %w", r.Period, err)
+		}
+		p, err := period.FromDate(r.Period, period.Type(periodType))
+		if err != nil {
+			return err
+		}
+		r.Period = p.ID
+		return nil
+	}
+
+	p, err := period.Parse(r.Period)
+	if err != nil {
+		return err
+	}
+	periodType, err := tasks.DataSetPeriodType(r.InstanceName(), r.DataSet)
+	if err != nil {
+		log.WithError(err).WithField("DataSet", r.DataSet).Warn("Could not check period against data set period type")
+		return nil
+	}
+	return period.CheckType(p, periodType)
 }
 
 // ReEnqueueAggregateTask godoc
 // @Summary Re-enqueue a failed aggregate task
-// @Description Re-enqueues a task from the dead or retry queue by its ID. Requires `Authorization: Token
+// @Description Re-enqueues an archived or retry task by its ID. Requires `Authorization: Token
 // @Tags aggregate
 // @Security BasicAuth
 // @Security TokenAuth
 // @Param task_id path string true "Task ID to re-enqueue"
-// @Param queue query string false "Queue to re-enqueue from (default: dead)"
+// @Param queue query string false "Queue holding the task, e.g. default or critical (default: default)"
 // @Success 200 {object} models.TaskReEnqueueResponse
 // @Failure 404 {object} models.ErrorResponse "Task not found"
 // @Failure 500 {object} models.ErrorResponse "Server-side error"
 // @Router /aggregate/reenqueue/{task_id} [post]
 func (a *AggregateController) ReEnqueueAggregateTask(c *gin.Context) {
-	cfg := config.MustGet().Config
 	taskID := c.Param("task_id")
-	queue := cfg.Server.QueuePrefix + ":default"
-	// queue := c.DefaultQuery("queue", "default")
+	queue := taskQueue(c.Query("queue"))
 	asyncClient := c.MustGet("asynqClient").(*asynq.Client)
-	inspector := asynq.NewInspector(asynq.RedisClientOpt{Addr: cfg.Server.RedisAddress})
+	inspector := newInspector()
+	defer func() { _ = inspector.Close() }()
 
-	// Get the task from the dead/retry queue
 	info, err := inspector.GetTaskInfo(queue, taskID)
 	if err != nil {
+		if goerrors.Is(err, asynq.ErrTaskNotFound) || goerrors.Is(err, asynq.ErrQueueNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Task not found in queue " + queue})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get task info in queue: " + err.Error()})
 		return
 	}
 
-	jl, err := joblog.GetByTaskID(db.GetDB(), taskID)
+	taskInfo, err := requeueTask(asyncClient, inspector, info)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Job log not found for task ID: " + taskID})
-		return
-	}
-
-	// Create a new Task from the old type & payload
-	task := asynq.NewTask(info.Type, info.Payload, asynq.MaxRetry(3))
-	// Enqueue as a new task (you can add options, like target queue or delay, here)
-	taskInfo, errEnqueue := asyncClient.Enqueue(task, asynq.Queue(queue))
-	if errEnqueue != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to re-enqueue task: " + err.Error()})
 		return
 	}
-
-	if jl != nil {
-		_ = jl.UpdateTaskID(taskInfo.ID)
-	}
-	_ = inspector.DeleteTask(info.Queue, taskID) // Remove from dead/retry queue
+	recordAudit(c, audit.ActionTaskReEnqueue, gin.H{
+		"queue":     info.Queue,
+		"taskId":    taskID,
+		"type":      info.Type,
+		"newTaskId": taskInfo.ID,
+	})
 	c.JSON(http.StatusOK, gin.H{
 		"message": fmt.Sprintf("Re-enqueued task %s (type: %s) from %s queue", taskID, info.Type, info.Queue),
 	})
-	return
 }
 
 type BatchReEnqueueRequest struct {
-	Queue   string   `json:"queue"`    // e.g., "dead" or "retry"
+	Queue   string   `json:"queue"`    // e.g. "default" or "critical"; defaults to the default queue
 	TaskIDs []string `json:"task_ids"` // task IDs to re-enqueue
 }
 
 // BatchReEnqueueAggregateTasksByIDs godoc
 // @Summary Re-enqueue multiple aggregate tasks by IDs
-// @Description Re-enqueues multiple tasks from the dead or retry queue by their IDs. Requires `Authorization
+// @Description Re-enqueues multiple archived or retry tasks by their IDs. Requires `Authorization
 // @Tags aggregate
 // @Security BasicAuth
 // @Security TokenAuth
@@ -175,20 +546,16 @@ type BatchReEnqueueRequest struct {
 // @Failure 500 {object} models.ErrorResponse "Server-side error"
 // @Router /aggregate/reenqueue/batch [post]
 func (a *AggregateController) BatchReEnqueueAggregateTasksByIDs(c *gin.Context) {
-	cfg := config.MustGet().Config
 	var req BatchReEnqueueRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
 		return
 	}
-
-	if req.Queue == "" {
-		req.Queue = "dead"
-	}
+	req.Queue = taskQueue(req.Queue)
 
 	asyncClient := c.MustGet("asynqClient").(*asynq.Client)
-	inspector := asynq.NewInspector(asynq.RedisClientOpt{
-		Addr: cfg.Server.RedisAddress, DB: cfg.Server.RedisDB})
+	inspector := newInspector()
+	defer func() { _ = inspector.Close() }()
 
 	reEnqueued := 0
 	failed := 0
@@ -201,28 +568,20 @@ func (a *AggregateController) BatchReEnqueueAggregateTasksByIDs(c *gin.Context)
 			errors = append(errors, fmt.Sprintf("Task %s: %v", taskID, err))
 			continue
 		}
-
-		queue := cfg.Server.QueuePrefix + ":default"
-		task := asynq.NewTask(info.Type, info.Payload)
-		taskInfo, err := asyncClient.Enqueue(task, asynq.MaxRetry(3), asynq.Queue(queue))
-		if err != nil {
+		if _, err := requeueTask(asyncClient, inspector, info); err != nil {
 			failed++
-			errors = append(errors, fmt.Sprintf("Task %s: failed to enqueue: %v", taskID, err))
+			errors = append(errors, fmt.Sprintf("Task %s: %v", taskID, err))
 			continue
 		}
-
-		// Optionally update your job log
-		jl, err := joblog.GetByTaskID(db.GetDB(), taskID)
-		if err == nil && jl != nil {
-			_ = jl.UpdateTaskID(taskInfo.ID)
-		}
-
-		// Optionally, delete original from dead queue:
-		// _ = inspector.DeleteTask(req.Queue, taskID)
-
 		reEnqueued++
 	}
 
+	recordAudit(c, audit.ActionTaskReEnqueue, gin.H{
+		"queue":      req.Queue,
+		"taskIds":    req.TaskIDs,
+		"reEnqueued": reEnqueued,
+		"failed":     failed,
+	})
 	c.JSON(http.StatusOK, gin.H{
 		"queue":      req.Queue,
 		"reEnqueued": reEnqueued,
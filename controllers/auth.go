@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"dhis2gw/audit"
+	"dhis2gw/auth"
+	"dhis2gw/config"
+	"dhis2gw/models"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+type AuthController struct{}
+
+type LoginInput struct {
+	Username string `json:"username" binding:"required" example:"admin"`
+	Password string `json:"password" binding:"required" example:"s3cretP@ss"`
+}
+
+type RefreshTokenInput struct {
+	RefreshToken string `json:"refresh_token" binding:"required" example:"42.9f86d081884c7d659a2feaa0c55ad015"`
+}
+
+type LogoutInput struct {
+	RefreshToken string `json:"refresh_token" binding:"required" example:"42.9f86d081884c7d659a2feaa0c55ad015"`
+	All          bool   `json:"all" example:"false"` // revoke every refresh token of the user
+}
+
+// TokenPairResponse carries a short-lived access JWT and the refresh token that renews it
+type TokenPairResponse struct {
+	AccessToken      string    `json:"access_token" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+	TokenType        string    `json:"token_type" example:"Bearer"`
+	ExpiresIn        int       `json:"expires_in" example:"900"` // seconds
+	RefreshToken     string    `json:"refresh_token" example:"42.9f86d081884c7d659a2feaa0c55ad015"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at" example:"2025-08-01T08:00:00Z"`
+}
+
+// Login godoc
+// @Summary Log in and get an access JWT
+// @Description Checks a username and password and returns an access JWT for the `Authorization: Bearer <jwt>` header
+// @Description together with a refresh token. Refresh tokens are single use: each refresh returns a new one.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param credentials body LoginInput true "Username and password"
+// @Success 200 {object} TokenPairResponse
+// @Failure 400 {object} models.ErrorResponse "Invalid input"
+// @Failure 401 {object} models.ErrorResponse "Invalid username or password"
+// @Failure 503 {object} models.ErrorResponse "Bearer auth is not configured"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /auth/login [post]
+func (a *AuthController) Login(c *gin.Context) {
+	var input LoginInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username and password are required"})
+		return
+	}
+	userID, err := models.CheckUserCredentials(input.Username, input.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			recordAuditAs(c, 0, input.Username, audit.ActionLoginFailed, gin.H{"reason": err.Error()})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		log.WithError(err).Error("Failed to check user credentials")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in"})
+		return
+	}
+	cfg := config.MustGet().Config
+	refresh, expires, err := models.IssueRefreshToken(userID, cfg.Server.RefreshTokenTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue refresh token"})
+		return
+	}
+	respondWithTokens(c, audit.ActionTokenIssue, userID, input.Username, refresh, expires)
+}
+
+// RefreshToken godoc
+// @Summary Exchange a refresh token for new tokens
+// @Description Revokes the refresh token and returns a new access JWT and refresh token. Presenting a refresh token
+// @Description that was already exchanged revokes all refresh tokens of its user.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param token body RefreshTokenInput true "Refresh token"
+// @Success 200 {object} TokenPairResponse
+// @Failure 400 {object} models.ErrorResponse "Invalid input"
+// @Failure 401 {object} models.ErrorResponse "Invalid, expired, revoked or reused refresh token"
+// @Failure 503 {object} models.ErrorResponse "Bearer auth is not configured"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /auth/refresh [post]
+func (a *AuthController) RefreshToken(c *gin.Context) {
+	var input RefreshTokenInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "refresh_token is required"})
+		return
+	}
+	cfg := config.MustGet().Config
+	userID, refresh, expires, err := models.RotateRefreshToken(input.RefreshToken, cfg.Server.RefreshTokenTTL)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidRefreshToken) || errors.Is(err, models.ErrRefreshTokenReused) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		log.WithError(err).Error("Failed to rotate refresh token")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		return
+	}
+	user, err := models.LoadAuthUser(userID)
+	if err != nil {
+		_ = models.RevokeUserRefreshTokens(userID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User is inactive or has no role"})
+		return
+	}
+	respondWithTokens(c, audit.ActionTokenRefresh, userID, user.Username, refresh, expires)
+}
+
+// Logout godoc
+// @Summary Revoke refresh tokens
+// @Description Revokes the given refresh token, or with all set every refresh token of its user. Access JWTs stay
+// @Description valid until they expire.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param token body LogoutInput true "Refresh token to revoke"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse "Invalid input"
+// @Failure 401 {object} models.ErrorResponse "Invalid refresh token"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /auth/logout [post]
+func (a *AuthController) Logout(c *gin.Context) {
+	var input LogoutInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "refresh_token is required"})
+		return
+	}
+	userID, err := models.RevokeRefreshToken(input.RefreshToken)
+	if err == nil && input.All {
+		err = models.RevokeUserRefreshTokens(userID)
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidRefreshToken) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		log.WithError(err).Error("Failed to revoke refresh token")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke refresh token"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+// respondWithTokens signs an access JWT and returns it with the refresh token, recording the
+// issue or refresh given by action in the audit log
+func respondWithTokens(c *gin.Context, action string, userID int64, username, refresh string,
+	refreshExpires time.Time) {
+	cfg := config.MustGet().Config
+	access, err := auth.GenerateJWT(strconv.FormatInt(userID, 10), username,
+		[]byte(cfg.Server.JWTSecret), cfg.Server.AccessTokenTTL)
+	if err != nil {
+		log.WithError(err).Error("Failed to sign access token")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue access token"})
+		return
+	}
+	recordAuditAs(c, userID, username, action, gin.H{"token": "jwt", "refreshExpires": refreshExpires})
+	c.JSON(http.StatusOK, TokenPairResponse{
+		AccessToken:      access,
+		TokenType:        "Bearer",
+		ExpiresIn:        int(cfg.Server.AccessTokenTTL.Seconds()),
+		RefreshToken:     refresh,
+		RefreshExpiresAt: refreshExpires,
+	})
+}
@@ -0,0 +1,525 @@
+package controllers
+
+import (
+	"dhis2gw/audit"
+	"dhis2gw/auth"
+	"dhis2gw/config"
+	"dhis2gw/db"
+	"dhis2gw/joblog"
+	"dhis2gw/metrics"
+	"dhis2gw/models"
+	"dhis2gw/tasks"
+	"dhis2gw/utils"
+	goerrors "errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hibiken/asynq"
+	log "github.com/sirupsen/logrus"
+)
+
+// Task states that can be browsed. Archived tasks have used up their retries; retry tasks
+// are waiting for their next attempt.
+const (
+	failedStateArchived = "archived"
+	failedStateRetry    = "retry"
+)
+
+// inspectorPageSize is how many tasks are read from Redis per inspector call
+const inspectorPageSize = 500
+
+type FailedTaskController struct{}
+
+// FailedTaskFilter selects archived or retry tasks. Dates are YYYY-MM-DD or RFC3339 and
+// apply to the time of the last failure. Org units match by UID or source code. Bulk actions
+// refuse a filter that sets none of these fields unless All is set.
+type FailedTaskFilter struct {
+	State    string `json:"state" form:"state" example:"archived"`
+	Type     string `json:"type" form:"type" example:"aggregate:send"`
+	Error    string `json:"error" form:"error" example:"502 Bad Gateway"`
+	DataSet  string `json:"dataSet" form:"dataset" example:"pKxY5g6WgDm"`
+	OrgUnit  string `json:"orgUnit" form:"orgunit" example:"g8xY5g6WgXl"`
+	Source   string `json:"source" form:"source" example:"default"`
+	FromDate string `json:"from_date" form:"from_date" example:"2025-03-01"`
+	ToDate   string `json:"to_date" form:"to_date" example:"2025-03-02"`
+	All      bool   `json:"all,omitempty" form:"-" example:"false"`
+
+	from, to time.Time
+}
+
+// FailedTask is an archived or retry task with the submission it belongs to
+type FailedTask struct {
+	ID            string                `json:"id" example:"c5265e8f-2f15-4090-b25e-303d748adfce"`
+	Queue         string                `json:"queue" example:"dhis2gw:default"`
+	Type          string                `json:"type" example:"aggregate:send"`
+	State         string                `json:"state" example:"archived"`
+	Retried       int                   `json:"retried" example:"3"`
+	MaxRetry      int                   `json:"max_retry" example:"3"`
+	LastError     string                `json:"last_error" example:"502 Bad Gateway"`
+	LastFailedAt  *time.Time            `json:"last_failed_at,omitempty"`
+	NextProcessAt *time.Time            `json:"next_process_at,omitempty"`
+	Summary       tasks.PayloadSummary  `json:"summary"`
+	Submission    *FailedTaskSubmission `json:"submission,omitempty"`
+
+	info *asynq.TaskInfo
+}
+
+// FailedTaskSubmission is the submission_log row of a failed task
+type FailedTaskSubmission struct {
+	ID         int64     `json:"id" example:"1035"`
+	Status     string    `json:"status" example:"failed"`
+	Submitted  time.Time `json:"submitted_at"`
+	RetryCount int       `json:"retry_count" example:"3"`
+	Errors     string    `json:"errors,omitempty"`
+}
+
+type PaginatedFailedTaskResponse models.PaginatedResponse[FailedTask]
+
+// FailedTaskActionResponse reports the outcome of a bulk action on failed tasks. Partial
+// counts bulk groups that were left alone because only some of their blocks match the
+// filter; Forbidden counts tasks whose module the role may not act on.
+type FailedTaskActionResponse struct {
+	Action    string   `json:"action" example:"requeue"`
+	State     string   `json:"state" example:"archived"`
+	Matched   int      `json:"matched" example:"120"`
+	Succeeded int      `json:"succeeded" example:"120"`
+	Failed    int      `json:"failed" example:"0"`
+	Partial   int      `json:"partial" example:"0"`
+	Forbidden int      `json:"forbidden" example:"0"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// GetFailedTasksHandler godoc
+// @Summary List failed tasks
+// @Description Lists archived tasks, which have used up their retries, or tasks waiting to be retried, newest
+// @Description failure first. Each task carries a summary of its payload and its submission log row.
+// @Tags tasks
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param state query string false "archived or retry" default(archived)
+// @Param type query string false "Task type, e.g. aggregate:bulk"
+// @Param error query string false "Text contained in the last error, case-insensitive"
+// @Param dataset query string false "Data set UID"
+// @Param orgunit query string false "Org unit UID or source code"
+// @Param source query string false "Source system"
+// @Param from_date query string false "Last failed on or after"
+// @Param to_date query string false "Last failed on or before"
+// @Param page query int false "Page number" default(1)
+// @Param page_size query int false "Items per page" default(20)
+// @Success 200 {object} PaginatedFailedTaskResponse
+// @Failure 400 {object} models.ErrorResponse "Invalid filter"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /tasks/failed [get]
+func (f *FailedTaskController) GetFailedTasksHandler(c *gin.Context) {
+	var filter FailedTaskFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filter", "detail": err.Error()})
+		return
+	}
+	if err := filter.parse(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filter", "detail": err.Error()})
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = 20
+	}
+
+	inspector := newInspector()
+	defer func() { _ = inspector.Close() }()
+	matched, err := findFailedTasks(inspector, &filter)
+	if err != nil {
+		log.WithError(err).Error("Failed to list failed tasks")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list failed tasks", "detail": err.Error()})
+		return
+	}
+
+	start := (page - 1) * pageSize
+	end := start + pageSize
+	if start > len(matched) {
+		start = len(matched)
+	}
+	if end > len(matched) {
+		end = len(matched)
+	}
+	items := matched[start:end]
+	if err := attachSubmissions(items); err != nil {
+		log.WithError(err).Error("Failed to load submission logs of failed tasks")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load submission logs", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, PaginatedFailedTaskResponse{
+		Items:      items,
+		Total:      int64(len(matched)),
+		Page:       page,
+		TotalPages: int(math.Ceil(float64(len(matched)) / float64(pageSize))),
+		PageSize:   pageSize,
+	})
+}
+
+// RequeueFailedTasksHandler godoc
+// @Summary Requeue failed tasks matching a filter
+// @Description Enqueues a fresh copy of every matching task with a new retry budget, points its submission
+// @Description log at the new task and removes the failed one. Use it to resend everything that failed
+// @Description during a DHIS2 outage. An empty filter is refused unless "all" is true. Bulk groups are only
+// @Description requeued when every block matches the filter.
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param filter body FailedTaskFilter true "Tasks to requeue"
+// @Success 200 {object} FailedTaskActionResponse
+// @Failure 400 {object} models.ErrorResponse "Invalid or empty filter"
+// @Failure 403 {object} models.ErrorResponse "Role may not modify the selected tasks"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /tasks/failed/requeue [post]
+func (f *FailedTaskController) RequeueFailedTasksHandler(c *gin.Context) {
+	asyncClient := c.MustGet("asynqClient").(*asynq.Client)
+	applyToFailedTasks(c, "requeue", auth.PermModify, audit.ActionTaskReEnqueue, func(inspector *asynq.Inspector, info *asynq.TaskInfo) error {
+		_, err := requeueTask(asyncClient, inspector, info)
+		return err
+	})
+}
+
+// ArchiveFailedTasksHandler godoc
+// @Summary Archive retry tasks matching a filter
+// @Description Stops retrying the matching tasks and moves them to the archive, e.g. while DHIS2 is known to be
+// @Description down. Only applies to tasks in the retry state. An empty filter is refused unless "all" is
+// @Description true, and bulk groups are only archived when every block matches the filter.
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param filter body FailedTaskFilter true "Tasks to archive"
+// @Success 200 {object} FailedTaskActionResponse
+// @Failure 400 {object} models.ErrorResponse "Invalid or empty filter"
+// @Failure 403 {object} models.ErrorResponse "Role may not modify the selected tasks"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /tasks/failed/archive [post]
+func (f *FailedTaskController) ArchiveFailedTasksHandler(c *gin.Context) {
+	applyToFailedTasks(c, "archive", auth.PermModify, audit.ActionTaskArchive, func(inspector *asynq.Inspector, info *asynq.TaskInfo) error {
+		return inspector.ArchiveTask(info.Queue, info.ID)
+	})
+}
+
+// DeleteFailedTasksHandler godoc
+// @Summary Delete failed tasks matching a filter
+// @Description Removes the matching tasks from Redis for good. Their submission logs are kept. An empty
+// @Description filter is refused unless "all" is true, and bulk groups are only deleted when every block
+// @Description matches the filter.
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param filter body FailedTaskFilter true "Tasks to delete"
+// @Success 200 {object} FailedTaskActionResponse
+// @Failure 400 {object} models.ErrorResponse "Invalid or empty filter"
+// @Failure 403 {object} models.ErrorResponse "Role may not delete the selected tasks"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /tasks/failed/delete [post]
+func (f *FailedTaskController) DeleteFailedTasksHandler(c *gin.Context) {
+	applyToFailedTasks(c, "delete", auth.PermDelete, audit.ActionTaskDelete, func(inspector *asynq.Inspector, info *asynq.TaskInfo) error {
+		return inspector.DeleteTask(info.Queue, info.ID)
+	})
+}
+
+// applyToFailedTasks runs apply on every task matching the filter in the request body and
+// records the outcome in the audit log. The user needs perms on the module of each task:
+// Aggregate for aggregate tasks, Tracker for tracker tasks.
+func applyToFailedTasks(c *gin.Context, action, perms, auditAction string,
+	apply func(inspector *asynq.Inspector, info *asynq.TaskInfo) error) {
+	var filter FailedTaskFilter
+	if err := c.ShouldBindJSON(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "detail": err.Error()})
+		return
+	}
+	if err := filter.parse(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filter", "detail": err.Error()})
+		return
+	}
+	if action == "archive" && filter.State != failedStateRetry {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filter", "detail": "only retry tasks can be archived"})
+		return
+	}
+	if filter.isEmpty() && !filter.All {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filter",
+			"detail": fmt.Sprintf("refusing to %s every %s task; narrow the filter or send \"all\": true", action, filter.State)})
+		return
+	}
+	user := auth.GetUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	if filter.Type != "" && !user.Can(taskModule(filter.Type), perms) ||
+		!user.Can(auth.ModuleAggregate, perms) && !user.Can(auth.ModuleTracker, perms) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf(
+			"Forbidden - role %q does not grant %s permission on the selected tasks", user.Role, auth.DescribePerms(perms))})
+		return
+	}
+
+	inspector := newInspector()
+	defer func() { _ = inspector.Close() }()
+	matched, err := findFailedTasks(inspector, &filter)
+	if err != nil {
+		log.WithError(err).Error("Failed to list failed tasks")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list failed tasks", "detail": err.Error()})
+		return
+	}
+
+	resp := FailedTaskActionResponse{Action: action, State: filter.State, Matched: len(matched)}
+	for i := range matched {
+		if !user.Can(taskModule(matched[i].Type), perms) {
+			resp.Forbidden++
+			continue
+		}
+		if !filter.matchesAllBlocks(&matched[i]) {
+			resp.Partial++
+			continue
+		}
+		if err := apply(inspector, matched[i].info); err != nil {
+			resp.Failed++
+			resp.Errors = append(resp.Errors, fmt.Sprintf("Task %s: %v", matched[i].ID, err))
+			continue
+		}
+		resp.Succeeded++
+	}
+
+	recordAudit(c, auditAction, gin.H{
+		"filter":    filter,
+		"matched":   resp.Matched,
+		"succeeded": resp.Succeeded,
+		"failed":    resp.Failed,
+		"partial":   resp.Partial,
+		"forbidden": resp.Forbidden,
+	})
+	c.JSON(http.StatusOK, resp)
+}
+
+func (f *FailedTaskFilter) parse() error {
+	switch f.State {
+	case "":
+		f.State = failedStateArchived
+	case failedStateArchived, failedStateRetry:
+	default:
+		return fmt.Errorf("invalid state %q: use archived or retry", f.State)
+	}
+	var err error
+	if f.from, err = parseAuditDate(f.FromDate, false); err != nil {
+		return err
+	}
+	if f.to, err = parseAuditDate(f.ToDate, true); err != nil {
+		return err
+	}
+	return nil
+}
+
+// isEmpty reports whether the filter selects every task in its state
+func (f *FailedTaskFilter) isEmpty() bool {
+	return f.Type == "" && f.Error == "" && f.DataSet == "" && f.OrgUnit == "" && f.Source == "" &&
+		f.FromDate == "" && f.ToDate == ""
+}
+
+func (f *FailedTaskFilter) matches(t *FailedTask) bool {
+	if f.Type != "" && t.Type != f.Type {
+		return false
+	}
+	if f.Error != "" && !strings.Contains(strings.ToLower(t.LastError), strings.ToLower(f.Error)) {
+		return false
+	}
+	if !f.matchesContent(&t.Summary) {
+		return false
+	}
+	if !f.from.IsZero() || !f.to.IsZero() {
+		if t.LastFailedAt == nil {
+			return false
+		}
+		if !f.from.IsZero() && t.LastFailedAt.Before(f.from) {
+			return false
+		}
+		if !f.to.IsZero() && t.LastFailedAt.After(f.to) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesContent reports whether a payload summary holds the filter's data set, org unit
+// and source
+func (f *FailedTaskFilter) matchesContent(s *tasks.PayloadSummary) bool {
+	if f.DataSet != "" && !utils.SliceContains(s.DataSets, f.DataSet) {
+		return false
+	}
+	if f.OrgUnit != "" && !utils.SliceContains(s.OrgUnits, f.OrgUnit) {
+		return false
+	}
+	if f.Source != "" && !utils.SliceContains(s.Sources, f.Source) {
+		return false
+	}
+	return true
+}
+
+// matchesAllBlocks reports whether every block of a bulk group matches the filter's data
+// set, org unit and source. Actions apply to a group as a whole, so a group that also holds
+// other blocks is not acted on. Single submissions always pass.
+func (f *FailedTaskFilter) matchesAllBlocks(t *FailedTask) bool {
+	for i := range t.Summary.BlockSummaries {
+		if !f.matchesContent(&t.Summary.BlockSummaries[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// taskModule is the permission module that governs a task type
+func taskModule(taskType string) string {
+	if taskType == tasks.TypeTracker {
+		return auth.ModuleTracker
+	}
+	return auth.ModuleAggregate
+}
+
+// findFailedTasks reads the tasks in the filter's state from every gateway queue and keeps
+// the matching ones, newest failure first
+func findFailedTasks(inspector *asynq.Inspector, filter *FailedTaskFilter) ([]FailedTask, error) {
+	list := inspector.ListArchivedTasks
+	if filter.State == failedStateRetry {
+		list = inspector.ListRetryTasks
+	}
+
+	matched := make([]FailedTask, 0)
+	for _, queue := range utils.QueueNames(config.MustGet().Config.Server.QueuePrefix) {
+		for page := 1; ; page++ {
+			infos, err := list(queue, asynq.Page(page), asynq.PageSize(inspectorPageSize))
+			if goerrors.Is(err, asynq.ErrQueueNotFound) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("list %s tasks in %s: %w", filter.State, queue, err)
+			}
+			for _, info := range infos {
+				t := newFailedTask(info)
+				if filter.matches(&t) {
+					matched = append(matched, t)
+				}
+			}
+			if len(infos) < inspectorPageSize {
+				break
+			}
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, b := matched[i].LastFailedAt, matched[j].LastFailedAt
+		return a != nil && (b == nil || a.After(*b))
+	})
+	return matched, nil
+}
+
+func newFailedTask(info *asynq.TaskInfo) FailedTask {
+	t := FailedTask{
+		ID:        info.ID,
+		Queue:     info.Queue,
+		Type:      info.Type,
+		State:     info.State.String(),
+		Retried:   info.Retried,
+		MaxRetry:  info.MaxRetry,
+		LastError: info.LastErr,
+		info:      info,
+	}
+	if !info.LastFailedAt.IsZero() {
+		t.LastFailedAt = &info.LastFailedAt
+	}
+	if info.State == asynq.TaskStateRetry && !info.NextProcessAt.IsZero() {
+		t.NextProcessAt = &info.NextProcessAt
+	}
+	summary, err := tasks.SummarizePayload(info.Type, info.Payload)
+	if err != nil {
+		log.WithError(err).WithField("TaskID", info.ID).Warn("Could not summarize task payload")
+	}
+	t.Summary = summary
+	return t
+}
+
+// attachSubmissions loads the submission log rows of the given tasks
+func attachSubmissions(items []FailedTask) error {
+	ids := make([]int64, 0, len(items))
+	for i := range items {
+		if items[i].Summary.LogID != 0 {
+			ids = append(ids, items[i].Summary.LogID)
+		}
+	}
+	logs, err := joblog.GetByIDs(db.GetDB(), ids)
+	if err != nil {
+		return err
+	}
+	for i := range items {
+		jl, ok := logs[items[i].Summary.LogID]
+		if !ok {
+			continue
+		}
+		items[i].Submission = &FailedTaskSubmission{
+			ID:         jl.ID,
+			Status:     jl.Status,
+			Submitted:  jl.Submitted,
+			RetryCount: jl.RetryCount,
+			Errors:     jl.Errors.String,
+		}
+	}
+	return nil
+}
+
+// requeueTask enqueues a fresh copy of a failed task into its own queue, moves its
+// submission log over to the new task and removes the failed one
+func requeueTask(client *asynq.Client, inspector *asynq.Inspector, info *asynq.TaskInfo) (*asynq.TaskInfo, error) {
+	if info.State != asynq.TaskStateArchived && info.State != asynq.TaskStateRetry {
+		return nil, fmt.Errorf("task is %s, not archived or waiting for retry", info.State)
+	}
+	task := asynq.NewTask(info.Type, info.Payload, asynq.MaxRetry(info.MaxRetry))
+	taskInfo, err := client.Enqueue(task, asynq.Queue(info.Queue))
+	if err != nil {
+		return nil, fmt.Errorf("failed to enqueue: %w", err)
+	}
+	metrics.TaskEnqueued(task.Type())
+	if jl, err := joblog.GetByTaskID(db.GetDB(), info.ID); err == nil {
+		_ = jl.UpdateTaskID(taskInfo.ID)
+	}
+	if err := inspector.DeleteTask(info.Queue, info.ID); err != nil {
+		log.WithError(err).WithField("TaskID", info.ID).Warn("Could not remove requeued task")
+	}
+	return taskInfo, nil
+}
+
+// taskQueue resolves the queue a task is looked up in. Older clients pass the task state
+// (dead, retry or archived) instead of a queue; those, like an empty name, mean the
+// default queue. Names without the configured prefix get it added.
+func taskQueue(name string) string {
+	prefix := config.MustGet().Config.Server.QueuePrefix
+	switch name {
+	case "", "dead", failedStateArchived, failedStateRetry:
+		name = "default"
+	}
+	if prefix == "" || strings.HasPrefix(name, prefix+":") {
+		return name
+	}
+	return prefix + ":" + name
+}
+
+func newInspector() *asynq.Inspector {
+	cfg := config.MustGet().Config
+	return asynq.NewInspector(asynq.RedisClientOpt{Addr: cfg.Server.RedisAddress, DB: cfg.Server.RedisDB})
+}
@@ -1,9 +1,11 @@
 package controllers
 
 import (
+	"dhis2gw/audit"
 	"dhis2gw/joblog"
 	"dhis2gw/models"
 	"dhis2gw/utils/dbutils"
+	"encoding/json"
 	"fmt"
 	"github.com/HISP-Uganda/go-dhis2-sdk/utils"
 	"github.com/gin-gonic/gin"
@@ -25,7 +27,7 @@ type JobLogPaginatedResponse models.PaginatedResponse[joblog.JobLogSwagger]
 // @Produce json
 // @Security BasicAuth
 // @Security TokenAuth
-// @Param        status        query     string  false  "Filter by status"
+// @Param        status        query     string  false  "Filter by status, e.g. queued, pending, retrying, success or failed"
 // @Param        task_id       query     string  false  "Filter by task id"
 // @Param        job_id        query     integer false  "Filter by job id"
 // @Param        submitted_at  query     string  false  "Filter by exact submitted_at (RFC3339)"
@@ -134,6 +136,7 @@ func (l *LogsController) GetLogByIdHandler(db *sqlx.DB) gin.HandlerFunc {
 			Response:   utils.StringPtr(log.Response.String),
 			Errors:     utils.StringPtr(log.Errors.String),
 		}
+		_ = json.Unmarshal(log.Attempts, &jl.Attempts)
 
 		c.JSON(http.StatusOK, jl)
 	}
@@ -203,6 +206,7 @@ func (l *LogsController) DeleteSubmissionLogHandler(db *sqlx.DB) gin.HandlerFunc
 			return
 		}
 
+		recordAudit(c, audit.ActionLogDelete, gin.H{"id": id})
 		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Deleted %d log(s)", rowsAffected)})
 	}
 }
@@ -240,6 +244,7 @@ func (l *LogsController) PurgeSubmissionLogsByDateHandler(db *sqlx.DB) gin.Handl
 			return
 		}
 		rows, _ := res.RowsAffected()
+		recordAudit(c, audit.ActionLogPurge, gin.H{"before": cutoff, "deleted": rows})
 		c.JSON(http.StatusOK, gin.H{
 			"message": fmt.Sprintf("Deleted %d logs older than %s", rows, cutoff.Format(time.RFC3339)),
 		})
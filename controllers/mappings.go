@@ -2,13 +2,20 @@ package controllers
 
 import (
 	"bytes"
+	"database/sql"
+	"dhis2gw/audit"
+	"dhis2gw/auth"
 	"dhis2gw/db"
+	"dhis2gw/mappings"
 	"dhis2gw/models"
+	"dhis2gw/tasks"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -120,15 +127,20 @@ type CSVMappingsResponse = models.ImportResponse[models.Dhis2Mapping]
 
 // ImportExcelHandler godoc
 // @Summary Import DHIS2 mappings from Excel
-// @Description Imports DHIS2 mappings from an Excel file
+// @Description Imports DHIS2 mappings from an Excel file and reports per row whether it was created, updated,
+// @Description unchanged, rejected or, in replace-source mode, deleted. With dry_run the report is built the same way
+// @Description for any mode but nothing is kept.
 // @Tags mappings
 // @Accept multipart/form-data
 // @Produce json
 // @Security BasicAuth
 // @Security TokenAuth
 // @Param file formData file true "Excel file containing DHIS2 mappings"
+// @Param mode query string false "insert-only (default), upsert, replace-source or preview (insert-only with dry_run)"
+// @Param dry_run query bool false "Report what the import would do without keeping any change"
+// @Param validate query bool false "Validate the mappings against DHIS2 metadata before importing"
 // @Success 200 {object} CSVMappingsResponse
-// @Failure 400 {object} models.ErrorResponse "Invalid file format"
+// @Failure 400 {object} models.ErrorResponse "Invalid file format or mappings failed validation"
 // @Failure 500 {object} models.ErrorResponse "Server-side error"
 // @Router /mappings/import/excel [post]
 func (m *MappingController) ImportExcelHandler(c *gin.Context) {
@@ -144,32 +156,40 @@ func (m *MappingController) ImportExcelHandler(c *gin.Context) {
 	}
 	defer file.Close()
 
+	mode, err := models.ParseMappingImportMode(c.Query("mode"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	records, err := models.ParseDhis2MappingExcel(file)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse Excel", "details": err.Error()})
 		return
 	}
-	importError := models.BulkInsertMappings(records)
-	if importError != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import mappings", "details": importError.Error()})
+	if c.Query("validate") == "true" && !validateImportedMappings(c, records) {
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"total": len(records), "records": records})
+	m.importMappings(c, mode, "excel", records)
 }
 
 type ExcelImportResponse = models.ImportResponse[models.Dhis2Mapping]
 
 // ImportCSVHandler godoc
 // @Summary Import DHIS2 mappings from CSV
-// @Description Imports DHIS2 mappings from a CSV file
+// @Description Imports DHIS2 mappings from a CSV file and reports per row whether it was created, updated,
+// @Description unchanged, rejected or, in replace-source mode, deleted. With dry_run the report is built the same way
+// @Description for any mode but nothing is kept.
 // @Tags mappings
 // @Accept multipart/form-data
 // @Produce json
 // @Security BasicAuth
 // @Security TokenAuth
 // @Param file formData file true "CSV file containing DHIS2 mappings"
+// @Param mode query string false "insert-only (default), upsert, replace-source or preview (insert-only with dry_run)"
+// @Param dry_run query bool false "Report what the import would do without keeping any change"
+// @Param validate query bool false "Validate the mappings against DHIS2 metadata before importing"
 // @Success 200 {object} ExcelImportResponse
-// @Failure 400 {object} models.ErrorResponse "Invalid file format"
+// @Failure 400 {object} models.ErrorResponse "Invalid file format or mappings failed validation"
 // @Failure 500 {object} models.ErrorResponse "Server-side error"
 // @Router /mappings/import/csv [post]
 func (m *MappingController) ImportCSVHandler(c *gin.Context) {
@@ -185,17 +205,20 @@ func (m *MappingController) ImportCSVHandler(c *gin.Context) {
 	}
 	defer file.Close()
 
+	mode, err := models.ParseMappingImportMode(c.Query("mode"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	records, err := models.ParseDhis2MappingCSV(file)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse CSV", "details": err.Error()})
 		return
 	}
-	importError := models.BulkInsertMappings(records)
-	if importError != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import mappings", "details": importError.Error()})
+	if c.Query("validate") == "true" && !validateImportedMappings(c, records) {
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"total": len(records), "records": records})
+	m.importMappings(c, mode, "csv", records)
 }
 
 // ExportExcelTemplateHandler godoc
@@ -254,3 +277,623 @@ func (m *MappingController) ExportExcelMappingsHandler(c *gin.Context) {
 	}
 	c.Data(http.StatusOK, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", buf.Bytes())
 }
+
+// importMappings applies the parsed rows and writes the per-row import report
+func (m *MappingController) importMappings(
+	c *gin.Context, mode models.MappingImportMode, source string, records []models.Dhis2Mapping) {
+	dryRun := c.Query("dry_run") == "true"
+	resp, err := models.ImportMappings(records, mode, dryRun, currentUserID(c), source)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import mappings", "details": err.Error()})
+		return
+	}
+	if !resp.DryRun {
+		reloadMappingCaches(c)
+		recordAudit(c, audit.ActionMappingImport, gin.H{
+			"source":    source,
+			"mode":      mode,
+			"rows":      len(records),
+			"summary":   resp.Summary,
+			"changeSet": resp.ChangeSet,
+		})
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// validateImportedMappings checks parsed import records against DHIS2, writing a 400 with
+// the validation report when any of them is invalid
+func validateImportedMappings(c *gin.Context, records []models.Dhis2Mapping) bool {
+	report, err := mappings.Validate(c.Request.Context(), tasks.ClientForInstance, records)
+	if err != nil {
+		log.WithError(err).Error("Failed to validate imported mappings")
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to fetch DHIS2 metadata", "details": err.Error()})
+		return false
+	}
+	if report.Invalid > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Some mappings failed validation", "detail": report})
+		return false
+	}
+	return true
+}
+
+// MappingValidationRequest selects the mappings to validate. An empty body validates all mappings.
+type MappingValidationRequest struct {
+	UIDs     []string `json:"uids,omitempty"`
+	Instance string   `json:"instance,omitempty" example:"default"`
+}
+
+// ValidateMappingsHandler godoc
+// @Summary Validate mappings against DHIS2
+// @Description Checks that the data sets, data elements, category combos, category option combos and org units
+// @Description referenced by the mappings exist in their DHIS2 instance and belong together
+// @Tags mappings
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param request body MappingValidationRequest false "Mappings to validate"
+// @Success 200 {object} mappings.ValidationReport
+// @Failure 400 {object} models.ErrorResponse "Invalid JSON"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Failure 502 {object} models.ErrorResponse "DHIS2 metadata could not be fetched"
+// @Router /mappings/validate [post]
+func (m *MappingController) ValidateMappingsHandler(c *gin.Context) {
+	var req MappingValidationRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+			return
+		}
+	}
+
+	var (
+		ms  []models.Dhis2Mapping
+		err error
+	)
+	if len(req.UIDs) > 0 {
+		ms, err = models.GetMappingsByUIDs(req.UIDs)
+	} else {
+		ms, err = models.GetAllMappings()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch mappings"})
+		return
+	}
+	if req.Instance != "" {
+		selected := ms[:0]
+		for _, mapping := range ms {
+			if mapping.InstanceName == req.Instance {
+				selected = append(selected, mapping)
+			}
+		}
+		ms = selected
+	}
+
+	report, err := mappings.Validate(c.Request.Context(), tasks.ClientForInstance, ms)
+	if err != nil {
+		log.WithError(err).Error("Failed to validate mappings")
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to fetch DHIS2 metadata", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// currentUserID returns the id of the authenticated user, or 0 when there is none
+func currentUserID(c *gin.Context) int64 {
+	if user := auth.GetUser(c); user != nil {
+		return user.ID
+	}
+	return 0
+}
+
+// reloadMappingCaches refreshes in-memory mapping caches after a mapping change
+func reloadMappingCaches(c *gin.Context) {
+	if err := mappings.ReloadAll(c.Request.Context()); err != nil {
+		log.WithError(err).Warn("Failed to reload mapping caches")
+	}
+}
+
+// mappingFromParam loads the mapping named by the :uid path parameter, writing a 404 when absent
+func mappingFromParam(c *gin.Context) (*models.Dhis2Mapping, bool) {
+	mapping, err := models.GetMappingByUID(c.Param("uid"))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Mapping not found"})
+		return nil, false
+	}
+	if err != nil {
+		log.WithError(err).Error("Failed to fetch mapping")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch mapping"})
+		return nil, false
+	}
+	return mapping, true
+}
+
+// GetMappingHandler godoc
+// @Summary Get a mapping
+// @Description Returns a single DHIS2 mapping by UID
+// @Tags mappings
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param uid path string true "Mapping UID"
+// @Success 200 {object} models.Dhis2Mapping
+// @Failure 404 {object} models.ErrorResponse "Mapping not found"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /mappings/{uid} [get]
+func (m *MappingController) GetMappingHandler(c *gin.Context) {
+	mapping, ok := mappingFromParam(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, mapping)
+}
+
+// CreateMappingHandler godoc
+// @Summary Create a mapping
+// @Description Creates a DHIS2 mapping
+// @Tags mappings
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param mapping body models.Dhis2Mapping true "Mapping"
+// @Success 201 {object} models.Dhis2Mapping
+// @Failure 400 {object} models.ErrorResponse "Invalid mapping"
+// @Failure 409 {object} models.ErrorResponse "Mapping already exists"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /mappings [post]
+func (m *MappingController) CreateMappingHandler(c *gin.Context) {
+	var mapping models.Dhis2Mapping
+	if err := c.ShouldBindJSON(&mapping); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+	if err := mapping.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	id, err := mapping.Insert(currentUserID(c))
+	if errors.Is(err, models.ErrMappingExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create mapping"})
+		return
+	}
+	created, err := models.GetMappingByID(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch created mapping"})
+		return
+	}
+	reloadMappingCaches(c)
+	c.JSON(http.StatusCreated, created)
+}
+
+// UpdateMappingHandler godoc
+// @Summary Replace a mapping
+// @Description Replaces all fields of a DHIS2 mapping
+// @Tags mappings
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param uid path string true "Mapping UID"
+// @Param mapping body models.Dhis2Mapping true "Mapping"
+// @Success 200 {object} models.Dhis2Mapping
+// @Failure 400 {object} models.ErrorResponse "Invalid mapping"
+// @Failure 404 {object} models.ErrorResponse "Mapping not found"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /mappings/{uid} [put]
+func (m *MappingController) UpdateMappingHandler(c *gin.Context) {
+	existing, ok := mappingFromParam(c)
+	if !ok {
+		return
+	}
+	var mapping models.Dhis2Mapping
+	if err := c.ShouldBindJSON(&mapping); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+	m.saveMapping(c, existing, &mapping)
+}
+
+// PatchMappingHandler godoc
+// @Summary Update a mapping
+// @Description Updates only the fields present in the body
+// @Tags mappings
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param uid path string true "Mapping UID"
+// @Param mapping body models.Dhis2Mapping true "Fields to change"
+// @Success 200 {object} models.Dhis2Mapping
+// @Failure 400 {object} models.ErrorResponse "Invalid mapping"
+// @Failure 404 {object} models.ErrorResponse "Mapping not found"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /mappings/{uid} [patch]
+func (m *MappingController) PatchMappingHandler(c *gin.Context) {
+	existing, ok := mappingFromParam(c)
+	if !ok {
+		return
+	}
+	mapping := *existing
+	if err := c.ShouldBindJSON(&mapping); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+	m.saveMapping(c, existing, &mapping)
+}
+
+func (m *MappingController) saveMapping(c *gin.Context, existing, mapping *models.Dhis2Mapping) {
+	mapping.ID, mapping.UID = existing.ID, existing.UID
+	if err := mapping.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := mapping.Update(currentUserID(c)); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			c.JSON(http.StatusConflict, gin.H{"error": models.ErrMappingExists.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update mapping"})
+		return
+	}
+	updated, err := models.GetMappingByID(existing.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch updated mapping"})
+		return
+	}
+	reloadMappingCaches(c)
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteMappingHandler godoc
+// @Summary Delete a mapping
+// @Description Deletes a DHIS2 mapping and its dimensions
+// @Tags mappings
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param uid path string true "Mapping UID"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 404 {object} models.ErrorResponse "Mapping not found"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /mappings/{uid} [delete]
+func (m *MappingController) DeleteMappingHandler(c *gin.Context) {
+	mapping, ok := mappingFromParam(c)
+	if !ok {
+		return
+	}
+	if err := mapping.Delete(currentUserID(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete mapping"})
+		return
+	}
+	reloadMappingCaches(c)
+	c.JSON(http.StatusOK, gin.H{"message": "Mapping deleted successfully"})
+}
+
+// GetMappingDimensionsHandler godoc
+// @Summary List mapping dimensions
+// @Description Returns the dimensions of a DHIS2 mapping
+// @Tags mappings
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param uid path string true "Mapping UID"
+// @Success 200 {array} models.Dhis2MappingDimension
+// @Failure 404 {object} models.ErrorResponse "Mapping not found"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /mappings/{uid}/dimensions [get]
+func (m *MappingController) GetMappingDimensionsHandler(c *gin.Context) {
+	mapping, ok := mappingFromParam(c)
+	if !ok {
+		return
+	}
+	dims, err := models.GetMappingDimensions([]int64{mapping.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch mapping dimensions"})
+		return
+	}
+	items := dims[mapping.ID]
+	if items == nil {
+		items = []models.Dhis2MappingDimension{}
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+// CreateMappingDimensionHandler godoc
+// @Summary Add a mapping dimension
+// @Description Adds a dimension to a DHIS2 mapping. The type defaults to attribution. All dimensions of one
+// @Description type on a mapping must share a dimension group.
+// @Tags mappings
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param uid path string true "Mapping UID"
+// @Param dimension body models.Dhis2MappingDimension true "Dimension"
+// @Success 201 {object} models.Dhis2MappingDimension
+// @Failure 400 {object} models.ErrorResponse "Invalid dimension"
+// @Failure 404 {object} models.ErrorResponse "Mapping not found"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /mappings/{uid}/dimensions [post]
+func (m *MappingController) CreateMappingDimensionHandler(c *gin.Context) {
+	mapping, ok := mappingFromParam(c)
+	if !ok {
+		return
+	}
+	var dim models.Dhis2MappingDimension
+	if err := c.ShouldBindJSON(&dim); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+	dim.MappingID = mapping.ID
+	if dim.Type == "" {
+		dim.Type = models.DimensionTypeAttribution
+	}
+	if err := dim.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err := dim.Insert(currentUserID(c))
+	switch {
+	case errors.Is(err, models.ErrDimensionGroupConflict):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create mapping dimension"})
+		return
+	}
+	reloadMappingCaches(c)
+	c.JSON(http.StatusCreated, dim)
+}
+
+// dimensionFromParams loads the dimension named by the :id path parameter of a mapping
+func dimensionFromParams(c *gin.Context, mapping *models.Dhis2Mapping) (*models.Dhis2MappingDimension, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dimension id"})
+		return nil, false
+	}
+	dim, err := models.GetMappingDimension(mapping.ID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Mapping dimension not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch mapping dimension"})
+		return nil, false
+	}
+	return dim, true
+}
+
+// UpdateMappingDimensionHandler godoc
+// @Summary Replace a mapping dimension
+// @Description Replaces a dimension of a DHIS2 mapping
+// @Tags mappings
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param uid path string true "Mapping UID"
+// @Param id path int true "Dimension ID"
+// @Param dimension body models.Dhis2MappingDimension true "Dimension"
+// @Success 200 {object} models.Dhis2MappingDimension
+// @Failure 400 {object} models.ErrorResponse "Invalid dimension"
+// @Failure 404 {object} models.ErrorResponse "Mapping or dimension not found"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /mappings/{uid}/dimensions/{id} [put]
+func (m *MappingController) UpdateMappingDimensionHandler(c *gin.Context) {
+	mapping, ok := mappingFromParam(c)
+	if !ok {
+		return
+	}
+	existing, ok := dimensionFromParams(c, mapping)
+	if !ok {
+		return
+	}
+	var dim models.Dhis2MappingDimension
+	if err := c.ShouldBindJSON(&dim); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+	dim.ID, dim.MappingID = existing.ID, mapping.ID
+	if dim.Type == "" {
+		dim.Type = models.DimensionTypeAttribution
+	}
+	if err := dim.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err := dim.Update(currentUserID(c))
+	switch {
+	case errors.Is(err, models.ErrDimensionGroupConflict):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update mapping dimension"})
+		return
+	}
+	updated, err := models.GetMappingDimension(mapping.ID, existing.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch updated mapping dimension"})
+		return
+	}
+	reloadMappingCaches(c)
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteMappingDimensionHandler godoc
+// @Summary Delete a mapping dimension
+// @Description Removes a dimension from a DHIS2 mapping
+// @Tags mappings
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param uid path string true "Mapping UID"
+// @Param id path int true "Dimension ID"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 404 {object} models.ErrorResponse "Mapping or dimension not found"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /mappings/{uid}/dimensions/{id} [delete]
+func (m *MappingController) DeleteMappingDimensionHandler(c *gin.Context) {
+	mapping, ok := mappingFromParam(c)
+	if !ok {
+		return
+	}
+	dim, ok := dimensionFromParams(c, mapping)
+	if !ok {
+		return
+	}
+	if err := dim.Delete(currentUserID(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete mapping dimension"})
+		return
+	}
+	reloadMappingCaches(c)
+	c.JSON(http.StatusOK, gin.H{"message": "Mapping dimension deleted successfully"})
+}
+
+type MappingChangeSetsResponse = models.PaginatedResponse[models.MappingChangeSet]
+
+// GetMappingChangeSetsHandler godoc
+// @Summary List mapping change sets
+// @Description Returns the groups of mapping changes made by API calls, imports and rollbacks, newest first
+// @Tags mappings
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param page query int false "Page number (default 1)"
+// @Param page_size query int false "Items per page (default 10)"
+// @Success 200 {object} MappingChangeSetsResponse
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /mappings/changesets [get]
+func (m *MappingController) GetMappingChangeSetsHandler(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = 10
+	}
+	sets, total, err := models.GetMappingChangeSets(page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch mapping change sets"})
+		return
+	}
+	c.JSON(http.StatusOK, MappingChangeSetsResponse{
+		Items:      sets,
+		Total:      total,
+		Page:       page,
+		TotalPages: int((total + int64(pageSize) - 1) / int64(pageSize)),
+		PageSize:   pageSize,
+	})
+}
+
+// MappingChangeSetResponse is a change set with its recorded changes
+type MappingChangeSetResponse struct {
+	ChangeSet *models.MappingChangeSet     `json:"changeSet"`
+	Changes   []models.MappingHistoryEntry `json:"changes"`
+}
+
+// GetMappingChangeSetHandler godoc
+// @Summary Get a mapping change set
+// @Description Returns a change set with the old and new values of every mapping it changed
+// @Tags mappings
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param uid path string true "Change set UID"
+// @Success 200 {object} MappingChangeSetResponse
+// @Failure 404 {object} models.ErrorResponse "Change set not found"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /mappings/changesets/{uid} [get]
+func (m *MappingController) GetMappingChangeSetHandler(c *gin.Context) {
+	cs, changes, err := models.GetMappingChangeSet(c.Param("uid"))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Change set not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch mapping change set"})
+		return
+	}
+	c.JSON(http.StatusOK, MappingChangeSetResponse{ChangeSet: cs, Changes: changes})
+}
+
+// RollbackMappingChangeSetHandler godoc
+// @Summary Roll back a mapping change set
+// @Description Reverts all changes of a change set in one transaction and records the reversal as a new change set.
+// @Description Fails without changing anything if any affected mapping was changed again afterwards.
+// @Tags mappings
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param uid path string true "Change set UID"
+// @Success 200 {object} models.MappingChangeSet
+// @Failure 404 {object} models.ErrorResponse "Change set not found"
+// @Failure 409 {object} models.ErrorResponse "Already rolled back or mappings changed since"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /mappings/changesets/{uid}/rollback [post]
+func (m *MappingController) RollbackMappingChangeSetHandler(c *gin.Context) {
+	cs, err := models.RollbackMappingChangeSet(c.Param("uid"), currentUserID(c))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Change set not found"})
+		return
+	case errors.Is(err, models.ErrChangeSetRolledBack), errors.Is(err, models.ErrRollbackConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		log.WithError(err).Error("Failed to roll back mapping change set")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to roll back mapping change set"})
+		return
+	}
+	reloadMappingCaches(c)
+	if cs == nil {
+		c.JSON(http.StatusOK, gin.H{"message": "Change set had no changes to roll back"})
+		return
+	}
+	c.JSON(http.StatusOK, cs)
+}
+
+// DiffMappingsHandler godoc
+// @Summary Diff mappings between two points in time
+// @Description Lists the mappings that were created, updated or deleted between from and to
+// @Tags mappings
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param from query string true "Start time (RFC3339 format)"
+// @Param to query string false "End time (RFC3339 format, default now)"
+// @Success 200 {array} models.MappingStateDiff
+// @Failure 400 {object} models.ErrorResponse "Invalid time range"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /mappings/history/diff [get]
+func (m *MappingController) DiffMappingsHandler(c *gin.Context) {
+	from, err := time.Parse(time.RFC3339, c.Query("from"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or missing from date"})
+		return
+	}
+	to := time.Now()
+	if v := c.Query("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date format"})
+			return
+		}
+	}
+	if to.Before(from) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to must not be before from"})
+		return
+	}
+	diffs, err := models.DiffMappings(from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to diff mappings"})
+		return
+	}
+	c.JSON(http.StatusOK, diffs)
+}
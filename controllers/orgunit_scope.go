@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"dhis2gw/auth"
+	"dhis2gw/config"
+	"dhis2gw/joblog"
+	"dhis2gw/models"
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// orgUnitScopeError returns why the current user may not write data to the given org units,
+// or "" when every one of them is, or descends from, an org unit assigned to the user.
+// Administrators are not restricted. Users without assignments are refused, unless their
+// role is listed in api.orgunit_scope_exempt_roles.
+func orgUnitScopeError(c *gin.Context, orgUnits ...string) (string, error) {
+	user := auth.GetUser(c)
+	if user == nil || user.IsAdmin {
+		return "", nil
+	}
+	if len(user.OrgUnits) == 0 {
+		if orgUnitScopeExempt(user.Role) {
+			return "", nil
+		}
+		return fmt.Sprintf("User %s has no assigned org units", user.Username), nil
+	}
+
+	paths, err := models.GetOrgUnitPaths(orgUnits)
+	if err != nil {
+		return "", err
+	}
+	var outside []string
+	for _, ou := range orgUnits {
+		if path, ok := paths[ou]; !ok || !user.CoversOrgUnitPath(path) {
+			outside = append(outside, ou)
+		}
+	}
+	if len(outside) > 0 {
+		return fmt.Sprintf("Org units outside the scope of user %s: %s", user.Username,
+			strings.Join(outside, ", ")), nil
+	}
+	return "", nil
+}
+
+// orgUnitScopeExempt reports whether role is listed in api.orgunit_scope_exempt_roles
+func orgUnitScopeExempt(role string) bool {
+	for _, r := range strings.Split(config.MustGet().Config.API.OrgUnitScopeExemptRoles, ",") {
+		if r = strings.TrimSpace(r); r != "" && strings.EqualFold(r, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// logRejectedSubmission records a submission refused for the user's org unit scope
+func logRejectedSubmission(c *gin.Context, db *sqlx.DB, payload interface{}, reason string) {
+	if _, err := joblog.NewRejected(db, payload, currentUserID(c), reason); err != nil {
+		log.Errorf("Could not log rejected submission: %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+package controllers
+
+import (
+	"database/sql"
+	"dhis2gw/audit"
+	"dhis2gw/models"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RoleController struct{}
+
+// UserRoleInput assigns a role to a user
+type UserRoleInput struct {
+	RoleID int64 `json:"role_id" example:"2"`
+}
+
+// GetRolesHandler godoc
+// @Summary List roles
+// @Description Returns all user roles with their permissions per module. Permission letters are r (read), m (modify),
+// @Description a (add) and d (delete).
+// @Tags roles
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Success 200 {array} models.Role
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /roles [get]
+func (r *RoleController) GetRolesHandler(c *gin.Context) {
+	roles, err := models.GetRoles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch roles"})
+		return
+	}
+	c.JSON(http.StatusOK, roles)
+}
+
+// GetRoleHandler godoc
+// @Summary Get a role
+// @Description Returns a user role with its permissions per module
+// @Tags roles
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param id path int true "Role ID"
+// @Success 200 {object} models.Role
+// @Failure 404 {object} models.ErrorResponse "Role not found"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /roles/{id} [get]
+func (r *RoleController) GetRoleHandler(c *gin.Context) {
+	id, ok := roleIDFromParam(c)
+	if !ok {
+		return
+	}
+	role, err := models.GetRole(id)
+	if err != nil {
+		respondRoleError(c, err, "Failed to fetch role")
+		return
+	}
+	c.JSON(http.StatusOK, role)
+}
+
+// CreateRoleHandler godoc
+// @Summary Create a role
+// @Description Creates a user role with permissions per module, e.g. {"Mappings": "ra"}
+// @Tags roles
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param role body models.RoleInput true "Role"
+// @Success 201 {object} models.Role
+// @Failure 400 {object} models.ErrorResponse "Invalid role"
+// @Failure 409 {object} models.ErrorResponse "Role name already taken"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /roles [post]
+func (r *RoleController) CreateRoleHandler(c *gin.Context) {
+	var input models.RoleInput
+	if !bindRoleInput(c, &input) {
+		return
+	}
+	role, err := models.CreateRole(input)
+	if err != nil {
+		respondRoleError(c, err, "Failed to create role")
+		return
+	}
+	recordAudit(c, audit.ActionRoleCreate, gin.H{"id": role.ID, "name": role.Name, "permissions": role.Permissions})
+	c.JSON(http.StatusCreated, role)
+}
+
+// UpdateRoleHandler godoc
+// @Summary Update a role
+// @Description Updates the name and description of a role. When permissions are given they replace all permissions
+// @Description of the role; modules left out lose their permissions.
+// @Tags roles
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param id path int true "Role ID"
+// @Param role body models.RoleInput true "Role"
+// @Success 200 {object} models.Role
+// @Failure 400 {object} models.ErrorResponse "Invalid role"
+// @Failure 404 {object} models.ErrorResponse "Role not found"
+// @Failure 409 {object} models.ErrorResponse "Role name already taken"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /roles/{id} [put]
+func (r *RoleController) UpdateRoleHandler(c *gin.Context) {
+	id, ok := roleIDFromParam(c)
+	if !ok {
+		return
+	}
+	var input models.RoleInput
+	if !bindRoleInput(c, &input) {
+		return
+	}
+	role, err := models.UpdateRole(id, input)
+	if err != nil {
+		respondRoleError(c, err, "Failed to update role")
+		return
+	}
+	recordAudit(c, audit.ActionRoleUpdate, gin.H{"id": role.ID, "name": role.Name, "permissions": role.Permissions})
+	c.JSON(http.StatusOK, role)
+}
+
+// DeleteRoleHandler godoc
+// @Summary Delete a role
+// @Description Deletes a user role and its permissions. Roles still assigned to users cannot be deleted.
+// @Tags roles
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param id path int true "Role ID"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 404 {object} models.ErrorResponse "Role not found"
+// @Failure 409 {object} models.ErrorResponse "Role is assigned to users"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /roles/{id} [delete]
+func (r *RoleController) DeleteRoleHandler(c *gin.Context) {
+	id, ok := roleIDFromParam(c)
+	if !ok {
+		return
+	}
+	if err := models.DeleteRole(id); err != nil {
+		respondRoleError(c, err, "Failed to delete role")
+		return
+	}
+	recordAudit(c, audit.ActionRoleDelete, gin.H{"id": id})
+	c.JSON(http.StatusOK, gin.H{"message": "Role deleted successfully"})
+}
+
+// SetUserRoleHandler godoc
+// @Summary Assign a role to a user
+// @Description Replaces the role of a user, and with it the user's permissions
+// @Tags roles
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param uid path string true "User UID"
+// @Param role body UserRoleInput true "Role to assign"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse "Invalid input or unknown role"
+// @Failure 404 {object} models.ErrorResponse "User not found"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /users/{uid}/role [put]
+func (r *RoleController) SetUserRoleHandler(c *gin.Context) {
+	var input UserRoleInput
+	if err := c.ShouldBindJSON(&input); err != nil || input.RoleID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role_id is required"})
+		return
+	}
+	err := models.SetUserRole(c.Param("uid"), input.RoleID)
+	switch {
+	case errors.Is(err, models.ErrRoleNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, sql.ErrNoRows):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign role"})
+	default:
+		recordAudit(c, audit.ActionUserRole, gin.H{"uid": c.Param("uid"), "roleId": input.RoleID})
+		c.JSON(http.StatusOK, gin.H{"message": "Role assigned successfully"})
+	}
+}
+
+func roleIDFromParam(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role id"})
+		return 0, false
+	}
+	return id, true
+}
+
+func bindRoleInput(c *gin.Context, input *models.RoleInput) bool {
+	if err := c.ShouldBindJSON(input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return false
+	}
+	if err := input.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+func respondRoleError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, models.ErrRoleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrRoleExists), errors.Is(err, models.ErrRoleInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
@@ -0,0 +1,268 @@
+package controllers
+
+import (
+	"dhis2gw/audit"
+	"dhis2gw/models"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ServerController struct{}
+
+// PaginatedServerResponse is a page of servers; passwords and auth tokens are left out
+type PaginatedServerResponse models.PaginatedResponse[map[string]any]
+
+// GetServersHandler godoc
+// @Summary List servers
+// @Description Returns a page of the registered servers, the source systems and DHIS2 or other destinations the
+// @Description gateway talks to, ordered by name. Passwords and auth tokens are never returned.
+// @Tags servers
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param page query int false "Page number" default(1)
+// @Param page_size query int false "Items per page" default(20)
+// @Success 200 {object} PaginatedServerResponse
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /servers [get]
+func (s *ServerController) GetServersHandler(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = 20
+	}
+	servers, total, err := models.ListServers(page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch servers"})
+		return
+	}
+	items := make([]map[string]any, len(servers))
+	for i := range servers {
+		items[i] = servers[i].Public()
+	}
+	c.JSON(http.StatusOK, PaginatedServerResponse{
+		Items:      items,
+		Total:      total,
+		Page:       page,
+		TotalPages: int(math.Ceil(float64(total) / float64(pageSize))),
+		PageSize:   pageSize,
+	})
+}
+
+// GetServerHandler godoc
+// @Summary Get a server
+// @Description Returns a registered server with the names of the servers allowed to send requests to it
+// @Tags servers
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param uid path string true "Server UID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} models.ErrorResponse "Server not found"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /servers/{uid} [get]
+func (s *ServerController) GetServerHandler(c *gin.Context) {
+	srv, err := models.GetServerByUID(c.Param("uid"))
+	respondServer(c, http.StatusOK, srv, err, "Failed to fetch server")
+}
+
+// CreateServerHandler godoc
+// @Summary Register a server
+// @Description Registers a source system or destination. name, URL and HTTPMethod are required, and allowedSources
+// @Description names the servers that may send requests to it. The server cache is refreshed right away.
+// @Tags servers
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param server body map[string]interface{} true "Server definition"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} models.ErrorResponse "Invalid server or unknown allowed sources"
+// @Failure 409 {object} models.ErrorResponse "A server with this name already exists"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /servers [post]
+func (s *ServerController) CreateServerHandler(c *gin.Context) {
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+	srv, err := models.CreateServer(body)
+	auditServer(c, audit.ActionServerCreate, srv, err)
+	respondServer(c, http.StatusCreated, srv, err, "Failed to create server")
+}
+
+// UpdateServerHandler godoc
+// @Summary Update a server
+// @Description Updates the fields present in the body and leaves the others unchanged. allowedSources, when given,
+// @Description replaces the allowed sources. The server cache is refreshed right away.
+// @Tags servers
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param uid path string true "Server UID"
+// @Param server body map[string]interface{} true "Server fields to change"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} models.ErrorResponse "Invalid server or unknown allowed sources"
+// @Failure 404 {object} models.ErrorResponse "Server not found"
+// @Failure 409 {object} models.ErrorResponse "A server with this name already exists"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /servers/{uid} [put]
+func (s *ServerController) UpdateServerHandler(c *gin.Context) {
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+	srv, err := models.UpdateServer(c.Param("uid"), body)
+	auditServer(c, audit.ActionServerUpdate, srv, err)
+	respondServer(c, http.StatusOK, srv, err, "Failed to update server")
+}
+
+// DeleteServerHandler godoc
+// @Summary Delete a server
+// @Description Deletes a server and removes it from the allowed sources of other servers. Servers that requests or
+// @Description schedules refer to cannot be deleted; suspend them instead.
+// @Tags servers
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param uid path string true "Server UID"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 404 {object} models.ErrorResponse "Server not found"
+// @Failure 409 {object} models.ErrorResponse "Server is in use"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /servers/{uid} [delete]
+func (s *ServerController) DeleteServerHandler(c *gin.Context) {
+	if err := models.DeleteServer(c.Param("uid")); err != nil {
+		respondServerError(c, err, "Failed to delete server")
+		return
+	}
+	recordAudit(c, audit.ActionServerDelete, gin.H{"uid": c.Param("uid")})
+	c.JSON(http.StatusOK, gin.H{"message": "Server deleted successfully"})
+}
+
+// SuspendServerHandler godoc
+// @Summary Suspend a server
+// @Description Marks a server as suspended so that no requests are sent to it until it is resumed
+// @Tags servers
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param uid path string true "Server UID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} models.ErrorResponse "Server not found"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /servers/{uid}/suspend [post]
+func (s *ServerController) SuspendServerHandler(c *gin.Context) {
+	srv, err := models.SetServerSuspended(c.Param("uid"), true)
+	auditServer(c, audit.ActionServerSuspend, srv, err)
+	respondServer(c, http.StatusOK, srv, err, "Failed to suspend server")
+}
+
+// ResumeServerHandler godoc
+// @Summary Resume a server
+// @Description Lifts the suspension of a server
+// @Tags servers
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param uid path string true "Server UID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} models.ErrorResponse "Server not found"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /servers/{uid}/resume [post]
+func (s *ServerController) ResumeServerHandler(c *gin.Context) {
+	srv, err := models.SetServerSuspended(c.Param("uid"), false)
+	auditServer(c, audit.ActionServerResume, srv, err)
+	respondServer(c, http.StatusOK, srv, err, "Failed to resume server")
+}
+
+// GetServerSourcesHandler godoc
+// @Summary List the allowed sources of a server
+// @Description Returns the names of the servers allowed to send requests to a server
+// @Tags servers
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param uid path string true "Server UID"
+// @Success 200 {object} models.ServerSourcesInput
+// @Failure 404 {object} models.ErrorResponse "Server not found"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /servers/{uid}/sources [get]
+func (s *ServerController) GetServerSourcesHandler(c *gin.Context) {
+	srv, err := models.GetServerByUID(c.Param("uid"))
+	if err != nil {
+		respondServerError(c, err, "Failed to fetch server")
+		return
+	}
+	c.JSON(http.StatusOK, models.ServerSourcesInput{AllowedSources: srv.AllowedSources()})
+}
+
+// SetServerSourcesHandler godoc
+// @Summary Replace the allowed sources of a server
+// @Description Replaces the servers allowed to send requests to a server, given by name. An empty list allows none.
+// @Tags servers
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param uid path string true "Server UID"
+// @Param sources body models.ServerSourcesInput true "Names of the allowed source servers"
+// @Success 200 {object} models.ServerSourcesInput
+// @Failure 400 {object} models.ErrorResponse "Invalid JSON or unknown servers"
+// @Failure 404 {object} models.ErrorResponse "Server not found"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /servers/{uid}/sources [put]
+func (s *ServerController) SetServerSourcesHandler(c *gin.Context) {
+	var input models.ServerSourcesInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+	srv, err := models.SetServerSources(c.Param("uid"), input.AllowedSources)
+	if err != nil {
+		respondServerError(c, err, "Failed to set allowed sources")
+		return
+	}
+	recordAudit(c, audit.ActionServerSources, gin.H{
+		"uid": srv.UID(), "name": srv.Name(), "allowedSources": srv.AllowedSources()})
+	c.JSON(http.StatusOK, models.ServerSourcesInput{AllowedSources: srv.AllowedSources()})
+}
+
+// auditServer records a successful change to a server; its password and auth token are left out
+func auditServer(c *gin.Context, action string, srv models.Server, err error) {
+	if err != nil {
+		return
+	}
+	recordAudit(c, action, gin.H{"uid": srv.UID(), "name": srv.Name(), "suspended": srv.Suspended()})
+}
+
+func respondServer(c *gin.Context, status int, srv models.Server, err error, message string) {
+	if err != nil {
+		respondServerError(c, err, message)
+		return
+	}
+	c.JSON(status, srv.Public())
+}
+
+func respondServerError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, models.ErrInvalidServer), errors.Is(err, models.ErrUnknownServers):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrServerNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrServerExists), errors.Is(err, models.ErrServerInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
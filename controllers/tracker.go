@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"dhis2gw/config"
+	"dhis2gw/joblog"
+	"dhis2gw/metrics"
+	"dhis2gw/models"
+	"dhis2gw/schemas"
+	"dhis2gw/tasks"
+	"dhis2gw/utils"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hibiken/asynq"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+type TrackerController struct{}
+
+// CreateRequest godoc
+// @Summary Submit tracker data request
+// @Description Accepts a nested DHIS2 tracker payload and queues it for import. Every org unit in the payload must be
+// @Description one assigned to the user or below one. Requires `Authorization: Token <token>` header.
+// @Tags tracker
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param request body models.TrackerRequest true "Tracker submission payload"
+// @Success 200 {object} models.TrackerResponse
+// @Failure 400 {object} models.ErrorResponse "Invalid JSON or schema validation failed"
+// @Failure 403 {object} models.ErrorResponse "Org units outside the user's assigned org units"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /tracker [post]
+func (t *TrackerController) CreateRequest(c *gin.Context) {
+	cfg := config.MustGet().Config
+	var req map[string]interface{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+
+	valid, errors, err := utils.ValidateJSONAgainstSchemaString(schemas.Tracker, req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Schema validation error: " + err.Error()})
+		return
+	}
+
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":  "Request does not match required schema",
+			"detail": errors,
+		})
+		return
+	}
+
+	// The SDK only sends nested payloads, so flat lists would be silently dropped
+	for _, key := range []string{"enrollments", "events", "relationships"} {
+		if _, ok := req[key]; ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Top-level " + key + " are not supported; nest them under trackedEntities",
+			})
+			return
+		}
+	}
+
+	var request models.TrackerRequest
+	jsonBytes, _ := json.Marshal(req)
+	if err := json.Unmarshal(jsonBytes, &request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not parse validated data: " + err.Error()})
+		return
+	}
+	if err := request.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	reason, err := orgUnitScopeError(c, request.OrgUnits()...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check org unit scope"})
+		return
+	}
+	if reason != "" {
+		logRejectedSubmission(c, db, request, reason)
+		c.JSON(http.StatusForbidden, gin.H{"error": reason})
+		return
+	}
+	asynqClient := c.MustGet("asynqClient").(*asynq.Client)
+
+	jl, err := joblog.New(db, request)
+	if err != nil {
+		log.Errorf("Could not create job log: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log submission"})
+		return
+	}
+
+	task, err := tasks.NewTrackerTask(tasks.TrackerTaskPayload{
+		LogID:   jl.ID,
+		Payload: request,
+	})
+	if err != nil {
+		log.Errorf("Could not create tracker task: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create task"})
+		return
+	}
+	queue := cfg.Server.QueuePrefix + ":default"
+	taskInfo, err := asynqClient.Enqueue(task, asynq.Queue(queue))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enqueue job"})
+		return
+	}
+	metrics.TaskEnqueued(task.Type())
+
+	_ = jl.UpdateTaskID(taskInfo.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Tracker request queued for processing",
+		"submission_id": jl.ID,
+		"task_id":       taskInfo.ID,
+	})
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"database/sql"
+	"dhis2gw/audit"
+	"dhis2gw/models"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetUserOrgUnits godoc
+// @Summary List the org units assigned to a user
+// @Description Returns the org units a user may submit aggregate and tracker data for, together with everything below
+// @Description them in the hierarchy. A user without assignments may not submit at all, unless their role is listed in api.orgunit_scope_exempt_roles.
+// @Tags users
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param uid path string true "User UID"
+// @Success 200 {array} models.UserOrgUnit
+// @Failure 404 {object} models.ErrorResponse "User not found"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /users/{uid}/orgunits [get]
+func (uc *UserController) GetUserOrgUnits(c *gin.Context) {
+	orgUnits, err := models.GetUserOrgUnits(c.Param("uid"))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user org units"})
+	default:
+		c.JSON(http.StatusOK, orgUnits)
+	}
+}
+
+// SetUserOrgUnits godoc
+// @Summary Assign org units to a user
+// @Description Replaces the org units assigned to a user with the given org unit UIDs. An empty list removes every
+// @Description assignment.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security TokenAuth
+// @Param uid path string true "User UID"
+// @Param orgUnits body models.UserOrgUnitsInput true "Org unit UIDs to assign"
+// @Success 200 {array} models.UserOrgUnit
+// @Failure 400 {object} models.ErrorResponse "Invalid JSON or unknown org units"
+// @Failure 404 {object} models.ErrorResponse "User not found"
+// @Failure 500 {object} models.ErrorResponse "Server-side error"
+// @Router /users/{uid}/orgunits [put]
+func (uc *UserController) SetUserOrgUnits(c *gin.Context) {
+	var input models.UserOrgUnitsInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+	orgUnits, err := models.SetUserOrgUnits(c.Param("uid"), input.OrgUnits)
+	switch {
+	case errors.Is(err, models.ErrUnknownOrgUnits):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, sql.ErrNoRows):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign org units"})
+	default:
+		recordAudit(c, audit.ActionUserOrgUnits, gin.H{"uid": c.Param("uid"), "orgUnits": input.OrgUnits})
+		c.JSON(http.StatusOK, orgUnits)
+	}
+}
@@ -1,10 +1,11 @@
 package controllers
 
 import (
+	"dhis2gw/audit"
+	"dhis2gw/auth"
 	"dhis2gw/db"
 	"dhis2gw/models"
 	"dhis2gw/utils"
-	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/jmoiron/sqlx"
 	log "github.com/sirupsen/logrus"
@@ -74,6 +75,12 @@ func (uc *UserController) CreateUser(c *gin.Context) {
 		return
 	}
 
+	recordAudit(c, audit.ActionUserCreate, gin.H{
+		"uid":         uid,
+		"username":    input.Username,
+		"isActive":    input.IsActive,
+		"isAdminUser": input.IsAdminUser,
+	})
 	c.JSON(http.StatusCreated, gin.H{"message": "User created successfully!", "uid": uid})
 }
 
@@ -133,6 +140,14 @@ func (uc *UserController) UpdateUser(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
 		return
 	}
+	recordAudit(c, audit.ActionUserUpdate, gin.H{
+		"uid":       uid,
+		"username":  user.Username,
+		"firstName": user.FirstName,
+		"lastName":  user.LastName,
+		"email":     user.Email,
+		"telephone": user.Phone,
+	})
 
 	c.JSON(http.StatusOK, gin.H{"message": "User updated successfully"})
 }
@@ -164,22 +179,14 @@ func (uc *UserController) DeleteUser(c *gin.Context) {
 // @Failure 500 {object} models.ErrorResponse "Server/internal error"
 // @Router /users/getToken [post] generates and saves an API token for the currently authenticated user
 func (uc *UserController) CreateUserToken(c *gin.Context) {
-	// Extract the authenticated user's UID from the request context
-	authUserUID, exists := c.Get("currentUser")
-	if !exists {
+	authUser := auth.GetUser(c)
+	if authUser == nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
 
-	// Ensure UID is a valid string
-	userID, ok := authUserUID.(int64)
-	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Invalid user identifier: %v", authUserUID)})
-		return
-	}
-
-	// Fetch the full user details using UID
-	user, err := models.GetUserById(userID)
+	// Fetch the full user details
+	user, err := models.GetUserById(authUser.ID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
@@ -243,6 +250,7 @@ func (uc *UserController) CreateUserToken(c *gin.Context) {
 		return
 	}
 
+	recordAudit(c, audit.ActionTokenIssue, gin.H{"token": "api", "expires": expirationTime})
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "Token created successfully",
 		"token":   token,
@@ -263,22 +271,14 @@ func (uc *UserController) CreateUserToken(c *gin.Context) {
 // @Failure 500 {object} models.ErrorResponse "Server/internal error"
 // @Router /users/refreshToken [post]
 func (uc *UserController) RefreshUserToken(c *gin.Context) {
-	// Extract the authenticated user's UID from context
-	authUserUID, exists := c.Get("currentUser")
-	if !exists {
+	authUser := auth.GetUser(c)
+	if authUser == nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
 
-	// Convert UID to string
-	userID, ok := authUserUID.(int64)
-	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user identifier"})
-		return
-	}
-
-	// Fetch the full user details using ID
-	user, err := models.GetUserById(userID)
+	// Fetch the full user details
+	user, err := models.GetUserById(authUser.ID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
@@ -336,6 +336,7 @@ func (uc *UserController) RefreshUserToken(c *gin.Context) {
 		return
 	}
 
+	recordAudit(c, audit.ActionTokenRefresh, gin.H{"token": "api", "expires": newExpiration})
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Token refreshed successfully",
 		"token":   newToken,
@@ -0,0 +1 @@
+ALTER TABLE submission_log DROP COLUMN async_job_id;
\ No newline at end of file
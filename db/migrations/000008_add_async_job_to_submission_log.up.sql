@@ -0,0 +1 @@
+ALTER TABLE submission_log ADD COLUMN async_job_id TEXT;
\ No newline at end of file
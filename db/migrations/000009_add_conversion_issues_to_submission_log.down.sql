@@ -0,0 +1 @@
+ALTER TABLE submission_log DROP COLUMN conversion_issues;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE submission_log ADD COLUMN conversion_issues JSONB;
\ No newline at end of file
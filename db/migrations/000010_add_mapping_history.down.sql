@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS dhis2_mappings_history;
+DROP TABLE IF EXISTS mapping_change_sets;
\ No newline at end of file
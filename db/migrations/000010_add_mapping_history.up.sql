@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS mapping_change_sets
+(
+    id             BIGSERIAL PRIMARY KEY,
+    uid            TEXT        NOT NULL DEFAULT generate_uid(),
+    source         TEXT        NOT NULL DEFAULT '', -- 'api', 'import', 'rollback'
+    description    TEXT        NOT NULL DEFAULT '',
+    user_id        BIGINT REFERENCES users (id) ON DELETE SET NULL,
+    rolled_back_by BIGINT REFERENCES mapping_change_sets (id),
+    created        TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_mapping_change_sets_uid ON mapping_change_sets (uid);
+CREATE INDEX IF NOT EXISTS idx_mapping_change_sets_created ON mapping_change_sets (created);
+
+CREATE TABLE IF NOT EXISTS dhis2_mappings_history
+(
+    id            BIGSERIAL PRIMARY KEY,
+    change_set_id BIGINT      NOT NULL REFERENCES mapping_change_sets (id) ON DELETE CASCADE,
+    mapping_id    BIGINT      NOT NULL, -- no foreign key, history outlives deleted mappings
+    mapping_uid   TEXT        NOT NULL,
+    operation     TEXT        NOT NULL CHECK (operation IN ('create', 'update', 'delete')),
+    old_value     JSONB,
+    new_value     JSONB,
+    user_id       BIGINT REFERENCES users (id) ON DELETE SET NULL,
+    created       TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_dhis2_mappings_history_change_set ON dhis2_mappings_history (change_set_id);
+CREATE INDEX IF NOT EXISTS idx_dhis2_mappings_history_mapping ON dhis2_mappings_history (mapping_id);
+CREATE INDEX IF NOT EXISTS idx_dhis2_mappings_history_created ON dhis2_mappings_history (created);
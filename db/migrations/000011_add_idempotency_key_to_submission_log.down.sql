@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_submission_log_user_idempotency_key;
+ALTER TABLE submission_log DROP COLUMN request_hash;
+ALTER TABLE submission_log DROP COLUMN idempotency_key;
+ALTER TABLE submission_log DROP COLUMN user_id;
\ No newline at end of file
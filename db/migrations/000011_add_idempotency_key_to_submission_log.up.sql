@@ -0,0 +1,7 @@
+ALTER TABLE submission_log ADD COLUMN user_id BIGINT;
+ALTER TABLE submission_log ADD COLUMN idempotency_key TEXT;
+ALTER TABLE submission_log ADD COLUMN request_hash TEXT;
+
+-- A client key identifies one submission per user; rows without a key are not constrained
+CREATE UNIQUE INDEX IF NOT EXISTS idx_submission_log_user_idempotency_key
+    ON submission_log (user_id, idempotency_key) WHERE idempotency_key IS NOT NULL;
\ No newline at end of file
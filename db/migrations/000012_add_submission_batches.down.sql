@@ -0,0 +1,2 @@
+ALTER TABLE submission_log DROP COLUMN batch_id;
+DROP TABLE IF EXISTS submission_batches;
\ No newline at end of file
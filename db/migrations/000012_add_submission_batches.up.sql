@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS submission_batches
+(
+    id       BIGSERIAL PRIMARY KEY,
+    uid      TEXT        NOT NULL DEFAULT generate_uid(),
+    user_id  BIGINT REFERENCES users (id) ON DELETE SET NULL,
+    blocks   INTEGER     NOT NULL DEFAULT 0,
+    accepted INTEGER     NOT NULL DEFAULT 0,
+    rejected INTEGER     NOT NULL DEFAULT 0,
+    created  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_submission_batches_uid ON submission_batches (uid);
+
+ALTER TABLE submission_log ADD COLUMN batch_id BIGINT REFERENCES submission_batches (id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_submission_log_batch_id ON submission_log (batch_id);
\ No newline at end of file
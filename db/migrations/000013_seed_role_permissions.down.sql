@@ -0,0 +1,3 @@
+DELETE FROM user_role_permissions
+WHERE user_role IN (SELECT id FROM user_roles WHERE name IN ('Administrator', 'SMS User'))
+  AND sys_module IN ('Roles', 'Aggregate', 'Tracker', 'Logs', 'Mappings');
\ No newline at end of file
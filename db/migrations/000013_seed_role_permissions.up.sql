@@ -0,0 +1,14 @@
+-- Administrators get every permission; SMS User partners may submit data and read their logs
+INSERT INTO user_role_permissions (user_role, sys_module, sys_perms)
+SELECT r.id, m.module, 'rmad'
+FROM user_roles r,
+     (VALUES ('Users'), ('Roles'), ('Aggregate'), ('Tracker'), ('Logs'), ('Mappings')) AS m (module)
+WHERE r.name = 'Administrator'
+ON CONFLICT (sys_module, user_role) DO NOTHING;
+
+INSERT INTO user_role_permissions (user_role, sys_module, sys_perms)
+SELECT r.id, m.module, m.perms
+FROM user_roles r,
+     (VALUES ('Aggregate', 'ra'), ('Tracker', 'ra'), ('Logs', 'r')) AS m (module, perms)
+WHERE r.name = 'SMS User'
+ON CONFLICT (sys_module, user_role) DO NOTHING;
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS user_refresh_tokens;
\ No newline at end of file
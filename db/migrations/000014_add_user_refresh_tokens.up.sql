@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS user_refresh_tokens
+(
+    id          BIGSERIAL PRIMARY KEY,
+    user_id     BIGINT      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    token_hash  TEXT        NOT NULL, -- bcrypt hash, the plain token is only handed to the client
+    expires_at  TIMESTAMPTZ NOT NULL,
+    revoked_at  TIMESTAMPTZ,
+    replaced_by BIGINT REFERENCES user_refresh_tokens (id),
+    created     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_refresh_tokens_user_id ON user_refresh_tokens (user_id);
\ No newline at end of file
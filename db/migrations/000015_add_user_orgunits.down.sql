@@ -0,0 +1 @@
+DROP TABLE IF EXISTS user_orgunits;
\ No newline at end of file
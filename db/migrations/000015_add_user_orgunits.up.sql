@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS user_orgunits
+(
+    id         BIGSERIAL PRIMARY KEY,
+    user_id    BIGINT      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    orgunit_id BIGINT      NOT NULL REFERENCES organisationunit (id) ON DELETE CASCADE,
+    created    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (user_id, orgunit_id)
+);
\ No newline at end of file
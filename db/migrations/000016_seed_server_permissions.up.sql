@@ -0,0 +1,6 @@
+-- Administrators manage the server registry
+INSERT INTO user_role_permissions (user_role, sys_module, sys_perms)
+SELECT id, 'Servers', 'rmad'
+FROM user_roles
+WHERE name = 'Administrator'
+ON CONFLICT (sys_module, user_role) DO NOTHING;
\ No newline at end of file
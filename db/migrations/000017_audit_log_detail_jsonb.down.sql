@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS audit_log_actor;
+
+ALTER TABLE audit_log ALTER COLUMN detail TYPE TEXT USING detail::text;
\ No newline at end of file
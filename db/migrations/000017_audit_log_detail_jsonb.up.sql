@@ -0,0 +1,4 @@
+-- Audit details are structured payloads
+ALTER TABLE audit_log ALTER COLUMN detail TYPE JSONB USING to_jsonb(detail);
+
+CREATE INDEX IF NOT EXISTS audit_log_actor ON audit_log (actor);
\ No newline at end of file
@@ -0,0 +1 @@
+DELETE FROM user_role_permissions WHERE sys_module = 'Audit';
\ No newline at end of file
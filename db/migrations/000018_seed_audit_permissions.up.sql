@@ -0,0 +1,6 @@
+-- Administrators may read the audit log
+INSERT INTO user_role_permissions (user_role, sys_module, sys_perms)
+SELECT id, 'Audit', 'r'
+FROM user_roles
+WHERE name = 'Administrator'
+ON CONFLICT (sys_module, user_role) DO NOTHING;
\ No newline at end of file
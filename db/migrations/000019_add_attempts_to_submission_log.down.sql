@@ -0,0 +1 @@
+ALTER TABLE submission_log DROP COLUMN attempts;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE submission_log ADD COLUMN attempts JSONB NOT NULL DEFAULT '[]'::jsonb;
\ No newline at end of file
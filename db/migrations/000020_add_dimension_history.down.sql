@@ -0,0 +1,3 @@
+DELETE FROM dhis2_mappings_history WHERE dimension_id IS NOT NULL;
+DROP INDEX IF EXISTS idx_dhis2_mappings_history_dimension;
+ALTER TABLE dhis2_mappings_history DROP COLUMN IF EXISTS dimension_id;
@@ -0,0 +1,4 @@
+-- Mapping dimension changes are recorded in the change set of their mapping. Entries with a
+-- dimension_id hold dimension snapshots in old_value and new_value.
+ALTER TABLE dhis2_mappings_history ADD COLUMN IF NOT EXISTS dimension_id BIGINT;
+CREATE INDEX IF NOT EXISTS idx_dhis2_mappings_history_dimension ON dhis2_mappings_history (dimension_id);
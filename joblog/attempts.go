@@ -0,0 +1,67 @@
+package joblog
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Classes of an attempt at importing a submission into DHIS2
+const (
+	// AttemptSucceeded means DHIS2 imported everything
+	AttemptSucceeded = "succeeded"
+	// AttemptPartial means DHIS2 imported the submission with conflicts; the conflicting values
+	// would be rejected again, so the attempt is not retried
+	AttemptPartial = "partial"
+	// AttemptRetryable covers network errors, timeouts, 408, 429 and 5xx responses
+	AttemptRetryable = "retryable"
+	// AttemptPermanent covers 409 conflicts, validation errors and other 4xx responses
+	AttemptPermanent = "permanent"
+)
+
+// Attempt records how one attempt at a submission ended and whether it is retried
+type Attempt struct {
+	Number       int       `json:"attempt" example:"1"`
+	At           time.Time `json:"at"`
+	Class        string    `json:"class" example:"retryable"`
+	Reason       string    `json:"reason,omitempty" example:"DHIS2 returned 502 Bad Gateway"`
+	HTTPStatus   int       `json:"httpStatus,omitempty" example:"502"`
+	ImportStatus string    `json:"importStatus,omitempty" example:"WARNING"`
+	WillRetry    bool      `json:"willRetry"`
+}
+
+// RecordAttempt appends an attempt to the submission's attempts and sets its retry count to
+// the number of attempts before this one.
+func (jl *JobLog) RecordAttempt(a Attempt) error {
+	if a.At.IsZero() {
+		a.At = time.Now()
+	}
+	raw, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	_, err = jl.db.Exec(`
+		UPDATE submission_log
+		SET attempts = attempts || jsonb_build_array($1::jsonb), retry_count = $2, last_attempt_at = $3
+		WHERE id = $4`, string(raw), a.Number-1, a.At, jl.ID)
+	if err == nil {
+		jl.RetryCount = a.Number - 1
+		jl.Attempts = appendAttempt(jl.Attempts, raw)
+	}
+	return err
+}
+
+// AttemptCount is the number of attempts recorded on the submission
+func (jl *JobLog) AttemptCount() int {
+	var attempts []json.RawMessage
+	if err := json.Unmarshal(jl.Attempts, &attempts); err != nil {
+		return 0
+	}
+	return len(attempts)
+}
+
+func appendAttempt(attempts json.RawMessage, raw []byte) json.RawMessage {
+	var list []json.RawMessage
+	_ = json.Unmarshal(attempts, &list)
+	out, _ := json.Marshal(append(list, raw))
+	return out
+}
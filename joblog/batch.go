@@ -0,0 +1,70 @@
+package joblog
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Batch groups the submission logs created by one bulk request.
+type Batch struct {
+	ID       int64         `db:"id" json:"-"`
+	UID      string        `db:"uid" json:"uid"`
+	UserID   sql.NullInt64 `db:"user_id" json:"-"`
+	Blocks   int           `db:"blocks" json:"blocks"`
+	Accepted int           `db:"accepted" json:"accepted"`
+	Rejected int           `db:"rejected" json:"rejected"`
+	Created  time.Time     `db:"created" json:"created"`
+
+	db *sqlx.DB
+}
+
+// NewBatch records a bulk request of the given number of blocks made by userID, where 0
+// means no authenticated user.
+func NewBatch(db *sqlx.DB, userID int64, blocks int) (*Batch, error) {
+	user := sql.NullInt64{Int64: userID, Valid: userID != 0}
+	var b Batch
+	err := db.Get(&b, `
+		INSERT INTO submission_batches (user_id, blocks)
+		VALUES ($1, $2)
+		RETURNING id, uid, user_id, blocks, accepted, rejected, created`, user, blocks)
+	if err != nil {
+		return nil, err
+	}
+	b.db = db
+	return &b, nil
+}
+
+// UpdateCounts records how many blocks of the batch were accepted and rejected.
+func (b *Batch) UpdateCounts(accepted, rejected int) error {
+	_, err := b.db.Exec(
+		`UPDATE submission_batches SET accepted = $1, rejected = $2 WHERE id = $3`,
+		accepted, rejected, b.ID,
+	)
+	if err == nil {
+		b.Accepted, b.Rejected = accepted, rejected
+	}
+	return err
+}
+
+// NewInBatch creates a JobLog like New and attaches it to a batch.
+func (b *Batch) NewInBatch(payload interface{}) (*JobLog, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	var jl JobLog
+	err = b.db.Get(&jl, `
+		INSERT INTO submission_log (payload, status, user_id, batch_id)
+		VALUES ($1, 'queued', $2, $3)
+		RETURNING id, submitted_at, payload, status, retry_count, last_attempt_at, task_id, response,
+		          user_id, batch_id`,
+		raw, b.UserID, b.ID)
+	if err != nil {
+		return nil, err
+	}
+	jl.db = b.db
+	return &jl, nil
+}
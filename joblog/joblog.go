@@ -3,8 +3,10 @@ package joblog
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	log "github.com/sirupsen/logrus"
 	"strings"
 	"time"
@@ -20,7 +22,14 @@ type JobLog struct {
 	LastAttempt  sql.NullTime    `db:"last_attempt_at" json:"last_attempt"`
 	TaskID       sql.NullString  `db:"task_id" json:"task_id"`
 	Response     sql.NullString  `db:"response" json:"response"`
-	Errors       sql.NullString  `db:"errors" json:"errors"` // Optional field for storing error messages
+	Errors       sql.NullString  `db:"errors" json:"errors"`                                                      // Optional field for storing error messages
+	AsyncJobID   sql.NullString  `db:"async_job_id" json:"async_job_id,omitempty"`                                // DHIS2 job ID for async imports
+	Issues       sql.NullString  `db:"conversion_issues" swaggertype:"object" json:"conversion_issues,omitempty"` // Data values left out of the DHIS2 payload
+	UserID       sql.NullInt64   `db:"user_id" json:"user_id,omitempty"`                                          // Submitting user
+	Idempotency  sql.NullString  `db:"idempotency_key" json:"idempotency_key,omitempty"`                          // Client-supplied key, unique per user
+	RequestHash  sql.NullString  `db:"request_hash" json:"-"`                                                     // Hash of the request body the key was first used with
+	BatchID      sql.NullInt64   `db:"batch_id" json:"batch_id,omitempty"`                                        // Bulk request the submission belongs to
+	Attempts     json.RawMessage `db:"attempts" swaggertype:"array,object" json:"attempts,omitempty"`             // Classified outcome of every import attempt
 
 	db *sqlx.DB `json:"-"` // not persisted, for method receivers
 }
@@ -36,6 +45,9 @@ type JobLogSwagger struct {
 	TaskID      *string                `json:"task_id,omitempty" example:"abc-123"`
 	Response    *string                `json:"response,omitempty" example:"OK"`
 	Errors      *string                `json:"errors,omitempty" example:""`
+	AsyncJobID  *string                `json:"async_job_id,omitempty" example:"mB3zCeQwQn5"`
+	Issues      []map[string]any       `json:"conversion_issues,omitempty"`
+	Attempts    []Attempt              `json:"attempts,omitempty"`
 }
 
 type JobLogFilter struct {
@@ -68,11 +80,112 @@ func New(db *sqlx.DB, payload interface{}) (*JobLog, error) {
 	return &jl, nil
 }
 
+// NewRejected records a submission that was refused before being queued, such as one for
+// org units outside the submitting user's scope. userID 0 means no authenticated user.
+func NewRejected(db *sqlx.DB, payload interface{}, userID int64, reason string) (*JobLog, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	user := sql.NullInt64{Int64: userID, Valid: userID != 0}
+	var jl JobLog
+	err = db.Get(&jl, `
+		INSERT INTO submission_log (payload, status, user_id, errors, last_attempt_at)
+		VALUES ($1, 'rejected', $2, $3, NOW())
+		RETURNING id, submitted_at, payload, status, retry_count, last_attempt_at, task_id, response, errors,
+		          user_id`,
+		raw, user, reason)
+	if err != nil {
+		return nil, err
+	}
+	jl.db = db
+	return &jl, nil
+}
+
+// ErrIdempotencyConflict is returned when an idempotency key is reused for a different request body.
+var ErrIdempotencyConflict = errors.New("idempotency key was already used for a different request")
+
+// IdempotencyKey is a client-supplied key identifying one submission of a user, together
+// with the hash of the request body it was first used with.
+type IdempotencyKey struct {
+	UserID      int64
+	Key         string
+	RequestHash string
+}
+
+// NewIdempotent creates a JobLog like New while claiming the idempotency key. When the key
+// was claimed before, the original JobLog is returned with created false, together with
+// ErrIdempotencyConflict if it was claimed for a different request body.
+func NewIdempotent(db *sqlx.DB, payload interface{}, key IdempotencyKey) (*JobLog, bool, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, false, err
+	}
+	var jl JobLog
+	err = db.Get(&jl, `
+		INSERT INTO submission_log (payload, status, user_id, idempotency_key, request_hash)
+		VALUES ($1, 'queued', $2, $3, $4)
+		ON CONFLICT (user_id, idempotency_key) WHERE idempotency_key IS NOT NULL DO NOTHING
+		RETURNING id, submitted_at, payload, status, retry_count, last_attempt_at, task_id, response,
+		          user_id, idempotency_key, request_hash`,
+		raw, key.UserID, key.Key, key.RequestHash)
+	if err == nil {
+		jl.db = db
+		return &jl, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, false, err
+	}
+	// Another submission holds the key
+	original, err := FindIdempotent(db, key)
+	if err == nil && original == nil {
+		err = fmt.Errorf("submission with idempotency key %q disappeared", key.Key)
+	}
+	return original, false, err
+}
+
+// FindIdempotent returns the JobLog a user created with the key, or nil if the key is unused.
+// ErrIdempotencyConflict is returned along with the JobLog when its request body differs.
+func FindIdempotent(db *sqlx.DB, key IdempotencyKey) (*JobLog, error) {
+	var jl JobLog
+	err := db.Get(&jl, `
+		SELECT id, submitted_at, payload, status, retry_count, last_attempt_at, task_id, response, errors,
+		       user_id, idempotency_key, request_hash
+		FROM submission_log WHERE user_id = $1 AND idempotency_key = $2`, key.UserID, key.Key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	jl.db = db
+	if jl.RequestHash.String != key.RequestHash {
+		return &jl, ErrIdempotencyConflict
+	}
+	return &jl, nil
+}
+
+// FailUnqueued marks a submission that could not be queued as failed and frees its
+// idempotency key, so that the client can retry with the same key.
+func (jl *JobLog) FailUnqueued(errors string) error {
+	_, err := jl.db.Exec(
+		`UPDATE submission_log SET status = 'failed', errors = $1, idempotency_key = NULL WHERE id = $2`,
+		errors, jl.ID,
+	)
+	if err == nil {
+		jl.Status = "failed"
+		jl.Errors = sql.NullString{String: errors, Valid: true}
+		jl.Idempotency = sql.NullString{}
+	}
+	return err
+}
+
 // Load finds a JobLog by ID.
 func Load(db *sqlx.DB, id int64) (*JobLog, error) {
 	var jl JobLog
 	err := db.Get(&jl, `
-		SELECT id, submitted_at, payload, status, retry_count, last_attempt_at, task_id, response, errors, dhis2_payload
+		SELECT id, submitted_at, payload, status, retry_count, last_attempt_at, task_id, response, errors, dhis2_payload,
+		       async_job_id, conversion_issues, attempts
 		FROM submission_log WHERE id = $1`, id)
 	if err != nil {
 		return nil, err
@@ -152,15 +265,28 @@ func (jl *JobLog) UpdateDhis2Payload(dhis2Payload string) error {
 	return err
 }
 
-// IncrementRetry increments the retry count and resets the status to "queued".
-func (jl *JobLog) IncrementRetry() error {
+// UpdateAsyncJob records the DHIS2 job ID of an async import and marks the log as pending.
+func (jl *JobLog) UpdateAsyncJob(jobID string) error {
 	_, err := jl.db.Exec(
-		`UPDATE submission_log SET retry_count = retry_count + 1, status = 'queued', last_attempt_at = NOW() WHERE id = $1`,
-		jl.ID,
+		`UPDATE submission_log SET async_job_id = $1, status = 'pending', last_attempt_at = NOW() WHERE id = $2`,
+		jobID, jl.ID,
 	)
 	if err == nil {
-		jl.RetryCount++
-		jl.Status = "queued"
+		jl.AsyncJobID = sql.NullString{String: jobID, Valid: true}
+		jl.Status = "pending"
+	}
+	return err
+}
+
+// UpdateConversionIssues stores the data values that were dropped or flagged while building the DHIS2 payload.
+func (jl *JobLog) UpdateConversionIssues(issues interface{}) error {
+	raw, err := json.Marshal(issues)
+	if err != nil {
+		return err
+	}
+	_, err = jl.db.Exec(`UPDATE submission_log SET conversion_issues = $1 WHERE id = $2`, raw, jl.ID)
+	if err == nil {
+		jl.Issues = sql.NullString{String: string(raw), Valid: true}
 	}
 	return err
 }
@@ -205,6 +331,26 @@ func GetByTaskID(db *sqlx.DB, taskID string) (*JobLog, error) {
 	return &jl, nil
 }
 
+// GetByIDs retrieves the JobLogs with the given IDs, keyed by ID. IDs without a log are left out.
+func GetByIDs(db *sqlx.DB, ids []int64) (map[int64]*JobLog, error) {
+	logs := make(map[int64]*JobLog, len(ids))
+	if len(ids) == 0 {
+		return logs, nil
+	}
+	var jobs []*JobLog
+	err := db.Select(&jobs, `
+		SELECT id, submitted_at, payload, status, retry_count, last_attempt_at, task_id, response, errors
+		FROM submission_log WHERE id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	for _, jl := range jobs {
+		jl.db = db
+		logs[jl.ID] = jl
+	}
+	return logs, nil
+}
+
 // GetLogs retrieves job logs based on the provided filter criteria.
 func GetLogs(db *sqlx.DB, filter *JobLogFilter) ([]JobLog, int, error) {
 	var (
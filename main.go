@@ -8,8 +8,10 @@ import (
 	"dhis2gw/controllers"
 	"dhis2gw/db"
 	"dhis2gw/docs"
+	"dhis2gw/metrics"
 	"dhis2gw/middleware"
 	"dhis2gw/models"
+	"dhis2gw/processor"
 	"dhis2gw/tasks"
 	"dhis2gw/utils"
 	"fmt"
@@ -97,7 +99,7 @@ func main() {
 		}
 		return
 	}
-	client = asynq.NewClient(asynq.RedisClientOpt{Addr: cfg.Server.RedisAddress})
+	client = asynq.NewClient(asynq.RedisClientOpt{Addr: cfg.Server.RedisAddress, DB: cfg.Server.RedisDB})
 	defer func(client *asynq.Client) {
 		_ = client.Close()
 	}(client)
@@ -108,12 +110,33 @@ func main() {
 		cfg.API.DHIS2Password)
 	tasks.SetClient(dhis2Client)
 
+	// /metrics is kept off the public API router and only served on the metrics address
+	metrics.RegisterQueueCollector(
+		asynq.NewInspector(asynq.RedisClientOpt{Addr: cfg.Server.RedisAddress, DB: cfg.Server.RedisDB}),
+		utils.QueueNames(cfg.Server.QueuePrefix))
+	metrics.ListenAndServe(cfg.Server.MetricsAddress)
+
 	var wg sync.WaitGroup
 
 	wg.Add(2)
 	go startAPIServer(ctx, &wg, cfg)
 	go startWorker(ctx, &wg, cfg)
 
+	if !cfg.Server.SkipRequestProcessing && !runtimeCfg.Flags.SkipRequestProcessing {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			processor.StartRequestProcessor(ctx)
+		}()
+	}
+	if !runtimeCfg.Flags.SkipScheduleProcessing {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			processor.StartScheduleProcessor(ctx)
+		}()
+	}
+
 	wg.Wait()
 }
 
@@ -128,6 +151,7 @@ func startAPIServer(ctx context.Context, wg *sync.WaitGroup, cfg config.Config)
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
+	router.Use(metrics.GinMiddleware())
 
 	funcMap := template.FuncMap{
 		"safeHTML": func(s string) template.HTML {
@@ -142,6 +166,14 @@ func startAPIServer(ctx context.Context, wg *sync.WaitGroup, cfg config.Config)
 
 	docs.SwaggerInfo.BasePath = "/api/v2"
 
+	authController := &controllers.AuthController{}
+	authGroup := router.Group("/api/v2/auth", middleware.RequireJWTSecret)
+	{
+		authGroup.POST("/login", authController.Login)
+		authGroup.POST("/refresh", authController.RefreshToken)
+		authGroup.POST("/logout", authController.Logout)
+	}
+
 	v2 := router.Group("/api/v2", middleware.BasicAuth(db.GetDB(), client))
 	{
 		v2.GET("/test2", func(c *gin.Context) {
@@ -149,31 +181,80 @@ func startAPIServer(ctx context.Context, wg *sync.WaitGroup, cfg config.Config)
 		})
 
 		userController := &controllers.UserController{}
-		v2.POST("/user", userController.CreateUser)
-		v2.GET("/users", userController.GetUsersHandler(db.GetDB()))
-		v2.GET("/users/:uid", userController.GetUserByUID)
-		v2.PUT("/users/:uid", userController.UpdateUser)
+		v2.POST("/user", middleware.Require("Users", "a"), userController.CreateUser)
+		v2.GET("/users", middleware.Require("Users", "r"), userController.GetUsersHandler(db.GetDB()))
+		v2.GET("/users/:uid", middleware.Require("Users", "r"), userController.GetUserByUID)
+		v2.PUT("/users/:uid", middleware.Require("Users", "m"), userController.UpdateUser)
+		v2.GET("/users/:uid/orgunits", middleware.Require("Users", "r"), userController.GetUserOrgUnits)
+		v2.PUT("/users/:uid/orgunits", middleware.Require("Users", "m"), userController.SetUserOrgUnits)
 		v2.POST("/users/getToken", userController.CreateUserToken)
 		v2.POST("/users/refreshToken", userController.RefreshUserToken)
 
+		roleController := &controllers.RoleController{}
+		v2.GET("/roles", middleware.Require("Roles", "r"), roleController.GetRolesHandler)
+		v2.POST("/roles", middleware.Require("Roles", "a"), roleController.CreateRoleHandler)
+		v2.GET("/roles/:id", middleware.Require("Roles", "r"), roleController.GetRoleHandler)
+		v2.PUT("/roles/:id", middleware.Require("Roles", "m"), roleController.UpdateRoleHandler)
+		v2.DELETE("/roles/:id", middleware.Require("Roles", "d"), roleController.DeleteRoleHandler)
+		v2.PUT("/users/:uid/role", middleware.Require("Users", "m"), roleController.SetUserRoleHandler)
+
+		auditController := &controllers.AuditController{}
+		v2.GET("/audit", middleware.Require("Audit", "r"), auditController.GetAuditHandler)
+
+		serverController := &controllers.ServerController{}
+		v2.GET("/servers", middleware.Require("Servers", "r"), serverController.GetServersHandler)
+		v2.POST("/servers", middleware.Require("Servers", "a"), serverController.CreateServerHandler)
+		v2.GET("/servers/:uid", middleware.Require("Servers", "r"), serverController.GetServerHandler)
+		v2.PUT("/servers/:uid", middleware.Require("Servers", "m"), serverController.UpdateServerHandler)
+		v2.DELETE("/servers/:uid", middleware.Require("Servers", "d"), serverController.DeleteServerHandler)
+		v2.POST("/servers/:uid/suspend", middleware.Require("Servers", "m"), serverController.SuspendServerHandler)
+		v2.POST("/servers/:uid/resume", middleware.Require("Servers", "m"), serverController.ResumeServerHandler)
+		v2.GET("/servers/:uid/sources", middleware.Require("Servers", "r"), serverController.GetServerSourcesHandler)
+		v2.PUT("/servers/:uid/sources", middleware.Require("Servers", "m"), serverController.SetServerSourcesHandler)
+
 		aggregateController := &controllers.AggregateController{}
-		v2.POST("/aggregate", aggregateController.CreateRequest)
-		v2.GET("/aggregate/reenqueue/:task_id", aggregateController.ReEnqueueAggregateTask)
-		v2.POST("/aggregate/reenqueue/batch", aggregateController.BatchReEnqueueAggregateTasksByIDs)
+		v2.POST("/aggregate", middleware.Require("Aggregate", "a"), aggregateController.CreateRequest)
+		v2.POST("/aggregate/bulk", middleware.Require("Aggregate", "a"), aggregateController.BulkCreateRequest)
+		v2.GET("/aggregate/reenqueue/:task_id", middleware.Require("Aggregate", "m"), aggregateController.ReEnqueueAggregateTask)
+		v2.POST("/aggregate/reenqueue/batch", middleware.Require("Aggregate", "m"), aggregateController.BatchReEnqueueAggregateTasksByIDs)
+
+		trackerController := &controllers.TrackerController{}
+		v2.POST("/tracker", middleware.Require("Tracker", "a"), trackerController.CreateRequest)
+
+		failedTaskController := &controllers.FailedTaskController{}
+		v2.GET("/tasks/failed", middleware.Require("Logs", "r"), failedTaskController.GetFailedTasksHandler)
+		// Requeue, archive and delete check Aggregate or Tracker permissions per task
+		v2.POST("/tasks/failed/requeue", failedTaskController.RequeueFailedTasksHandler)
+		v2.POST("/tasks/failed/archive", failedTaskController.ArchiveFailedTasksHandler)
+		v2.POST("/tasks/failed/delete", failedTaskController.DeleteFailedTasksHandler)
 
 		logController := &controllers.LogsController{}
-		v2.GET("/logs/:id", logController.GetLogByIdHandler(db.GetDB()))
-		v2.GET("/logs", logController.GetLogsHandler(db.GetDB()))
-		v2.DELETE("/logs/:id", logController.DeleteSubmissionLogHandler(db.GetDB()))
-		v2.DELETE("/logs/purge", logController.PurgeSubmissionLogsByDateHandler(db.GetDB()))
+		v2.GET("/logs/:id", middleware.Require("Logs", "r"), logController.GetLogByIdHandler(db.GetDB()))
+		v2.GET("/logs", middleware.Require("Logs", "r"), logController.GetLogsHandler(db.GetDB()))
+		v2.DELETE("/logs/:id", middleware.Require("Logs", "d"), logController.DeleteSubmissionLogHandler(db.GetDB()))
+		v2.DELETE("/logs/purge", middleware.Require("Logs", "d"), logController.PurgeSubmissionLogsByDateHandler(db.GetDB()))
 		// reporcess log
 		// v2.POST("/logs/reprocess/:id", logController.ReprocessLogHandler(db.GetDB()))
 
 		mappingsController := &controllers.MappingController{}
-		v2.GET("/mappings", mappingsController.GetMappingsHandler())
-		v2.POST("/mappings/import/csv", mappingsController.ImportCSVHandler)
-		v2.POST("/mappings/import/excel", mappingsController.ImportExcelHandler)
-		v2.GET("/mappings/export/excel", mappingsController.ExportExcelMappingsHandler)
+		v2.GET("/mappings", middleware.Require("Mappings", "r"), mappingsController.GetMappingsHandler())
+		v2.POST("/mappings/import/csv", middleware.Require("Mappings", "a"), mappingsController.ImportCSVHandler)
+		v2.POST("/mappings/import/excel", middleware.Require("Mappings", "a"), mappingsController.ImportExcelHandler)
+		v2.GET("/mappings/export/excel", middleware.Require("Mappings", "r"), mappingsController.ExportExcelMappingsHandler)
+		v2.POST("/mappings", middleware.Require("Mappings", "a"), mappingsController.CreateMappingHandler)
+		v2.POST("/mappings/validate", middleware.Require("Mappings", "r"), mappingsController.ValidateMappingsHandler)
+		v2.GET("/mappings/changesets", middleware.Require("Mappings", "r"), mappingsController.GetMappingChangeSetsHandler)
+		v2.GET("/mappings/changesets/:uid", middleware.Require("Mappings", "r"), mappingsController.GetMappingChangeSetHandler)
+		v2.POST("/mappings/changesets/:uid/rollback", middleware.Require("Mappings", "m"), mappingsController.RollbackMappingChangeSetHandler)
+		v2.GET("/mappings/history/diff", middleware.Require("Mappings", "r"), mappingsController.DiffMappingsHandler)
+		v2.GET("/mappings/:uid", middleware.Require("Mappings", "r"), mappingsController.GetMappingHandler)
+		v2.PUT("/mappings/:uid", middleware.Require("Mappings", "m"), mappingsController.UpdateMappingHandler)
+		v2.PATCH("/mappings/:uid", middleware.Require("Mappings", "m"), mappingsController.PatchMappingHandler)
+		v2.DELETE("/mappings/:uid", middleware.Require("Mappings", "d"), mappingsController.DeleteMappingHandler)
+		v2.GET("/mappings/:uid/dimensions", middleware.Require("Mappings", "r"), mappingsController.GetMappingDimensionsHandler)
+		v2.POST("/mappings/:uid/dimensions", middleware.Require("Mappings", "a"), mappingsController.CreateMappingDimensionHandler)
+		v2.PUT("/mappings/:uid/dimensions/:id", middleware.Require("Mappings", "m"), mappingsController.UpdateMappingDimensionHandler)
+		v2.DELETE("/mappings/:uid/dimensions/:id", middleware.Require("Mappings", "d"), mappingsController.DeleteMappingDimensionHandler)
 
 	}
 	mappingsController := &controllers.MappingController{}
@@ -255,11 +336,20 @@ func startWorker(ctx context.Context, wg *sync.WaitGroup, cfg config.Config) {
 			Concurrency: cfg.Server.MaxConcurrent,
 
 			Queues: utils.Queues(cfg.Server.QueuePrefix),
+
+			RetryDelayFunc: tasks.RetryDelay,
 		},
 	)
 
+	// Handlers enqueue follow-up tasks, e.g. checks of async DHIS2 imports
+	tasks.SetTaskClient(client)
+
 	mux := asynq.NewServeMux()
+	mux.Use(metrics.TaskMiddleware)
 	mux.HandleFunc(tasks.TypeAggregate, tasks.HandleAggregateTask)
+	mux.HandleFunc(tasks.TypeAggregateBulk, tasks.HandleBulkAggregateTask)
+	mux.HandleFunc(tasks.TypeAggregateImportCheck, tasks.HandleAggregateImportCheckTask)
+	mux.HandleFunc(tasks.TypeTracker, tasks.HandleTrackerTask)
 
 	// Run the worker in a goroutine and listen for shutdown
 	errCh := make(chan error, 1)
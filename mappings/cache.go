@@ -2,6 +2,7 @@ package mappings
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
@@ -21,12 +22,38 @@ type MappingCache struct {
 	items map[string]*models.Dhis2Mapping
 }
 
+var (
+	registryMu sync.Mutex
+	registry   []*MappingCache
+)
+
+// NewMappingCache creates a cache and registers it so that ReloadAll refreshes it
+// whenever mappings are changed through the API.
 func NewMappingCache(db *sqlx.DB, instanceName string) *MappingCache {
-	return &MappingCache{
+	c := &MappingCache{
 		db:           db,
 		instanceName: instanceName,
 		items:        make(map[string]*models.Dhis2Mapping),
 	}
+	registryMu.Lock()
+	registry = append(registry, c)
+	registryMu.Unlock()
+	return c
+}
+
+// ReloadAll reloads every mapping cache created in this process
+func ReloadAll(ctx context.Context) error {
+	registryMu.Lock()
+	caches := append([]*MappingCache(nil), registry...)
+	registryMu.Unlock()
+
+	var errs []error
+	for _, c := range caches {
+		if err := c.Reload(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
 
 func normalize(v string) string {
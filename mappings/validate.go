@@ -0,0 +1,254 @@
+package mappings
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	sdk "github.com/HISP-Uganda/go-dhis2-sdk"
+
+	"dhis2gw/models"
+)
+
+// metadataChunkSize keeps id:in filters well below common URL length limits
+const metadataChunkSize = 100
+
+// ClientLookup returns the SDK client of a DHIS2 instance
+type ClientLookup func(instance string) (*sdk.Client, error)
+
+// ValidationResult lists the problems found with one mapping
+type ValidationResult struct {
+	UID          string   `json:"uid,omitempty" example:"Xy12AbC34De"`
+	Code         string   `json:"code" example:"ANC1"`
+	InstanceName string   `json:"instanceName" example:"default"`
+	Problems     []string `json:"problems"`
+}
+
+// ValidationReport summarises a validation run
+type ValidationReport struct {
+	Checked int                `json:"checked" example:"120"`
+	Invalid int                `json:"invalid" example:"2"`
+	Results []ValidationResult `json:"results"`
+}
+
+type idRef struct {
+	ID string `json:"id"`
+}
+
+type dataSetMeta struct {
+	ID              string `json:"id"`
+	DataSetElements []struct {
+		DataElement idRef `json:"dataElement"`
+	} `json:"dataSetElements"`
+}
+
+type categoryComboMeta struct {
+	ID                   string  `json:"id"`
+	CategoryOptionCombos []idRef `json:"categoryOptionCombos"`
+}
+
+type dataElementMeta struct {
+	ID            string            `json:"id"`
+	CategoryCombo categoryComboMeta `json:"categoryCombo"`
+}
+
+// instanceMetadata is the part of an instance's metadata referenced by the mappings
+type instanceMetadata struct {
+	dataSets       map[string]map[string]bool // data set -> data elements
+	dataElements   map[string]categoryComboMeta
+	categoryCombos map[string]map[string]bool // category combo -> option combos
+	orgUnits       map[string]bool
+}
+
+// Validate checks the DHIS2 references of the mappings against the live metadata of each
+// mapping's instance: that the UIDs exist, that the category option combo belongs to the
+// data element's category combo and that the data element is part of the data set.
+func Validate(ctx context.Context, lookup ClientLookup, ms []models.Dhis2Mapping) (ValidationReport, error) {
+	report := ValidationReport{Checked: len(ms), Results: []ValidationResult{}}
+
+	byInstance := make(map[string][]models.Dhis2Mapping)
+	for _, m := range ms {
+		byInstance[m.InstanceName] = append(byInstance[m.InstanceName], m)
+	}
+	instances := make([]string, 0, len(byInstance))
+	for name := range byInstance {
+		instances = append(instances, name)
+	}
+	sort.Strings(instances)
+
+	for _, instance := range instances {
+		client, err := lookup(instance)
+		if err != nil {
+			for _, m := range byInstance[instance] {
+				report.Results = append(report.Results, ValidationResult{
+					UID: m.UID, Code: m.Code, InstanceName: instance, Problems: []string{err.Error()}})
+			}
+			continue
+		}
+		meta, err := fetchMetadata(ctx, client, byInstance[instance])
+		if err != nil {
+			return report, fmt.Errorf("instance %s: %w", instance, err)
+		}
+		for _, m := range byInstance[instance] {
+			if problems := meta.check(m); len(problems) > 0 {
+				report.Results = append(report.Results, ValidationResult{
+					UID: m.UID, Code: m.Code, InstanceName: instance, Problems: problems})
+			}
+		}
+	}
+	report.Invalid = len(report.Results)
+	return report, nil
+}
+
+func (meta *instanceMetadata) check(m models.Dhis2Mapping) []string {
+	var problems []string
+	if m.What == models.MappingWhatOrgUnit {
+		if m.DestinationOrgUnit != "" && !meta.orgUnits[m.DestinationOrgUnit] {
+			problems = append(problems, fmt.Sprintf("org unit %s not found", m.DestinationOrgUnit))
+		}
+		return problems
+	}
+
+	combo, deFound := meta.dataElements[m.DataElement]
+	if m.DataElement != "" && !deFound {
+		problems = append(problems, fmt.Sprintf("data element %s not found", m.DataElement))
+	}
+	if m.DataSet != "" {
+		elements, ok := meta.dataSets[m.DataSet]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("data set %s not found", m.DataSet))
+		case deFound && !elements[m.DataElement]:
+			problems = append(problems, fmt.Sprintf("data element %s is not in data set %s", m.DataElement, m.DataSet))
+		}
+	}
+	if m.CategoryCombo != "" {
+		if _, ok := meta.categoryCombos[m.CategoryCombo]; !ok {
+			problems = append(problems, fmt.Sprintf("category combo %s not found", m.CategoryCombo))
+		} else if deFound && combo.ID != m.CategoryCombo {
+			problems = append(problems, fmt.Sprintf(
+				"category combo %s is not the category combo of data element %s", m.CategoryCombo, m.DataElement))
+		}
+	}
+	if coc := m.CategoryOptionCombo; coc != nil && *coc != "" && deFound {
+		inCombo := false
+		for _, c := range combo.CategoryOptionCombos {
+			if c.ID == *coc {
+				inCombo = true
+				break
+			}
+		}
+		if !inCombo {
+			problems = append(problems, fmt.Sprintf(
+				"category option combo %s is not in the category combo of data element %s", *coc, m.DataElement))
+		}
+	}
+	return problems
+}
+
+func fetchMetadata(ctx context.Context, client *sdk.Client, ms []models.Dhis2Mapping) (*instanceMetadata, error) {
+	dataSetIDs, dataElementIDs, comboIDs, orgUnitIDs := idSet{}, idSet{}, idSet{}, idSet{}
+	for _, m := range ms {
+		if m.What == models.MappingWhatOrgUnit {
+			orgUnitIDs.add(m.DestinationOrgUnit)
+			continue
+		}
+		dataSetIDs.add(m.DataSet)
+		dataElementIDs.add(m.DataElement)
+		comboIDs.add(m.CategoryCombo)
+	}
+
+	meta := &instanceMetadata{
+		dataSets:       make(map[string]map[string]bool),
+		dataElements:   make(map[string]categoryComboMeta),
+		categoryCombos: make(map[string]map[string]bool),
+		orgUnits:       make(map[string]bool),
+	}
+
+	var dataSets []dataSetMeta
+	if err := fetchByIDs(ctx, client, "dataSets", "id,dataSetElements[dataElement[id]]",
+		dataSetIDs.list(), &dataSets); err != nil {
+		return nil, err
+	}
+	for _, ds := range dataSets {
+		elements := make(map[string]bool, len(ds.DataSetElements))
+		for _, dse := range ds.DataSetElements {
+			elements[dse.DataElement.ID] = true
+		}
+		meta.dataSets[ds.ID] = elements
+	}
+
+	var dataElements []dataElementMeta
+	if err := fetchByIDs(ctx, client, "dataElements", "id,categoryCombo[id,categoryOptionCombos[id]]",
+		dataElementIDs.list(), &dataElements); err != nil {
+		return nil, err
+	}
+	for _, de := range dataElements {
+		meta.dataElements[de.ID] = de.CategoryCombo
+	}
+
+	var combos []categoryComboMeta
+	if err := fetchByIDs(ctx, client, "categoryCombos", "id,categoryOptionCombos[id]",
+		comboIDs.list(), &combos); err != nil {
+		return nil, err
+	}
+	for _, cc := range combos {
+		cocs := make(map[string]bool, len(cc.CategoryOptionCombos))
+		for _, coc := range cc.CategoryOptionCombos {
+			cocs[coc.ID] = true
+		}
+		meta.categoryCombos[cc.ID] = cocs
+	}
+
+	var orgUnits []idRef
+	if err := fetchByIDs(ctx, client, "organisationUnits", "id", orgUnitIDs.list(), &orgUnits); err != nil {
+		return nil, err
+	}
+	for _, ou := range orgUnits {
+		meta.orgUnits[ou.ID] = true
+	}
+	return meta, nil
+}
+
+// fetchByIDs loads the given objects of a metadata resource in chunks and appends them to out
+func fetchByIDs[T any](ctx context.Context, client *sdk.Client, resource, fields string, ids []string, out *[]T) error {
+	for start := 0; start < len(ids); start += metadataChunkSize {
+		end := min(start+metadataChunkSize, len(ids))
+		page := map[string][]T{}
+		res, err := client.Resty.R().
+			SetContext(ctx).
+			SetQueryParams(map[string]string{
+				"filter": "id:in:[" + strings.Join(ids[start:end], ",") + "]",
+				"fields": fields,
+				"paging": "false",
+			}).
+			SetResult(&page).
+			Get("/" + resource)
+		if err != nil {
+			return fmt.Errorf("fetch %s: %w", resource, err)
+		}
+		if res.IsError() {
+			return fmt.Errorf("fetch %s: %s", resource, res.Status())
+		}
+		*out = append(*out, page[resource]...)
+	}
+	return nil
+}
+
+type idSet map[string]bool
+
+func (s idSet) add(id string) {
+	if id != "" {
+		s[id] = true
+	}
+}
+
+func (s idSet) list() []string {
+	ids := make([]string, 0, len(s))
+	for id := range s {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
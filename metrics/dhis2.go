@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// InstrumentResty times every call the client makes to the named DHIS2 instance
+func InstrumentResty(client *resty.Client, instance string) {
+	client.OnAfterResponse(func(_ *resty.Client, res *resty.Response) error {
+		observeDHIS2(instance, endpointOf(res.Request.URL), res.Request.Method, res.StatusCode(), res.Time())
+		return nil
+	})
+	client.OnError(func(req *resty.Request, err error) {
+		var resErr *resty.ResponseError
+		if errors.As(err, &resErr) {
+			// the response was already observed; a later hook failed
+			return
+		}
+		observeDHIS2(instance, endpointOf(req.URL), req.Method, 0, time.Since(req.Time))
+	})
+}
+
+// endpointOf reduces a request URL to at most two path segments below /api, with an API
+// version dropped and IDs replaced, e.g. system/tasks or dataSets/:id
+func endpointOf(rawURL string) string {
+	path := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		path = u.Path
+	}
+	if i := strings.Index(path, "/api/"); i >= 0 {
+		path = path[i+len("/api/"):]
+	}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) > 1 && strings.Trim(segments[0], "0123456789") == "" {
+		segments = segments[1:]
+	}
+	if len(segments) > 2 {
+		segments = segments[:2]
+	}
+	segments[0] = strings.TrimSuffix(segments[0], ".json")
+	if len(segments) == 2 && strings.ContainsAny(segments[1], "0123456789") {
+		segments[1] = ":id"
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,125 @@
+// Package metrics exposes Prometheus metrics of the API, the task queues and the calls made
+// to DHIS2.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+const namespace = "dhis2gw"
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests, by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	tasksEnqueued = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_enqueued_total",
+		Help:      "Tasks enqueued, by task type.",
+	}, []string{"type"})
+
+	tasksProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_processed_total",
+		Help:      "Tasks processed by the workers, by task type and outcome (success, retry or failed).",
+	}, []string{"type", "outcome"})
+
+	taskDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "task_duration_seconds",
+		Help:      "Time taken to process a task, by task type and outcome.",
+		Buckets:   []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"type", "outcome"})
+
+	dhis2Requests = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "dhis2_request_duration_seconds",
+		Help:      "Latency of calls to DHIS2, by instance, endpoint, method and status code; status is error when no response came back.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"instance", "endpoint", "method", "status"})
+
+	dhis2ImportValues = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dhis2_import_values_total",
+		Help:      "Values reported in DHIS2 import summaries, by import type and count (imported, updated, ignored or deleted).",
+	}, []string{"type", "count"})
+
+	syncRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sync_runs_total",
+		Help:      "Runs of the source system syncs, by sync (pbs or ibp) and result (success or failure).",
+	}, []string{"sync", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(httpRequests, httpDuration, tasksEnqueued, tasksProcessed, taskDuration,
+		dhis2Requests, dhis2ImportValues, syncRuns)
+}
+
+// Handler serves the registered metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ListenAndServe serves /metrics on addr in the background. Every process serves its metrics
+// this way rather than on a public router, so that addr can be kept to the internal network.
+// Nothing is served when addr is empty.
+func ListenAndServe(addr string) {
+	if addr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	go func() {
+		log.WithField("Address", addr).Info("Serving metrics")
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.WithError(err).Error("Metrics listener stopped")
+		}
+	}()
+}
+
+// TaskEnqueued counts a task put on a queue
+func TaskEnqueued(taskType string) {
+	tasksEnqueued.WithLabelValues(taskType).Inc()
+}
+
+// ImportCounts adds the counts of a DHIS2 import summary. importType is aggregate or tracker.
+func ImportCounts(importType string, imported, updated, ignored, deleted int) {
+	dhis2ImportValues.WithLabelValues(importType, "imported").Add(float64(imported))
+	dhis2ImportValues.WithLabelValues(importType, "updated").Add(float64(updated))
+	dhis2ImportValues.WithLabelValues(importType, "ignored").Add(float64(ignored))
+	dhis2ImportValues.WithLabelValues(importType, "deleted").Add(float64(deleted))
+}
+
+// SyncRun counts a finished run of the named sync; err is what the run returned
+func SyncRun(sync string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	syncRuns.WithLabelValues(sync, result).Inc()
+}
+
+func observeDHIS2(instance, endpoint, method string, status int, took time.Duration) {
+	label := "error"
+	if status != 0 {
+		label = strconv.Itoa(status)
+	}
+	dhis2Requests.WithLabelValues(instance, endpoint, method, label).Observe(took.Seconds())
+}
@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// TaskMiddleware times the tasks processed by an asynq server and counts their outcomes: a
+// failed task is one that skipped or used up its retries.
+func TaskMiddleware(next asynq.Handler) asynq.Handler {
+	return asynq.HandlerFunc(func(ctx context.Context, task *asynq.Task) error {
+		start := time.Now()
+		err := next.ProcessTask(ctx, task)
+
+		outcome := "success"
+		if err != nil {
+			outcome = "retry"
+			retried, _ := asynq.GetRetryCount(ctx)
+			maxRetry, _ := asynq.GetMaxRetry(ctx)
+			if errors.Is(err, asynq.SkipRetry) || retried >= maxRetry {
+				outcome = "failed"
+			}
+		}
+		tasksProcessed.WithLabelValues(task.Type(), outcome).Inc()
+		taskDuration.WithLabelValues(task.Type(), outcome).Observe(time.Since(start).Seconds())
+		return err
+	})
+}
+
+// queueCollector reads the size of every task state of the gateway queues from Redis when
+// the metrics are scraped
+type queueCollector struct {
+	inspector *asynq.Inspector
+	queues    []string
+	tasks     *prometheus.Desc
+}
+
+// RegisterQueueCollector reports the tasks in each state of the given queues
+func RegisterQueueCollector(inspector *asynq.Inspector, queues []string) {
+	prometheus.MustRegister(&queueCollector{
+		inspector: inspector,
+		queues:    queues,
+		tasks: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "queue_tasks"),
+			"Tasks in an asynq queue, by queue and state (pending, active, scheduled, retry or archived).",
+			[]string{"queue", "state"}, nil),
+	})
+}
+
+func (c *queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.tasks
+}
+
+func (c *queueCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, queue := range c.queues {
+		info, err := c.inspector.GetQueueInfo(queue)
+		if errors.Is(err, asynq.ErrQueueNotFound) {
+			continue
+		}
+		if err != nil {
+			log.WithError(err).WithField("Queue", queue).Warn("Could not read queue size for metrics")
+			continue
+		}
+		for state, n := range map[string]int{
+			"pending":   info.Pending,
+			"active":    info.Active,
+			"scheduled": info.Scheduled,
+			"retry":     info.Retry,
+			"archived":  info.Archived,
+		} {
+			ch <- prometheus.MustNewConstMetric(c.tasks, prometheus.GaugeValue, float64(n), queue, state)
+		}
+	}
+}
@@ -2,9 +2,13 @@ package middleware
 
 import (
 	"database/sql"
+	authpkg "dhis2gw/auth"
+	"dhis2gw/config"
 	"dhis2gw/db"
 	"dhis2gw/models"
 	"encoding/base64"
+	"errors"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -24,17 +28,31 @@ func BasicAuth(dbConn *sqlx.DB, asynqClient *asynq.Client) gin.HandlerFunc {
 
 		auth := strings.SplitN(c.Request.Header.Get("Authorization"), " ", 2)
 
-		if len(auth) != 2 || (auth[0] != "Basic" && auth[0] != "Token") {
+		if len(auth) != 2 || (auth[0] != "Basic" && auth[0] != "Token" && auth[0] != "Bearer") {
 			RespondWithError(401, "Unauthorized - unknown authorization type", c)
 			return
 		}
+		if auth[0] == "Bearer" {
+			userID, err := AuthenticateJWT(auth[1])
+			if err != nil {
+				RespondWithError(401, "Unauthorized - "+err.Error(), c)
+				return
+			}
+			if !setAuthUser(c, userID) {
+				return
+			}
+			c.Next()
+			return
+		}
 		tokenAuthenticated, userUID := AuthenticateUserToken(auth[1])
 		if auth[0] == "Token" {
 			if !tokenAuthenticated {
 				RespondWithError(401, "Unauthorized - Token auth failed", c)
 				return
 			}
-			c.Set("currentUser", userUID)
+			if !setAuthUser(c, userUID) {
+				return
+			}
 			c.Next()
 			return
 		}
@@ -48,7 +66,9 @@ func BasicAuth(dbConn *sqlx.DB, asynqClient *asynq.Client) gin.HandlerFunc {
 			RespondWithError(401, "Unauthorized: Basic Auth failed", c)
 			return
 		}
-		c.Set("currentUser", userUID)
+		if !setAuthUser(c, userUID) {
+			return
+		}
 
 		c.Next()
 	}
@@ -72,6 +92,23 @@ func AuthenticateUser(username, password string) (bool, int64) {
 	return true, userID
 }
 
+// AuthenticateJWT validates an access JWT and returns the id of the user it was issued to
+func AuthenticateJWT(token string) (int64, error) {
+	secret := config.MustGet().Config.Server.JWTSecret
+	if secret == "" {
+		return 0, errors.New("Bearer auth is not enabled")
+	}
+	claims, err := authpkg.ParseJWT(token, []byte(secret))
+	if err != nil {
+		return 0, errors.New("Bearer token is invalid or expired")
+	}
+	userID, err := strconv.ParseInt(claims.UserID, 10, 64)
+	if err != nil {
+		return 0, errors.New("Bearer token has no valid subject")
+	}
+	return userID, nil
+}
+
 func AuthenticateUserToken(token string) (bool, int64) {
 	userToken := models.UserToken{}
 	err := db.GetDB().QueryRowx(
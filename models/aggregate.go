@@ -3,6 +3,9 @@ package models
 import (
 	"dhis2gw/config"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/HISP-Uganda/go-dhis2-sdk/aggregate"
@@ -11,63 +14,346 @@ import (
 )
 
 type AggregateRequest struct {
-	OrgUnit     string         `json:"orgUnit" example:"g8xY5g6WgXl"`
-	OrgUnitName string         `json:"orgUnitName,omitempty" example:"Health Center 1"`
-	Period      string         `json:"period" example:"202401"`
-	DataSet     string         `json:"dataSet" example:"pKxY5g6WgDm"`
-	DataValues  map[string]any `json:"dataValues"`
+	OrgUnit              string         `json:"orgUnit,omitempty" example:"g8xY5g6WgXl"`
+	OrgUnitCode          string         `json:"orgUnitCode,omitempty" example:"F0123"` // source system code, used when orgUnit is empty
+	OrgUnitName          string         `json:"orgUnitName,omitempty" example:"Health Center 1"`
+	Period               string         `json:"period" example:"202401"` // or a date, converted to the data set's period type
+	DataSet              string         `json:"dataSet" example:"pKxY5g6WgDm"`
+	AttributeOptionCombo string         `json:"attributeOptionCombo,omitempty" example:"HllvX50cXC0"` // defaults to api.dhis2_attribute_option_combo
+	DataValues           map[string]any `json:"dataValues"`
+	Instance             string         `json:"instance,omitempty" example:"hmis"`
+	Source               string         `json:"source,omitempty" example:"default"`
+	Async                *bool          `json:"async,omitempty" example:"false"`                    // overrides api.async_aggregate_import
+	Strict               *bool          `json:"strict,omitempty" example:"false"`                   // overrides api.strict_aggregate_mapping
+	SubmissionID         string         `json:"submissionId,omitempty" example:"msg-20240115-0042"` // idempotency key, same as the Idempotency-Key header
 }
 
+// DefaultInstanceName and DefaultSourceName select the mapping set, and for the instance
+// also the DHIS2 server, used when a request does not name one.
+const (
+	DefaultInstanceName = "default"
+	DefaultSourceName   = "default"
+)
+
 type AggregateResponse struct {
 	Message      string                 `json:"message" example:"Aggregate request queued for processing"`
 	Payload      map[string]interface{} `json:"payload"`
 	SubmissionID int64                  `json:"submission_id" example:"1034"`
 	TaskID       string                 `json:"task_id" example:"c5265e8f-2f15-4090-b25e-303d748adfce"`
+	Issues       []ConversionIssue      `json:"issues,omitempty"`
+	Replayed     bool                   `json:"replayed,omitempty"` // the idempotency key matched an earlier submission
+}
+
+// InstanceName returns the DHIS2 instance the request targets
+func (r *AggregateRequest) InstanceName() string {
+	if r.Instance == "" {
+		return DefaultInstanceName
+	}
+	return r.Instance
+}
+
+// SourceName returns the source system whose mappings apply to the request
+func (r *AggregateRequest) SourceName() string {
+	if r.Source == "" {
+		return DefaultSourceName
+	}
+	return r.Source
+}
+
+// ResolveOrgUnit fills in OrgUnit from OrgUnitCode when the request names its org unit by
+// the source system's code instead of a DHIS2 UID
+func (r *AggregateRequest) ResolveOrgUnit() error {
+	if r.OrgUnit != "" {
+		return nil
+	}
+	if r.OrgUnitCode == "" {
+		return fmt.Errorf("%w: either orgUnit or orgUnitCode is required", ErrOrgUnitNotResolved)
+	}
+	uid, err := ResolveOrgUnitCode(r.OrgUnitCode, r.SourceName(), r.InstanceName())
+	if err != nil {
+		return err
+	}
+	r.OrgUnit = uid
+	return nil
+}
+
+// UseAsync reports whether DHIS2 should import this submission as a background job.
+func (r *AggregateRequest) UseAsync() bool {
+	if r.Async != nil {
+		return *r.Async
+	}
+	return config.MustGet().Config.API.AsyncAggregateImport
+}
+
+// AttributeOptionComboUID returns the attribute option combo for the whole data value set
+func (r *AggregateRequest) AttributeOptionComboUID() string {
+	if r.AttributeOptionCombo != "" {
+		return r.AttributeOptionCombo
+	}
+	return config.MustGet().Config.API.DHIS2AttributeOptionCombo
 }
 
+// StrictMapping reports whether the request must be rejected when a code is unmapped
+func (r *AggregateRequest) StrictMapping() bool {
+	if r.Strict != nil {
+		return *r.Strict
+	}
+	return config.MustGet().Config.API.StrictAggregateMapping
+}
+
+// ToDHIS2AggregatePayload converts the request into a dataValueSets payload, dropping
+// any values that could not be converted.
 func (r *AggregateRequest) ToDHIS2AggregatePayload() aggregate.DataValueSetPayload {
-	dataValues := ConvertDataValuesToDHIS2DataValues(r.DataValues, "default", "default")
+	payload, _, err := r.BuildDHIS2AggregatePayload()
+	if err != nil {
+		log.WithError(err).Error("Failed to convert aggregate request")
+	}
+	return payload
+}
+
+// BuildDHIS2AggregatePayload converts the request into a dataValueSets payload and reports
+// every data value that was left out along the way.
+func (r *AggregateRequest) BuildDHIS2AggregatePayload() (aggregate.DataValueSetPayload, []ConversionIssue, error) {
+	dataValues, issues, err := ConvertDataValuesToDHIS2DataValues(r.DataValues, r.SourceName(), r.InstanceName())
 	dateNow := time.Now().Format("2006-01-02")
 	return aggregate.DataValueSetPayload{
-		DataSet:      r.DataSet,
-		Period:       r.Period,
-		OrgUnit:      r.OrgUnit,
-		CompleteDate: dateNow,
-		DataValues:   dataValues,
+		DataSet:              r.DataSet,
+		Period:               r.Period,
+		OrgUnit:              r.OrgUnit,
+		AttributeOptionCombo: r.AttributeOptionComboUID(),
+		CompleteDate:         dateNow,
+		DataValues:           dataValues,
+	}, issues, err
+}
+
+// Kinds of ConversionIssue
+const (
+	IssueUnmapped          = "unmapped"
+	IssueUnmappedDimension = "unmapped_dimension"
+	IssueInvalidType       = "invalid_type"
+	IssueEmptyValue        = "empty_value"
+)
+
+// ConversionIssue describes a submitted data value that was not sent to DHIS2, or, when Sent
+// is set, one that was sent but deserves the submitter's attention, such as an empty value
+// that deletes the value stored in DHIS2
+type ConversionIssue struct {
+	Code    string `json:"code" example:"ANC1"`
+	Kind    string `json:"kind" example:"unmapped"`
+	Value   any    `json:"value,omitempty" swaggertype:"string"`
+	Message string `json:"message" example:"no mapping for code ANC1"`
+	Sent    bool   `json:"sent,omitempty" example:"false"`
+}
+
+// HasUnmapped reports whether any of the issues is an unmapped code or dimension
+func HasUnmapped(issues []ConversionIssue) bool {
+	for _, issue := range issues {
+		if issue.Kind == IssueUnmapped || issue.Kind == IssueUnmappedDimension {
+			return true
+		}
 	}
+	return false
 }
 
-func ConvertDataValuesToDHIS2DataValues(requestDataValues map[string]any, source, instance string) []schema.DataValue {
-	dv := []schema.DataValue{}
+// ConvertDataValuesToDHIS2DataValues maps the submitted codes to DHIS2 data values using the
+// mapping set of the given source and instance. Nested objects such as {"Male": 3, "Female": 5}
+// are expanded through the mapping's dimension rows. Values that cannot be converted are
+// skipped and reported as issues, ordered by code; empty values are sent and reported too.
+func ConvertDataValuesToDHIS2DataValues(
+	requestDataValues map[string]any, source, instance string) ([]schema.DataValue, []ConversionIssue, error) {
+	conv := &dataValueConverter{dv: []schema.DataValue{}}
 	codedMapping, err := GetDhis2MappingsByCode(config.MustGet().Config.API.AggregateMappingScheme, source, instance)
 	if err != nil {
 		log.Debugf("Error getting code dimensions: %v", err)
-		return dv
-	}
-	for k, v := range requestDataValues {
-		// if k in codedMapping create schema.DataValue and add to dv
-		if value, ok := codedMapping[k]; ok {
-			// Convert v to string safely
-			var strVal string
-			switch vTyped := v.(type) {
-			case string:
-				strVal = vTyped
-			case fmt.Stringer:
-				strVal = vTyped.String()
-			case int, int32, int64, float32, float64, bool:
-				strVal = fmt.Sprintf("%v", vTyped)
-			default:
-				strVal = ""
+		return conv.dv, conv.issues, err
+	}
+
+	for _, k := range sortedKeys(requestDataValues) {
+		v := requestDataValues[k]
+		mapping, ok := codedMapping[k]
+		if !ok {
+			conv.issue(k, IssueUnmapped, v, fmt.Sprintf("no mapping for code %s (source %s, instance %s)", k, source, instance))
+			continue
+		}
+		if err := conv.convert(k, mapping, v, resolvedDimensions{}); err != nil {
+			return conv.dv, conv.issues, err
+		}
+	}
+	return conv.dv, conv.issues, nil
+}
+
+// resolvedDimensions holds the combos picked up while descending into a nested data value
+type resolvedDimensions struct {
+	coc string
+	aoc string
+}
+
+type dataValueConverter struct {
+	dv         []schema.DataValue
+	issues     []ConversionIssue
+	dimensions map[int64][]Dhis2MappingDimension
+}
+
+func (c *dataValueConverter) issue(code, kind string, value any, message string) {
+	c.issues = append(c.issues, ConversionIssue{Code: code, Kind: kind, Value: value, Message: message})
+}
+
+// dimensionsOf loads the dimension rows of a mapping the first time they are needed
+func (c *dataValueConverter) dimensionsOf(m *Dhis2Mapping) ([]Dhis2MappingDimension, error) {
+	if c.dimensions == nil {
+		c.dimensions = make(map[int64][]Dhis2MappingDimension)
+	}
+	if dims, ok := c.dimensions[m.ID]; ok {
+		return dims, nil
+	}
+	loaded, err := GetMappingDimensions([]int64{m.ID})
+	if err != nil {
+		return nil, err
+	}
+	c.dimensions[m.ID] = loaded[m.ID]
+	return loaded[m.ID], nil
+}
+
+func (c *dataValueConverter) convert(path string, m *Dhis2Mapping, v any, dims resolvedDimensions) error {
+	if nested, ok := v.(map[string]any); ok && !isAggregateValueObject(nested) {
+		mappingDims, err := c.dimensionsOf(m)
+		if err != nil {
+			return err
+		}
+		for _, field := range sortedKeys(nested) {
+			fieldPath := path + "." + field
+			dim, found := FindDimension(mappingDims, field)
+			if !found {
+				c.issue(fieldPath, IssueUnmappedDimension, nested[field],
+					fmt.Sprintf("no dimension %s for code %s", field, path))
+				continue
+			}
+			next := dims
+			if dim.Type == DimensionTypeAttribution {
+				if next.aoc != "" {
+					c.issue(fieldPath, IssueInvalidType, nested[field], "more than one attribution dimension")
+					continue
+				}
+				next.aoc = dim.CategoryOptionCombo
+			} else {
+				if next.coc != "" {
+					c.issue(fieldPath, IssueInvalidType, nested[field], "more than one disaggregation dimension")
+					continue
+				}
+				next.coc = dim.CategoryOptionCombo
 			}
-			dataValue := schema.DataValue{
-				DataElement:         &value.DataElement,
-				Value:               &strVal,
-				CategoryOptionCombo: value.CategoryOptionCombo,
+			if err := c.convert(fieldPath, m, nested[field], next); err != nil {
+				return err
 			}
-			dv = append(dv, dataValue)
-		} else {
-			// key does not exist
 		}
+		return nil
+	}
+
+	av, _ := parseAggregateValue(v)
+	strVal, ok := dataValueString(av.Value)
+	if !ok {
+		c.issue(path, IssueInvalidType, v, fmt.Sprintf("unsupported value type %T", av.Value))
+		return nil
+	}
+	// An empty value is sent as is: DHIS2 deletes the stored value, which is how submitters
+	// clear a value they reported before. It is still reported, in case that was not meant.
+	strVal = strings.TrimSpace(strVal)
+	if strVal == "" {
+		c.issues = append(c.issues, ConversionIssue{Code: path, Kind: IssueEmptyValue, Sent: true,
+			Message: "value is empty; DHIS2 deletes the stored value"})
+	}
+	dataValue := schema.DataValue{
+		DataElement:         &m.DataElement,
+		Value:               &strVal,
+		CategoryOptionCombo: m.CategoryOptionCombo,
+	}
+	if dims.coc != "" {
+		coc := dims.coc
+		dataValue.CategoryOptionCombo = &coc
+	}
+	if dims.aoc != "" {
+		aoc := dims.aoc
+		dataValue.AttributeOptionCombo = &aoc
+	}
+	av.apply(&dataValue)
+	c.dv = append(c.dv, dataValue)
+	return nil
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// AggregateValue is the object form of a submitted data value. It lets submitters
+// override the mapped category option combo and attach a comment or follow-up flag.
+type AggregateValue struct {
+	Value    any    `json:"value" swaggertype:"string" example:"12"`
+	COC      string `json:"coc,omitempty" example:"HllvX50cXC0"`
+	Comment  string `json:"comment,omitempty" example:"Late report"`
+	FollowUp bool   `json:"followUp,omitempty"`
+}
+
+// isAggregateValueObject reports whether an object is an AggregateValue rather than a
+// map of dimensions
+func isAggregateValueObject(m map[string]any) bool {
+	_, hasValue := m["value"]
+	return hasValue
+}
+
+// parseAggregateValue accepts either a scalar or an AggregateValue object
+func parseAggregateValue(v any) (AggregateValue, bool) {
+	m, isObject := v.(map[string]any)
+	if !isObject {
+		return AggregateValue{Value: v}, true
+	}
+	if !isAggregateValueObject(m) {
+		return AggregateValue{}, false
+	}
+	av := AggregateValue{Value: m["value"]}
+	av.COC, _ = m["coc"].(string)
+	av.Comment, _ = m["comment"].(string)
+	av.FollowUp, _ = m["followUp"].(bool)
+	return av, true
+}
+
+// apply copies the per-value overrides onto dv
+func (av AggregateValue) apply(dv *schema.DataValue) {
+	if av.COC != "" {
+		coc := av.COC
+		dv.CategoryOptionCombo = &coc
+	}
+	if av.Comment != "" {
+		comment := av.Comment
+		dv.Comment = &comment
+	}
+	if av.FollowUp {
+		followUp := true
+		dv.Followup = &followUp
+	}
+}
+
+// dataValueString renders a scalar JSON value as a DHIS2 value string
+func dataValueString(v any) (string, bool) {
+	switch vTyped := v.(type) {
+	case nil:
+		return "", true
+	case string:
+		return vTyped, true
+	case float64:
+		// JSON numbers decode as float64; avoid exponent notation for large counts
+		return strconv.FormatFloat(vTyped, 'f', -1, 64), true
+	case float32:
+		return strconv.FormatFloat(float64(vTyped), 'f', -1, 32), true
+	case int, int32, int64, bool:
+		return fmt.Sprintf("%v", vTyped), true
+	case fmt.Stringer:
+		return vTyped.String(), true
+	default:
+		return "", false
 	}
-	return dv
 }
@@ -0,0 +1,139 @@
+package models
+
+import (
+	"fmt"
+
+	"github.com/HISP-Uganda/go-dhis2-sdk/dhis2/schema"
+)
+
+// BulkAggregateRequest is the body accepted by POST /aggregate/bulk. Each block is an
+// AggregateRequest for one org unit, period and data set, without a submissionId.
+type BulkAggregateRequest struct {
+	Blocks []AggregateRequest `json:"blocks"`
+}
+
+// BulkBlockResult reports whether one block of a bulk request was accepted
+type BulkBlockResult struct {
+	Index        int               `json:"index" example:"0"`
+	Accepted     bool              `json:"accepted" example:"true"`
+	OrgUnit      string            `json:"orgUnit,omitempty" example:"g8xY5g6WgXl"`
+	Period       string            `json:"period,omitempty" example:"202401"`
+	DataSet      string            `json:"dataSet,omitempty" example:"pKxY5g6WgDm"`
+	SubmissionID int64             `json:"submission_id,omitempty" example:"1034"`
+	Error        string            `json:"error,omitempty"`
+	Detail       any               `json:"detail,omitempty" swaggertype:"object"`
+	Issues       []ConversionIssue `json:"issues,omitempty"`
+}
+
+// BulkGroup is one combined DHIS2 import queued for a bulk request
+type BulkGroup struct {
+	SubmissionID int64  `json:"submission_id" example:"1034"`
+	TaskID       string `json:"task_id" example:"c5265e8f-2f15-4090-b25e-303d748adfce"`
+	Blocks       int    `json:"blocks" example:"120"`
+	DataValues   int    `json:"dataValues" example:"4800"`
+}
+
+type BulkAggregateResponse struct {
+	Message  string            `json:"message" example:"Bulk aggregate request queued for processing"`
+	BatchID  string            `json:"batch_id,omitempty" example:"Xk2jP9wQmL1"`
+	Accepted int               `json:"accepted" example:"798"`
+	Rejected int               `json:"rejected" example:"2"`
+	Groups   []BulkGroup       `json:"groups"`
+	Results  []BulkBlockResult `json:"results"`
+}
+
+// CombinedDataValueSet is a dataValueSets payload of one data set without a common org unit
+// or period; every data value carries its own, so blocks for many org units and periods go to
+// DHIS2 in one import. DHIS2 only registers data set completion for payloads with an org unit
+// and period, so the blocks are completed through Registrations once DHIS2 has imported the
+// values.
+type CombinedDataValueSet struct {
+	DataSet       string                       `json:"dataSet,omitempty"`
+	DataValues    []schema.DataValue           `json:"dataValues"`
+	Registrations CompleteDataSetRegistrations `json:"-"`
+}
+
+// CompleteDataSetRegistration marks a data set complete for an org unit and period
+type CompleteDataSetRegistration struct {
+	DataSet              string `json:"dataSet"`
+	Period               string `json:"period"`
+	OrganisationUnit     string `json:"organisationUnit"`
+	AttributeOptionCombo string `json:"attributeOptionCombo,omitempty"`
+	Completed            bool   `json:"completed"`
+}
+
+// CompleteDataSetRegistrations is the body of a DHIS2 completeDataSetRegistrations import
+type CompleteDataSetRegistrations struct {
+	CompleteDataSetRegistrations []CompleteDataSetRegistration `json:"completeDataSetRegistrations"`
+}
+
+// CombineAggregateRequests converts the blocks, which must share a data set, and merges their
+// data values into one payload. Each block registers the data set complete, as a single
+// submission does through its completeDate.
+func CombineAggregateRequests(blocks []AggregateRequest) (CombinedDataValueSet, error) {
+	combined := CombinedDataValueSet{DataValues: []schema.DataValue{}}
+	registered := make(map[CompleteDataSetRegistration]bool)
+	for i := range blocks {
+		payload, _, err := blocks[i].BuildDHIS2AggregatePayload()
+		if err != nil {
+			return combined, fmt.Errorf("block %d: %w", i, err)
+		}
+		if combined.DataSet == "" {
+			combined.DataSet = payload.DataSet
+		} else if payload.DataSet != combined.DataSet {
+			return combined, fmt.Errorf("block %d: data set %s differs from %s", i, payload.DataSet, combined.DataSet)
+		}
+		for _, dv := range payload.DataValues {
+			orgUnit, period := payload.OrgUnit, payload.Period
+			dv.OrgUnit, dv.Period = &orgUnit, &period
+			if dv.AttributeOptionCombo == nil && payload.AttributeOptionCombo != "" {
+				aoc := payload.AttributeOptionCombo
+				dv.AttributeOptionCombo = &aoc
+			}
+			combined.DataValues = append(combined.DataValues, dv)
+		}
+		reg := CompleteDataSetRegistration{
+			DataSet:              payload.DataSet,
+			Period:               payload.Period,
+			OrganisationUnit:     payload.OrgUnit,
+			AttributeOptionCombo: payload.AttributeOptionCombo,
+			Completed:            true,
+		}
+		if !registered[reg] {
+			registered[reg] = true
+			combined.Registrations.CompleteDataSetRegistrations = append(
+				combined.Registrations.CompleteDataSetRegistrations, reg)
+		}
+	}
+	return combined, nil
+}
+
+// GroupAggregateBlocks splits blocks into groups that can be imported together: blocks of a
+// group target the same instance, data set and import mode, and together hold at most
+// maxValues data values, counted by values. A block larger than maxValues forms a group of
+// its own. The groups hold indexes into blocks, in submission order.
+func GroupAggregateBlocks(blocks []AggregateRequest, values []int, maxValues int) [][]int {
+	type groupKey struct {
+		instance string
+		dataSet  string
+		async    bool
+	}
+	var (
+		groups [][]int
+		open   = make(map[groupKey]int) // group currently filling for each key
+		sizes  []int
+	)
+	for i := range blocks {
+		key := groupKey{instance: blocks[i].InstanceName(), dataSet: blocks[i].DataSet, async: blocks[i].UseAsync()}
+		g, ok := open[key]
+		if !ok || (maxValues > 0 && sizes[g]+values[i] > maxValues) {
+			groups = append(groups, nil)
+			sizes = append(sizes, 0)
+			g = len(groups) - 1
+			open[key] = g
+		}
+		groups[g] = append(groups[g], i)
+		sizes[g] += values[i]
+	}
+	return groups
+}
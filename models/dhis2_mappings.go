@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"dhis2gw/db"
+	"dhis2gw/utils"
 	"encoding/csv"
 	"errors"
 	"fmt"
@@ -14,6 +15,7 @@ import (
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	log "github.com/sirupsen/logrus"
 	"github.com/xuri/excelize/v2"
 )
@@ -52,6 +54,208 @@ type Dhis2MappingDimension struct {
 	Updated             time.Time `db:"updated" json:"updated"`
 }
 
+// Dimension types of a Dhis2MappingDimension
+const (
+	DimensionTypeAttribution    = "attribution"
+	DimensionTypeDisaggregation = "disaggregation"
+)
+
+// mappingDimensionColumns lists the dhis2_mapping_dimension columns scanned into Dhis2MappingDimension
+const mappingDimensionColumns = `id, mapping_id, source_field, COALESCE(source_label, '') AS source_label,
+	category_option, category_option_combo, COALESCE(type, '') AS type,
+	COALESCE(dimension_group, '') AS dimension_group, COALESCE(created, now()) AS created,
+	COALESCE(updated, now()) AS updated`
+
+// GetMappingDimensions returns the dimension rows of the given mappings keyed by mapping id
+func GetMappingDimensions(mappingIDs []int64) (map[int64][]Dhis2MappingDimension, error) {
+	dims := make(map[int64][]Dhis2MappingDimension)
+	if len(mappingIDs) == 0 {
+		return dims, nil
+	}
+	var rows []Dhis2MappingDimension
+	err := db.GetDB().Select(&rows, `SELECT `+mappingDimensionColumns+`
+		FROM dhis2_mapping_dimension WHERE mapping_id = ANY($1) ORDER BY id`, pq.Array(mappingIDs))
+	if err != nil {
+		log.WithError(err).Error("Failed to get mapping dimensions")
+		return nil, err
+	}
+	for _, d := range rows {
+		dims[d.MappingID] = append(dims[d.MappingID], d)
+	}
+	return dims, nil
+}
+
+// FindDimension returns the dimension whose source field, or failing that label, matches field
+func FindDimension(dims []Dhis2MappingDimension, field string) (Dhis2MappingDimension, bool) {
+	for _, d := range dims {
+		if d.SourceField == field {
+			return d, true
+		}
+	}
+	for _, d := range dims {
+		if strings.EqualFold(d.SourceField, field) || (d.SourceLabel != "" && strings.EqualFold(d.SourceLabel, field)) {
+			return d, true
+		}
+	}
+	return Dhis2MappingDimension{}, false
+}
+
+// Validate checks the required fields and UIDs of a mapping dimension
+func (d *Dhis2MappingDimension) Validate() error {
+	var problems []string
+	if strings.TrimSpace(d.SourceField) == "" {
+		problems = append(problems, "sourceField is required")
+	}
+	if !utils.ValidUID(d.CategoryOption) {
+		problems = append(problems, "categoryOption must be a valid UID")
+	}
+	if !utils.ValidUID(d.CategoryOptionCombo) {
+		problems = append(problems, "categoryOptionCombo must be a valid UID")
+	}
+	if d.Type != DimensionTypeAttribution && d.Type != DimensionTypeDisaggregation {
+		problems = append(problems, fmt.Sprintf("type must be %q or %q", DimensionTypeAttribution, DimensionTypeDisaggregation))
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// GetMappingDimension returns a dimension of the given mapping
+func GetMappingDimension(mappingID, id int64) (*Dhis2MappingDimension, error) {
+	var dim Dhis2MappingDimension
+	err := db.GetDB().Get(&dim, `SELECT `+mappingDimensionColumns+`
+		FROM dhis2_mapping_dimension WHERE mapping_id = $1 AND id = $2`, mappingID, id)
+	if err != nil {
+		return nil, err
+	}
+	return &dim, nil
+}
+
+// Insert adds the dimension, fills in its id and timestamps and records it in the mapping
+// history as a change by userID
+func (d *Dhis2MappingDimension) Insert(userID int64) error {
+	return withMappingChanges(userID, ChangeSourceAPI, "create mapping dimension", func(r *changeRecorder) error {
+		mapping, err := lockMapping(r.tx, d.MappingID)
+		if err != nil {
+			return err
+		}
+		if mapping == nil {
+			return sql.ErrNoRows
+		}
+		if err := checkDimensionGroup(r.tx, d); err != nil {
+			return err
+		}
+		rows, err := r.tx.NamedQuery(`
+		INSERT INTO dhis2_mapping_dimension (mapping_id, source_field, source_label, category_option,
+			category_option_combo, type, dimension_group)
+		VALUES (:mapping_id, :source_field, NULLIF(:source_label, ''), :category_option,
+			:category_option_combo, :type, NULLIF(:dimension_group, ''))
+		RETURNING id`, d)
+		if err != nil {
+			log.WithError(err).Error("Failed to insert mapping dimension")
+			return err
+		}
+		if rows.Next() {
+			err = rows.Scan(&d.ID)
+		}
+		_ = rows.Close()
+		if err != nil {
+			return err
+		}
+		created, err := lockDimension(r.tx, d.ID)
+		if err != nil {
+			return err
+		}
+		d.Created, d.Updated = created.Created, created.Updated
+		return r.recordDimension(mapping, MappingOpCreate, nil, created)
+	})
+}
+
+const updateMappingDimensionSQL = `
+		UPDATE dhis2_mapping_dimension SET source_field = :source_field, source_label = NULLIF(:source_label, ''),
+			category_option = :category_option, category_option_combo = :category_option_combo,
+			type = :type, dimension_group = NULLIF(:dimension_group, ''), updated = NOW()
+		WHERE id = :id AND mapping_id = :mapping_id`
+
+// Update saves the dimension, recording the old and new values as a change by userID
+func (d *Dhis2MappingDimension) Update(userID int64) error {
+	return withMappingChanges(userID, ChangeSourceAPI, "update mapping dimension", func(r *changeRecorder) error {
+		mapping, old, err := lockMappingDimension(r.tx, d.MappingID, d.ID)
+		if err != nil {
+			return err
+		}
+		if err := checkDimensionGroup(r.tx, d); err != nil {
+			return err
+		}
+		if _, err := r.tx.NamedExec(updateMappingDimensionSQL, d); err != nil {
+			log.WithError(err).Error("Failed to update mapping dimension")
+			return err
+		}
+		updated, err := lockDimension(r.tx, d.ID)
+		if err != nil {
+			return err
+		}
+		return r.recordDimension(mapping, MappingOpUpdate, old, updated)
+	})
+}
+
+// Delete removes the dimension, recording the removed values as a change by userID
+func (d *Dhis2MappingDimension) Delete(userID int64) error {
+	return withMappingChanges(userID, ChangeSourceAPI, "delete mapping dimension", func(r *changeRecorder) error {
+		mapping, old, err := lockMappingDimension(r.tx, d.MappingID, d.ID)
+		if err != nil {
+			return err
+		}
+		if _, err := r.tx.Exec(`DELETE FROM dhis2_mapping_dimension WHERE id = $1`, d.ID); err != nil {
+			log.WithError(err).Error("Failed to delete mapping dimension")
+			return err
+		}
+		return r.recordDimension(mapping, MappingOpDelete, old, nil)
+	})
+}
+
+// ErrDimensionGroupConflict is returned when a dimension would give its mapping dimensions of
+// the same type in more than one dimension group
+var ErrDimensionGroupConflict = errors.New("dimensions of the same type must belong to one dimension group")
+
+// checkDimensionGroup rejects a dimension whose group differs from that of the other dimensions
+// of its type on the mapping. Each dimension resolves to a whole category option combo, so a
+// value nested under two disaggregation groups, such as sex and age, has no single combo to
+// resolve to and the converter could only honour one of them.
+func checkDimensionGroup(tx *sqlx.Tx, d *Dhis2MappingDimension) error {
+	var groups []string
+	err := tx.Select(&groups, `SELECT DISTINCT COALESCE(dimension_group, '') FROM dhis2_mapping_dimension
+		WHERE mapping_id = $1 AND type = $2 AND id <> $3`, d.MappingID, d.Type, d.ID)
+	if err != nil {
+		log.WithError(err).Error("Failed to check mapping dimension groups")
+		return err
+	}
+	for _, g := range groups {
+		if g != d.DimensionGroup {
+			return fmt.Errorf("%w: the mapping has %s dimensions in group %q", ErrDimensionGroupConflict, d.Type, g)
+		}
+	}
+	return nil
+}
+
+// lockMappingDimension locks a dimension and its mapping, failing with sql.ErrNoRows when the
+// dimension does not belong to the mapping
+func lockMappingDimension(tx *sqlx.Tx, mappingID, id int64) (*Dhis2Mapping, *Dhis2MappingDimension, error) {
+	mapping, err := lockMapping(tx, mappingID)
+	if err != nil {
+		return nil, nil, err
+	}
+	dim, err := lockDimension(tx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if mapping == nil || dim == nil || dim.MappingID != mappingID {
+		return nil, nil, sql.ErrNoRows
+	}
+	return mapping, dim, nil
+}
+
 type MappingsFilter struct {
 	Code                *string   `json:"code,omitempty"`
 	What                *string   `json:"what,omitempty"`
@@ -75,52 +279,161 @@ type MappingsFilter struct {
 const insertDhis2MappingSQL = `
 INSERT INTO dhis2_mappings(code, what, name, description, dataset, dataelement, 
     category_option_combo, category_option, category_combo, instance_name, source_name, source_orgunit, 
-	dest_orgunit, created, updated)
+	dest_orgunit, dimension_type, created, updated)
 VALUES(:code, :what, :name, :description, :dataset, :dataelement, 
     :category_option_combo, :category_option, :category_combo, :instance_name, :source_name, :source_orgunit, 
-	:dest_orgunit, NOW(), NOW()) 
+	:dest_orgunit, COALESCE(NULLIF(:dimension_type, ''), 'none'), NOW(), NOW()) 
 ON CONFLICT ON CONSTRAINT unique_code_source_instance_what
 DO NOTHING 
-RETURNING id`
+RETURNING id, uid`
 
-// Insert adds a new Dhis2Mapping
-func (d *Dhis2Mapping) Insert() (int64, error) {
-	dbConn := db.GetDB()
-	var id int64
-	err := dbConn.QueryRowx(insertDhis2MappingSQL, d).Scan(&id)
+// ErrMappingExists is returned when a mapping with the same code, what, source and instance exists
+var ErrMappingExists = errors.New("mapping with the same code, what, source and instance already exists")
+
+// Insert adds a new Dhis2Mapping, recording it in the mapping history as a change by userID
+func (d *Dhis2Mapping) Insert(userID int64) (int64, error) {
+	err := withMappingChanges(userID, ChangeSourceAPI, "create mapping", func(r *changeRecorder) error {
+		if err := insertMappingTx(r.tx, d); err != nil {
+			return err
+		}
+		created, err := lockMapping(r.tx, d.ID)
+		if err != nil {
+			return err
+		}
+		return r.record(MappingOpCreate, nil, created)
+	})
 	if err != nil {
-		log.WithError(err).Error("Failed to insert Dhis2Mapping")
 		return 0, err
 	}
-	return id, nil
+	return d.ID, nil
 }
 
-// Update updates a Dhis2Mapping
-func (d *Dhis2Mapping) Update() error {
-	dbConn := db.GetDB()
-	_, err := dbConn.NamedExec(`
+func insertMappingTx(tx *sqlx.Tx, d *Dhis2Mapping) error {
+	rows, err := tx.NamedQuery(insertDhis2MappingSQL, d)
+	if err != nil {
+		log.WithError(err).Error("Failed to insert Dhis2Mapping")
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return ErrMappingExists
+	}
+	return rows.Scan(&d.ID, &d.UID)
+}
+
+const updateDhis2MappingSQL = `
     UPDATE dhis2_mappings SET name = :name, what = :what, description = :description, dataset = :dataset, 
     dataelement = :dataelement, category_option_combo = :category_option_combo,
 	category_option = :category_option, category_combo = :category_combo,
 	instance_name = :instance_name, source_name = :source_name,
 	source_orgunit = :source_orgunit, dest_orgunit = :dest_orgunit,
-    updated = NOW() WHERE uid = :uid`, d)
-	if err != nil {
-		log.WithError(err).Error("Failed to update Dhis2Mapping")
-		return err
+	dimension_type = COALESCE(NULLIF(:dimension_type, ''), 'none'),
+    updated = NOW() WHERE uid = :uid`
+
+// Update updates a Dhis2Mapping, recording the old and new values as a change by userID
+func (d *Dhis2Mapping) Update(userID int64) error {
+	return withMappingChanges(userID, ChangeSourceAPI, "update mapping", func(r *changeRecorder) error {
+		var old Dhis2Mapping
+		if err := r.tx.Get(&old, `SELECT * FROM dhis2_mappings WHERE uid = $1 FOR UPDATE`, d.UID); err != nil {
+			return err
+		}
+		if _, err := r.tx.NamedExec(updateDhis2MappingSQL, d); err != nil {
+			log.WithError(err).Error("Failed to update Dhis2Mapping")
+			return err
+		}
+		updated, err := lockMapping(r.tx, old.ID)
+		if err != nil {
+			return err
+		}
+		return r.record(MappingOpUpdate, &old, updated)
+	})
+}
+
+// Delete a Dhis2Mapping, recording the removed values as a change by userID
+func (d *Dhis2Mapping) Delete(userID int64) error {
+	return withMappingChanges(userID, ChangeSourceAPI, "delete mapping", func(r *changeRecorder) error {
+		old, err := lockMapping(r.tx, d.ID)
+		if err != nil || old == nil {
+			return err
+		}
+		if err := r.deleteMappingDimensions(old); err != nil {
+			return err
+		}
+		if _, err := r.tx.Exec("DELETE FROM dhis2_mappings WHERE id = $1", d.ID); err != nil {
+			log.WithError(err).Error("Failed to delete Dhis2Mapping")
+			return err
+		}
+		return r.record(MappingOpDelete, old, nil)
+	})
+}
+
+// Mapping kinds stored in the what column
+const (
+	MappingWhatDataElement = "de"
+	MappingWhatOrgUnit     = "ou"
+)
+
+// Validate checks that the mapping has the fields its kind needs and that the DHIS2
+// references look like UIDs.
+func (d *Dhis2Mapping) Validate() error {
+	var problems []string
+	if strings.TrimSpace(d.Name) == "" {
+		problems = append(problems, "name is required")
+	}
+	if d.InstanceName == "" || d.SourceName == "" {
+		problems = append(problems, "instanceName and sourceName are required")
+	}
+	switch d.What {
+	case MappingWhatDataElement:
+		if d.Code == "" || d.DataElement == "" {
+			problems = append(problems, "code and dataElement are required for data element mappings")
+		}
+	case MappingWhatOrgUnit:
+		if d.SourceOrgUnit == "" || d.DestinationOrgUnit == "" {
+			problems = append(problems, "sourceOrgUnit and destinationOrgUnit are required for org unit mappings")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("what must be %q or %q", MappingWhatDataElement, MappingWhatOrgUnit))
+	}
+	coc := ""
+	if d.CategoryOptionCombo != nil {
+		coc = *d.CategoryOptionCombo
+	}
+	refs := [][2]string{
+		{"dataSet", d.DataSet}, {"dataElement", d.DataElement}, {"categoryOptionCombo", coc},
+		{"categoryOption", d.CategoryOption}, {"categoryCombo", d.CategoryCombo},
+		{"destinationOrgUnit", d.DestinationOrgUnit},
+	}
+	for _, ref := range refs {
+		if ref[1] != "" && !utils.ValidUID(ref[1]) {
+			problems = append(problems, ref[0]+" is not a valid UID")
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
 	}
 	return nil
 }
 
-// Delete a Dhis2Mapping
-func (d *Dhis2Mapping) Delete() error {
-	dbConn := db.GetDB()
-	_, err := dbConn.Exec("DELETE FROM dhis2_mappings WHERE id = $1", d.ID)
-	if err != nil {
-		log.WithError(err).Error("Failed to delete Dhis2Mapping")
-		return err
+// GetMappingByUID returns the mapping with the given uid
+func GetMappingByUID(uid string) (*Dhis2Mapping, error) {
+	var mapping Dhis2Mapping
+	if err := db.GetDB().Get(&mapping, `SELECT * FROM dhis2_mappings WHERE uid = $1`, uid); err != nil {
+		return nil, err
 	}
-	return nil
+	return &mapping, nil
+}
+
+// GetMappingByID returns the mapping with the given id
+func GetMappingByID(id int64) (*Dhis2Mapping, error) {
+	var mapping Dhis2Mapping
+	if err := db.GetDB().Get(&mapping, `SELECT * FROM dhis2_mappings WHERE id = $1`, id); err != nil {
+		return nil, err
+	}
+	return &mapping, nil
 }
 
 func (d *Dhis2Mapping) DbID() int64 {
@@ -134,13 +447,13 @@ func (d *Dhis2Mapping) DbID() int64 {
 }
 
 // InsertOrUpdate a Dhis2Mapping
-func (d *Dhis2Mapping) InsertOrUpdate() error {
+func (d *Dhis2Mapping) InsertOrUpdate(userID int64) error {
 	if d.ID == 0 {
-		_, err := d.Insert()
+		_, err := d.Insert(userID)
 		return err
 	}
 	d.ID = d.DbID()
-	return d.Update()
+	return d.Update(userID)
 }
 
 // GetDhis2Mappings returns a map[string]*Dhis2Mapping where the key is the name of the mapping
@@ -361,6 +674,12 @@ func ParseDhis2MappingCSV(file multipart.File) ([]Dhis2Mapping, error) {
 		if idx, ok := headerMap["source_name"]; ok {
 			m.SourceName = record[idx]
 		}
+		if idx, ok := headerMap["source_orgunit"]; ok {
+			m.SourceOrgUnit = record[idx]
+		}
+		if idx, ok := headerMap["destination_orgunit"]; ok {
+			m.DestinationOrgUnit = record[idx]
+		}
 		mappings = append(mappings, m)
 	}
 	return mappings, nil
@@ -450,6 +769,18 @@ func GetAllMappings() ([]Dhis2Mapping, error) {
 	return mappings, nil
 }
 
+// GetMappingsByUIDs returns the mappings with the given UIDs
+func GetMappingsByUIDs(uids []string) ([]Dhis2Mapping, error) {
+	dbConn := db.GetDB()
+	var mappings []Dhis2Mapping
+	err := dbConn.Select(&mappings, "SELECT * FROM dhis2_mappings WHERE uid = ANY($1)", pq.Array(uids))
+	if err != nil {
+		log.WithError(err).Error("Failed to get Dhis2Mappings by UID")
+		return nil, err
+	}
+	return mappings, nil
+}
+
 // GenerateDhis2MappingExcel generates an Excel file from a slice of Dhis2Mapping
 func GenerateDhis2MappingExcel(mappings []Dhis2Mapping) (*excelize.File, error) {
 	f := excelize.NewFile()
@@ -530,6 +861,48 @@ func GetOrgUnitMapping(sourceOrgUnit, instanceName string) (string, error) {
 	return "", nil
 }
 
+// ErrOrgUnitNotResolved is returned when a source org unit code matches no DHIS2 org unit
+var ErrOrgUnitNotResolved = errors.New("org unit code could not be resolved to a DHIS2 org unit")
+
+// orgUnitResolvers are tried in order; the first that matches decides the org unit
+var orgUnitResolvers = []struct {
+	via      string
+	bySource bool // query also takes the source and instance names
+	query    string
+}{
+	{"ou mapping", true, `SELECT DISTINCT dest_orgunit FROM dhis2_mappings
+		WHERE what = 'ou' AND source_orgunit = $1 AND source_name = $2 AND instance_name = $3 AND dest_orgunit <> ''`},
+	{"organisationunit.code", false, `SELECT uid FROM organisationunit WHERE code = $1 AND NOT deleted`},
+	{"organisationunit.mflid", false, `SELECT uid FROM organisationunit WHERE mflid = $1 AND NOT deleted`},
+}
+
+// ResolveOrgUnitCode returns the DHIS2 UID of a source system's org unit code. The OU
+// mappings of the source and instance are tried first, then organisationunit.code and mflid.
+func ResolveOrgUnitCode(code, sourceName, instanceName string) (string, error) {
+	dbConn := db.GetDB()
+	for _, r := range orgUnitResolvers {
+		args := []interface{}{code}
+		if r.bySource {
+			args = append(args, sourceName, instanceName)
+		}
+		var uids []string
+		if err := dbConn.Select(&uids, r.query, args...); err != nil {
+			log.WithError(err).WithField("Via", r.via).Error("Failed to resolve org unit code")
+			return "", err
+		}
+		switch len(uids) {
+		case 0:
+			continue
+		case 1:
+			return uids[0], nil
+		default:
+			return "", fmt.Errorf("%w: code %q matches %d org units via %s", ErrOrgUnitNotResolved, code, len(uids), r.via)
+		}
+	}
+	return "", fmt.Errorf("%w: no OU mapping for source %q and instance %q, and no org unit with code or mflid %q",
+		ErrOrgUnitNotResolved, sourceName, instanceName, code)
+}
+
 // GetDataElementMapping returns the data element mapping for a given code and instance
 func GetDataElementMapping(code, instanceName string) (*Dhis2Mapping, error) {
 	dbConn := db.GetDB()
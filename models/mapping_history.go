@@ -0,0 +1,553 @@
+package models
+
+import (
+	"database/sql"
+	"dhis2gw/db"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+)
+
+// Where a mapping change set came from
+const (
+	ChangeSourceAPI      = "api"
+	ChangeSourceImport   = "import"
+	ChangeSourceRollback = "rollback"
+)
+
+// Operations recorded in dhis2_mappings_history
+const (
+	MappingOpCreate = "create"
+	MappingOpUpdate = "update"
+	MappingOpDelete = "delete"
+)
+
+var (
+	// ErrChangeSetRolledBack is returned when rolling back a change set a second time
+	ErrChangeSetRolledBack = errors.New("change set has already been rolled back")
+	// ErrRollbackConflict is returned when a mapping changed again after the change set being rolled back
+	ErrRollbackConflict = errors.New("mapping changed after this change set")
+)
+
+// MappingChangeSet groups the mapping changes made by one API call, import or rollback
+type MappingChangeSet struct {
+	ID           int64     `db:"id" json:"id"`
+	UID          string    `db:"uid" json:"uid" example:"Xy12AbC34De"`
+	Source       string    `db:"source" json:"source" example:"import"`
+	Description  string    `db:"description" json:"description,omitempty" example:"csv import (upsert)"`
+	UserID       *int64    `db:"user_id" json:"userId,omitempty"`
+	RolledBackBy *int64    `db:"rolled_back_by" json:"rolledBackBy,omitempty"`
+	Changes      int       `db:"changes" json:"changes" example:"12"`
+	Created      time.Time `db:"created" json:"created"`
+}
+
+// MappingHistoryEntry is one recorded change to a mapping or, when DimensionID is set, to one
+// of its dimensions. The values are snapshots of the mapping or the dimension.
+type MappingHistoryEntry struct {
+	ID          int64           `db:"id" json:"id"`
+	ChangeSetID int64           `db:"change_set_id" json:"changeSetId"`
+	MappingID   int64           `db:"mapping_id" json:"mappingId"`
+	MappingUID  string          `db:"mapping_uid" json:"mappingUid"`
+	DimensionID *int64          `db:"dimension_id" json:"dimensionId,omitempty"`
+	Operation   string          `db:"operation" json:"operation" example:"update"`
+	OldValue    json.RawMessage `db:"old_value" json:"oldValue" swaggertype:"object"`
+	NewValue    json.RawMessage `db:"new_value" json:"newValue" swaggertype:"object"`
+	UserID      *int64          `db:"user_id" json:"userId,omitempty"`
+	Created     time.Time       `db:"created" json:"created"`
+}
+
+// MappingStateDiff describes how a mapping differs between two points in time
+type MappingStateDiff struct {
+	MappingID int64         `json:"mappingId"`
+	UID       string        `json:"uid"`
+	Code      string        `json:"code"`
+	Action    string        `json:"action" example:"updated"`
+	Changes   []FieldChange `json:"changes,omitempty"`
+	Before    *Dhis2Mapping `json:"before,omitempty"`
+	After     *Dhis2Mapping `json:"after,omitempty"`
+}
+
+// changeRecorder opens a change set on the first recorded change, so runs that change nothing leave no trace
+type changeRecorder struct {
+	tx          *sqlx.Tx
+	userID      int64
+	source      string
+	description string
+	changeSet   *MappingChangeSet
+}
+
+func newChangeRecorder(tx *sqlx.Tx, userID int64, source, description string) *changeRecorder {
+	return &changeRecorder{tx: tx, userID: userID, source: source, description: description}
+}
+
+func actingUser(userID int64) *int64 {
+	if userID <= 0 {
+		return nil
+	}
+	return &userID
+}
+
+func (r *changeRecorder) record(op string, oldValue, newValue *Dhis2Mapping) error {
+	subject := newValue
+	if subject == nil {
+		subject = oldValue
+	}
+	return r.insertEntry(subject.ID, subject.UID, nil, op, oldValue, newValue)
+}
+
+// recordDimension records a change to a dimension of mapping
+func (r *changeRecorder) recordDimension(mapping *Dhis2Mapping, op string, oldValue, newValue *Dhis2MappingDimension) error {
+	subject := newValue
+	if subject == nil {
+		subject = oldValue
+	}
+	return r.insertEntry(mapping.ID, mapping.UID, &subject.ID, op, oldValue, newValue)
+}
+
+func (r *changeRecorder) insertEntry(mappingID int64, mappingUID string, dimensionID *int64,
+	op string, oldValue, newValue interface{}) error {
+	if r.changeSet == nil {
+		cs := MappingChangeSet{}
+		if err := r.tx.Get(&cs, `
+		INSERT INTO mapping_change_sets (source, description, user_id)
+		VALUES ($1, $2, $3) RETURNING *`, r.source, r.description, actingUser(r.userID)); err != nil {
+			log.WithError(err).Error("Failed to create mapping change set")
+			return err
+		}
+		r.changeSet = &cs
+	}
+
+	oldJSON, err := snapshotJSON(oldValue)
+	if err != nil {
+		return err
+	}
+	newJSON, err := snapshotJSON(newValue)
+	if err != nil {
+		return err
+	}
+	if _, err := r.tx.Exec(`
+	INSERT INTO dhis2_mappings_history (change_set_id, mapping_id, mapping_uid, dimension_id, operation,
+		old_value, new_value, user_id)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		r.changeSet.ID, mappingID, mappingUID, dimensionID, op, oldJSON, newJSON, actingUser(r.userID)); err != nil {
+		log.WithError(err).Error("Failed to record mapping history")
+		return err
+	}
+	r.changeSet.Changes++
+	return nil
+}
+
+func snapshotJSON(v interface{}) (interface{}, error) {
+	switch s := v.(type) {
+	case nil:
+		return nil, nil
+	case *Dhis2Mapping:
+		if s == nil {
+			return nil, nil
+		}
+	case *Dhis2MappingDimension:
+		if s == nil {
+			return nil, nil
+		}
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(raw), nil
+}
+
+func parseDimensionSnapshot(raw json.RawMessage) (*Dhis2MappingDimension, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var d Dhis2MappingDimension
+	if err := json.Unmarshal(raw, &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// dimensionStateEqual reports whether two snapshots of a dimension hold the same values
+func dimensionStateEqual(a, b *Dhis2MappingDimension) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.MappingID == b.MappingID && a.SourceField == b.SourceField && a.SourceLabel == b.SourceLabel &&
+		a.CategoryOption == b.CategoryOption && a.CategoryOptionCombo == b.CategoryOptionCombo &&
+		a.Type == b.Type && a.DimensionGroup == b.DimensionGroup
+}
+
+// lockDimension returns the current row of a dimension for update, or nil when it does not exist
+func lockDimension(tx *sqlx.Tx, id int64) (*Dhis2MappingDimension, error) {
+	var d Dhis2MappingDimension
+	err := tx.Get(&d, `SELECT `+mappingDimensionColumns+` FROM dhis2_mapping_dimension WHERE id = $1 FOR UPDATE`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// deleteMappingDimensions removes and records the dimensions of a mapping about to be deleted,
+// so that rolling back the delete restores them too
+func (r *changeRecorder) deleteMappingDimensions(mapping *Dhis2Mapping) error {
+	var dims []Dhis2MappingDimension
+	if err := r.tx.Select(&dims, `SELECT `+mappingDimensionColumns+`
+		FROM dhis2_mapping_dimension WHERE mapping_id = $1 ORDER BY id FOR UPDATE`, mapping.ID); err != nil {
+		return err
+	}
+	for i := range dims {
+		if _, err := r.tx.Exec(`DELETE FROM dhis2_mapping_dimension WHERE id = $1`, dims[i].ID); err != nil {
+			return err
+		}
+		if err := r.recordDimension(mapping, MappingOpDelete, &dims[i], nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseSnapshot(raw json.RawMessage) (*Dhis2Mapping, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var m Dhis2Mapping
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// mappingStateEqual reports whether two snapshots of a mapping hold the same values
+func mappingStateEqual(a, b *Dhis2Mapping) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	dimType := func(m *Dhis2Mapping) string {
+		if m.DimensionType == "" {
+			return "none"
+		}
+		return m.DimensionType
+	}
+	return keyOf(a) == keyOf(b) && dimType(a) == dimType(b) && len(diffMapping(a, b)) == 0
+}
+
+// lockMapping returns the current row of a mapping for update, or nil when it does not exist
+func lockMapping(tx *sqlx.Tx, id int64) (*Dhis2Mapping, error) {
+	var m Dhis2Mapping
+	err := tx.Get(&m, `SELECT * FROM dhis2_mappings WHERE id = $1 FOR UPDATE`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// withMappingChanges runs fn in a transaction that records mapping changes in one change set
+func withMappingChanges(userID int64, source, description string, fn func(r *changeRecorder) error) error {
+	tx, err := db.GetDB().Beginx()
+	if err != nil {
+		log.WithError(err).Error("Failed to begin mapping change transaction")
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if err := fn(newChangeRecorder(tx, userID, source, description)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetMappingChangeSets returns a page of change sets, newest first, with their change counts
+func GetMappingChangeSets(page, pageSize int) ([]MappingChangeSet, int64, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = 10
+	}
+	dbConn := db.GetDB()
+	var total int64
+	if err := dbConn.Get(&total, `SELECT COUNT(*) FROM mapping_change_sets`); err != nil {
+		log.WithError(err).Error("Failed to count mapping change sets")
+		return nil, 0, err
+	}
+	sets := []MappingChangeSet{}
+	err := dbConn.Select(&sets, `
+	SELECT cs.*, (SELECT COUNT(*) FROM dhis2_mappings_history h WHERE h.change_set_id = cs.id) AS changes
+	FROM mapping_change_sets cs ORDER BY cs.id DESC LIMIT $1 OFFSET $2`, pageSize, (page-1)*pageSize)
+	if err != nil {
+		log.WithError(err).Error("Failed to fetch mapping change sets")
+		return nil, 0, err
+	}
+	return sets, total, nil
+}
+
+// GetMappingChangeSet returns a change set and its recorded changes in the order they were made
+func GetMappingChangeSet(uid string) (*MappingChangeSet, []MappingHistoryEntry, error) {
+	dbConn := db.GetDB()
+	var cs MappingChangeSet
+	if err := dbConn.Get(&cs, `
+	SELECT cs.*, (SELECT COUNT(*) FROM dhis2_mappings_history h WHERE h.change_set_id = cs.id) AS changes
+	FROM mapping_change_sets cs WHERE cs.uid = $1`, uid); err != nil {
+		return nil, nil, err
+	}
+	entries := []MappingHistoryEntry{}
+	if err := dbConn.Select(&entries, `
+	SELECT * FROM dhis2_mappings_history WHERE change_set_id = $1 ORDER BY id`, cs.ID); err != nil {
+		log.WithError(err).Error("Failed to fetch mapping change set entries")
+		return nil, nil, err
+	}
+	return &cs, entries, nil
+}
+
+// DiffMappings compares the mappings as they were at from with how they were at to. Only
+// mappings with recorded changes in between can differ, so the diff is built from the first
+// old value and the last new value of each mapping in that window.
+func DiffMappings(from, to time.Time) ([]MappingStateDiff, error) {
+	var entries []MappingHistoryEntry
+	if err := db.GetDB().Select(&entries, `
+	SELECT * FROM dhis2_mappings_history WHERE created > $1 AND created <= $2 AND dimension_id IS NULL
+	ORDER BY id`, from, to); err != nil {
+		log.WithError(err).Error("Failed to fetch mapping history")
+		return nil, err
+	}
+
+	type window struct {
+		first, last *MappingHistoryEntry
+	}
+	windows := make(map[int64]*window)
+	for i := range entries {
+		e := &entries[i]
+		if w, ok := windows[e.MappingID]; ok {
+			w.last = e
+		} else {
+			windows[e.MappingID] = &window{first: e, last: e}
+		}
+	}
+
+	diffs := []MappingStateDiff{}
+	for id, w := range windows {
+		before, err := parseSnapshot(w.first.OldValue)
+		if err != nil {
+			return nil, fmt.Errorf("history entry %d: %w", w.first.ID, err)
+		}
+		after, err := parseSnapshot(w.last.NewValue)
+		if err != nil {
+			return nil, fmt.Errorf("history entry %d: %w", w.last.ID, err)
+		}
+		if mappingStateEqual(before, after) {
+			continue
+		}
+		d := MappingStateDiff{MappingID: id, UID: w.first.MappingUID, Before: before, After: after}
+		switch {
+		case before == nil:
+			d.Action, d.Code = ImportActionCreated, after.Code
+		case after == nil:
+			d.Action, d.Code = ImportActionDeleted, before.Code
+		default:
+			d.Action, d.Code, d.Changes = ImportActionUpdated, after.Code, diffMapping(before, after)
+		}
+		diffs = append(diffs, d)
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].MappingID < diffs[j].MappingID })
+	return diffs, nil
+}
+
+const restoreDhis2MappingSQL = `
+INSERT INTO dhis2_mappings(id, uid, code, what, name, description, dataset, dataelement,
+    category_option_combo, category_option, category_combo, instance_name, source_name, source_orgunit,
+	dest_orgunit, dimension_type, created, updated)
+VALUES(:id, :uid, :code, :what, :name, :description, :dataset, :dataelement,
+    :category_option_combo, :category_option, :category_combo, :instance_name, :source_name, :source_orgunit,
+	:dest_orgunit, COALESCE(NULLIF(:dimension_type, ''), 'none'), :created, NOW())`
+
+// RollbackMappingChangeSet reverts every change of a change set in one transaction and
+// records the reversal as a new change set. It fails with ErrRollbackConflict when any of
+// the mappings or dimensions changed again afterwards, or when restoring one collides with
+// a mapping added since.
+func RollbackMappingChangeSet(uid string, userID int64) (*MappingChangeSet, error) {
+	var result *MappingChangeSet
+	err := withMappingChanges(userID, ChangeSourceRollback, "", func(r *changeRecorder) error {
+		var cs MappingChangeSet
+		if err := r.tx.Get(&cs, `SELECT * FROM mapping_change_sets WHERE uid = $1 FOR UPDATE`, uid); err != nil {
+			return err
+		}
+		if cs.RolledBackBy != nil {
+			return ErrChangeSetRolledBack
+		}
+		r.description = "rollback of change set " + cs.UID
+
+		var entries []MappingHistoryEntry
+		if err := r.tx.Select(&entries, `
+		SELECT * FROM dhis2_mappings_history WHERE change_set_id = $1 ORDER BY id DESC`, cs.ID); err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := r.revert(e); err != nil {
+				return err
+			}
+		}
+		if r.changeSet == nil {
+			return nil
+		}
+		if _, err := r.tx.Exec(`UPDATE mapping_change_sets SET rolled_back_by = $1 WHERE id = $2`,
+			r.changeSet.ID, cs.ID); err != nil {
+			return err
+		}
+		result = r.changeSet
+		return nil
+	})
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && (pqErr.Code == "23505" || pqErr.Code == "23503") {
+		return nil, fmt.Errorf("%w: %s", ErrRollbackConflict, pqErr.Message)
+	}
+	return result, err
+}
+
+// revert undoes one history entry, provided the mapping is still as the entry left it
+func (r *changeRecorder) revert(e MappingHistoryEntry) error {
+	if e.DimensionID != nil {
+		return r.revertDimension(e)
+	}
+	oldValue, err := parseSnapshot(e.OldValue)
+	if err != nil {
+		return err
+	}
+	newValue, err := parseSnapshot(e.NewValue)
+	if err != nil {
+		return err
+	}
+	current, err := lockMapping(r.tx, e.MappingID)
+	if err != nil {
+		return err
+	}
+	if !mappingStateEqual(current, newValue) {
+		return fmt.Errorf("%w: %s", ErrRollbackConflict, e.MappingUID)
+	}
+
+	switch e.Operation {
+	case MappingOpCreate:
+		// the dimensions left are ones later change sets added or changed, since those of this
+		// change set were reverted before its mapping
+		changed, err := r.changedSince(e, false)
+		if err != nil {
+			return err
+		}
+		if changed {
+			return fmt.Errorf("%w: dimensions of %s changed afterwards", ErrRollbackConflict, e.MappingUID)
+		}
+		if err := r.deleteMappingDimensions(current); err != nil {
+			return err
+		}
+		if _, err := r.tx.Exec(`DELETE FROM dhis2_mappings WHERE id = $1`, e.MappingID); err != nil {
+			return err
+		}
+		return r.record(MappingOpDelete, current, nil)
+	case MappingOpUpdate:
+		if _, err := r.tx.NamedExec(updateDhis2MappingSQL, oldValue); err != nil {
+			return err
+		}
+	case MappingOpDelete:
+		if _, err := r.tx.NamedExec(restoreDhis2MappingSQL, oldValue); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown mapping history operation %q", e.Operation)
+	}
+	restored, err := lockMapping(r.tx, e.MappingID)
+	if err != nil {
+		return err
+	}
+	op := MappingOpUpdate
+	if e.Operation == MappingOpDelete {
+		op = MappingOpCreate
+	}
+	return r.record(op, current, restored)
+}
+
+// changedSince reports whether change sets after that of e, other than the rollback being
+// recorded, changed the dimension of e or, with sameDimension unset, any dimension of its mapping
+func (r *changeRecorder) changedSince(e MappingHistoryEntry, sameDimension bool) (bool, error) {
+	var rollbackID int64
+	if r.changeSet != nil {
+		rollbackID = r.changeSet.ID
+	}
+	query := `SELECT EXISTS (SELECT 1 FROM dhis2_mappings_history
+		WHERE mapping_id = $1 AND dimension_id IS NOT NULL AND change_set_id > $2 AND change_set_id <> $3`
+	args := []interface{}{e.MappingID, e.ChangeSetID, rollbackID}
+	if sameDimension {
+		query += ` AND dimension_id = $4`
+		args = append(args, *e.DimensionID)
+	}
+	var changed bool
+	err := r.tx.Get(&changed, query+`)`, args...)
+	return changed, err
+}
+
+const restoreMappingDimensionSQL = `
+INSERT INTO dhis2_mapping_dimension (id, mapping_id, source_field, source_label, category_option,
+	category_option_combo, type, dimension_group, created, updated)
+VALUES (:id, :mapping_id, :source_field, NULLIF(:source_label, ''), :category_option,
+	:category_option_combo, :type, NULLIF(:dimension_group, ''), :created, NOW())`
+
+// revertDimension undoes one dimension history entry, provided the dimension is still as the entry left it
+func (r *changeRecorder) revertDimension(e MappingHistoryEntry) error {
+	oldValue, err := parseDimensionSnapshot(e.OldValue)
+	if err != nil {
+		return err
+	}
+	newValue, err := parseDimensionSnapshot(e.NewValue)
+	if err != nil {
+		return err
+	}
+	current, err := lockDimension(r.tx, *e.DimensionID)
+	if err != nil {
+		return err
+	}
+	changed, err := r.changedSince(e, true)
+	if err != nil {
+		return err
+	}
+	if changed || !dimensionStateEqual(current, newValue) {
+		return fmt.Errorf("%w: dimension %d of %s", ErrRollbackConflict, *e.DimensionID, e.MappingUID)
+	}
+	mapping := &Dhis2Mapping{ID: e.MappingID, UID: e.MappingUID}
+
+	switch e.Operation {
+	case MappingOpCreate:
+		if _, err := r.tx.Exec(`DELETE FROM dhis2_mapping_dimension WHERE id = $1`, current.ID); err != nil {
+			return err
+		}
+		return r.recordDimension(mapping, MappingOpDelete, current, nil)
+	case MappingOpUpdate:
+		if _, err := r.tx.NamedExec(updateMappingDimensionSQL, oldValue); err != nil {
+			return err
+		}
+	case MappingOpDelete:
+		if _, err := r.tx.NamedExec(restoreMappingDimensionSQL, oldValue); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown mapping history operation %q", e.Operation)
+	}
+	restored, err := lockDimension(r.tx, *e.DimensionID)
+	if err != nil {
+		return err
+	}
+	op := MappingOpUpdate
+	if e.Operation == MappingOpDelete {
+		op = MappingOpCreate
+	}
+	return r.recordDimension(mapping, op, current, restored)
+}
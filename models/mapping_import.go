@@ -0,0 +1,275 @@
+package models
+
+import (
+	"dhis2gw/db"
+	"fmt"
+	"sort"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+)
+
+// MappingImportMode controls how imported mapping rows are applied
+type MappingImportMode string
+
+const (
+	// ImportModeInsertOnly creates new mappings and rejects rows that would change existing ones
+	ImportModeInsertOnly MappingImportMode = "insert-only"
+	// ImportModeUpsert creates new mappings and updates existing ones
+	ImportModeUpsert MappingImportMode = "upsert"
+	// ImportModeReplaceSource upserts and then deletes mappings of the imported sources missing from the file
+	ImportModeReplaceSource MappingImportMode = "replace-source"
+	// ImportModePreview is a dry run of insert-only, kept as a mode for clients that name it so
+	ImportModePreview MappingImportMode = "preview"
+)
+
+// Row actions reported by a mapping import
+const (
+	ImportActionCreated   = "created"
+	ImportActionUpdated   = "updated"
+	ImportActionUnchanged = "unchanged"
+	ImportActionRejected  = "rejected"
+	ImportActionDeleted   = "deleted"
+)
+
+// ParseMappingImportMode parses the mode query parameter, defaulting to insert-only
+func ParseMappingImportMode(s string) (MappingImportMode, error) {
+	switch mode := MappingImportMode(s); mode {
+	case "":
+		return ImportModeInsertOnly, nil
+	case ImportModeInsertOnly, ImportModeUpsert, ImportModeReplaceSource, ImportModePreview:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid import mode %q: use insert-only, upsert, replace-source or preview", s)
+	}
+}
+
+// mappingKey mirrors the unique_code_source_instance_what constraint
+type mappingKey struct {
+	code, source, instance, what string
+}
+
+func keyOf(m *Dhis2Mapping) mappingKey {
+	return mappingKey{code: m.Code, source: m.SourceName, instance: m.InstanceName, what: m.What}
+}
+
+type sourceKey struct {
+	source, instance string
+}
+
+// diffMapping lists the imported fields of a that differ in b
+func diffMapping(a, b *Dhis2Mapping) []FieldChange {
+	deref := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	fields := []struct {
+		name     string
+		old, new string
+	}{
+		{"name", a.Name, b.Name},
+		{"description", a.Description, b.Description},
+		{"dataSet", a.DataSet, b.DataSet},
+		{"dataElement", a.DataElement, b.DataElement},
+		{"categoryOptionCombo", deref(a.CategoryOptionCombo), deref(b.CategoryOptionCombo)},
+		{"categoryOption", a.CategoryOption, b.CategoryOption},
+		{"categoryCombo", a.CategoryCombo, b.CategoryCombo},
+		{"sourceOrgUnit", a.SourceOrgUnit, b.SourceOrgUnit},
+		{"destinationOrgUnit", a.DestinationOrgUnit, b.DestinationOrgUnit},
+	}
+	var changes []FieldChange
+	for _, f := range fields {
+		if f.old != f.new {
+			changes = append(changes, FieldChange{Field: f.name, Old: f.old, New: f.new})
+		}
+	}
+	return changes
+}
+
+// ImportMappings applies parsed import rows according to mode and reports what happened to
+// each row. Rows are numbered as in the uploaded file, with the header on row 1. All changes
+// are applied in one transaction, which a dry run rolls back once the report is complete, so
+// that it reports exactly what mode would do without keeping any of it.
+//
+// The changes are recorded as one mapping change set acting as userID; source names the file
+// type and ends up in the change set description. The preview mode runs as a dry run of
+// insert-only.
+func ImportMappings(records []Dhis2Mapping, mode MappingImportMode, dryRun bool, userID int64, source string) (
+	ImportResponse[Dhis2Mapping], error) {
+	if mode == ImportModePreview {
+		mode, dryRun = ImportModeInsertOnly, true
+	}
+	resp := ImportResponse[Dhis2Mapping]{
+		Items:   []Dhis2Mapping{},
+		Mode:    string(mode),
+		DryRun:  dryRun,
+		Summary: map[string]int{},
+	}
+
+	tx, err := db.GetDB().Beginx()
+	if err != nil {
+		log.WithError(err).Error("Failed to begin transaction for mapping import")
+		return resp, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	recorder := newChangeRecorder(tx, userID, ChangeSourceImport, fmt.Sprintf("%s import (%s)", source, mode))
+
+	existing, err := existingMappingsForImport(tx, records)
+	if err != nil {
+		return resp, err
+	}
+
+	seen := make(map[mappingKey]int)
+	sources := make(map[sourceKey]bool)
+	for i := range records {
+		m := records[i]
+		row := ImportRowResult{Row: i + 2, Code: m.Code}
+		if m.InstanceName == "" {
+			m.InstanceName = DefaultInstanceName
+		}
+		if m.SourceName == "" {
+			m.SourceName = DefaultSourceName
+		}
+		if m.What == "" {
+			m.What = MappingWhatDataElement
+		}
+
+		if err := m.Validate(); err != nil {
+			resp.addRow(row.reject(err.Error()))
+			continue
+		}
+		key := keyOf(&m)
+		if first, dup := seen[key]; dup {
+			resp.addRow(row.reject(fmt.Sprintf("duplicate of row %d", first)))
+			continue
+		}
+		seen[key] = row.Row
+		sources[sourceKey{source: m.SourceName, instance: m.InstanceName}] = true
+
+		current, found := existing[key]
+		if !found {
+			row.Action = ImportActionCreated
+			if err := insertMappingTx(tx, &m); err != nil {
+				return resp, fmt.Errorf("row %d: %w", row.Row, err)
+			}
+			created, err := lockMapping(tx, m.ID)
+			if err != nil {
+				return resp, err
+			}
+			if err := recorder.record(MappingOpCreate, nil, created); err != nil {
+				return resp, err
+			}
+			if dryRun {
+				// the mapping is rolled back, so neither its id nor its uid will exist
+				m.ID, m.UID = 0, ""
+			}
+			row.UID = m.UID
+			resp.addRow(row)
+			resp.Items = append(resp.Items, m)
+			continue
+		}
+
+		row.UID = current.UID
+		row.Changes = diffMapping(current, &m)
+		switch {
+		case len(row.Changes) == 0:
+			row.Action = ImportActionUnchanged
+		case mode == ImportModeInsertOnly:
+			row.Action, row.Reason = ImportActionRejected, "mapping already exists; import in upsert mode to update it"
+		default:
+			row.Action = ImportActionUpdated
+			m.ID, m.UID, m.Created = current.ID, current.UID, current.Created
+			if m.DimensionType == "" {
+				m.DimensionType = current.DimensionType
+			}
+			if _, err := tx.NamedExec(updateDhis2MappingSQL, &m); err != nil {
+				log.WithError(err).Error("Failed to update imported Dhis2Mapping")
+				return resp, fmt.Errorf("row %d: %w", row.Row, err)
+			}
+			updated, err := lockMapping(tx, m.ID)
+			if err != nil {
+				return resp, err
+			}
+			if err := recorder.record(MappingOpUpdate, current, updated); err != nil {
+				return resp, err
+			}
+			resp.Items = append(resp.Items, m)
+		}
+		resp.addRow(row)
+	}
+
+	if mode == ImportModeReplaceSource {
+		stale := make([]*Dhis2Mapping, 0)
+		for key, m := range existing {
+			if _, kept := seen[key]; !kept && sources[sourceKey{source: m.SourceName, instance: m.InstanceName}] {
+				stale = append(stale, m)
+			}
+		}
+		sort.Slice(stale, func(i, j int) bool { return stale[i].ID < stale[j].ID })
+		for _, m := range stale {
+			if err := recorder.deleteMappingDimensions(m); err != nil {
+				return resp, err
+			}
+			if _, err := tx.Exec("DELETE FROM dhis2_mappings WHERE id = $1", m.ID); err != nil {
+				log.WithError(err).Error("Failed to delete replaced Dhis2Mapping")
+				return resp, err
+			}
+			if err := recorder.record(MappingOpDelete, m, nil); err != nil {
+				return resp, err
+			}
+			resp.addRow(ImportRowResult{Action: ImportActionDeleted, UID: m.UID, Code: m.Code})
+		}
+	}
+
+	resp.Total = int64(len(records))
+	if dryRun {
+		return resp, nil
+	}
+	if recorder.changeSet != nil {
+		resp.ChangeSet = recorder.changeSet.UID
+	}
+	if err := tx.Commit(); err != nil {
+		log.WithError(err).Error("Failed to commit mapping import")
+		return resp, err
+	}
+	return resp, nil
+}
+
+// existingMappingsForImport loads the stored mappings of the instances named in the import
+func existingMappingsForImport(tx *sqlx.Tx, records []Dhis2Mapping) (map[mappingKey]*Dhis2Mapping, error) {
+	instances := map[string]bool{DefaultInstanceName: true}
+	for _, m := range records {
+		if m.InstanceName != "" {
+			instances[m.InstanceName] = true
+		}
+	}
+	names := make([]string, 0, len(instances))
+	for name := range instances {
+		names = append(names, name)
+	}
+
+	var stored []Dhis2Mapping
+	if err := tx.Select(&stored, "SELECT * FROM dhis2_mappings WHERE instance_name = ANY($1) FOR UPDATE",
+		pq.Array(names)); err != nil {
+		log.WithError(err).Error("Failed to load existing Dhis2Mappings for import")
+		return nil, err
+	}
+	existing := make(map[mappingKey]*Dhis2Mapping, len(stored))
+	for i := range stored {
+		existing[keyOf(&stored[i])] = &stored[i]
+	}
+	return existing, nil
+}
+
+func (r ImportRowResult) reject(reason string) ImportRowResult {
+	r.Action, r.Reason = ImportActionRejected, reason
+	return r
+}
+
+func (r *ImportResponse[T]) addRow(row ImportRowResult) {
+	r.Rows = append(r.Rows, row)
+	r.Summary[row.Action]++
+}
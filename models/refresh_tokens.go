@@ -0,0 +1,160 @@
+package models
+
+import (
+	"database/sql"
+	"dhis2gw/auth"
+	"dhis2gw/db"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrInvalidCredentials  = errors.New("invalid username or password")
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	// ErrRefreshTokenReused means a rotated refresh token was presented again, so it may have
+	// been stolen; all refresh tokens of its user are revoked when this is returned.
+	ErrRefreshTokenReused = errors.New("refresh token was already used; all sessions have been revoked")
+)
+
+// refreshToken is a row of user_refresh_tokens. Clients hold "<id>.<secret>", where the id
+// selects the row and the secret is checked against the stored bcrypt hash.
+type refreshToken struct {
+	ID        int64        `db:"id"`
+	UserID    int64        `db:"user_id"`
+	TokenHash string       `db:"token_hash"`
+	ExpiresAt time.Time    `db:"expires_at"`
+	RevokedAt sql.NullTime `db:"revoked_at"`
+}
+
+// CheckUserCredentials returns the id of the active user with the given username and password
+func CheckUserCredentials(username, password string) (int64, error) {
+	var user struct {
+		ID       int64  `db:"id"`
+		Password string `db:"password"`
+	}
+	err := db.GetDB().Get(&user, `SELECT id, password FROM users WHERE username = $1 AND is_active`, username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrInvalidCredentials
+		}
+		return 0, err
+	}
+	if auth.CheckPassword(user.Password, password) != nil {
+		return 0, ErrInvalidCredentials
+	}
+	return user.ID, nil
+}
+
+// IssueRefreshToken creates a refresh token for the user valid for ttl
+func IssueRefreshToken(userID int64, ttl time.Duration) (string, time.Time, error) {
+	_, token, expires, err := issueRefreshToken(db.GetDB(), userID, ttl)
+	return token, expires, err
+}
+
+func issueRefreshToken(q sqlx.Queryer, userID int64, ttl time.Duration) (int64, string, time.Time, error) {
+	plain, hash, err := auth.GenerateRefreshToken()
+	if err != nil {
+		return 0, "", time.Time{}, err
+	}
+	expires := time.Now().Add(ttl)
+	var id int64
+	err = sqlx.Get(q, &id, `
+		INSERT INTO user_refresh_tokens (user_id, token_hash, expires_at)
+		VALUES ($1, $2, $3) RETURNING id`, userID, hash, expires)
+	if err != nil {
+		log.WithError(err).Error("Failed to save refresh token")
+		return 0, "", time.Time{}, err
+	}
+	return id, fmt.Sprintf("%d.%s", id, plain), expires, nil
+}
+
+// RotateRefreshToken revokes a valid refresh token and issues its replacement, returning
+// the user it belongs to
+func RotateRefreshToken(token string, ttl time.Duration) (int64, string, time.Time, error) {
+	var (
+		userID  int64
+		next    string
+		expires time.Time
+	)
+	reused := false
+	err := withTx(func(tx *sqlx.Tx) error {
+		rt, err := findRefreshToken(tx, token, true)
+		if err != nil {
+			return err
+		}
+		if rt.RevokedAt.Valid {
+			reused = true
+			userID = rt.UserID
+			return ErrRefreshTokenReused
+		}
+		if time.Now().After(rt.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+		userID = rt.UserID
+		var nextID int64
+		if nextID, next, expires, err = issueRefreshToken(tx, rt.UserID, ttl); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE user_refresh_tokens SET revoked_at = NOW(), replaced_by = $1 WHERE id = $2`,
+			nextID, rt.ID)
+		return err
+	})
+	if reused {
+		// Revoked outside the rolled back transaction
+		if err := RevokeUserRefreshTokens(userID); err != nil {
+			log.WithError(err).Error("Failed to revoke refresh tokens after reuse")
+		}
+	}
+	if err != nil {
+		return userID, "", time.Time{}, err
+	}
+	return userID, next, expires, nil
+}
+
+// RevokeRefreshToken revokes one refresh token and returns the user it belongs to
+func RevokeRefreshToken(token string) (int64, error) {
+	rt, err := findRefreshToken(db.GetDB(), token, false)
+	if err != nil {
+		return 0, err
+	}
+	_, err = db.GetDB().Exec(`UPDATE user_refresh_tokens SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`,
+		rt.ID)
+	return rt.UserID, err
+}
+
+// RevokeUserRefreshTokens revokes every active refresh token of a user
+func RevokeUserRefreshTokens(userID int64) error {
+	_, err := db.GetDB().Exec(`UPDATE user_refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`,
+		userID)
+	return err
+}
+
+// findRefreshToken loads the row of a client-held token and checks its secret
+func findRefreshToken(q sqlx.Queryer, token string, forUpdate bool) (*refreshToken, error) {
+	idPart, secret, found := strings.Cut(token, ".")
+	id, err := strconv.ParseInt(idPart, 10, 64)
+	if !found || err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	query := `SELECT id, user_id, token_hash, expires_at, revoked_at FROM user_refresh_tokens WHERE id = $1`
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+	var rt refreshToken
+	if err := sqlx.Get(q, &rt, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+	if auth.CompareRefreshToken(rt.TokenHash, secret) != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	return &rt, nil
+}
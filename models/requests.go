@@ -13,6 +13,7 @@ import (
 	"github.com/samber/lo"
 	log "github.com/sirupsen/logrus"
 	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
@@ -28,11 +29,12 @@ type RequestStatus string
 
 // constants for the status
 const (
-	RequestStatusReady     = RequestStatus("ready")
-	RequestStatusExpired   = RequestStatus("expired")
-	RequestStatusCompleted = RequestStatus("completed")
-	RequestStatusFailed    = RequestStatus("failed")
-	RequestStatusCanceled  = RequestStatus("canceled")
+	RequestStatusReady      = RequestStatus("ready")
+	RequestStatusInProgress = RequestStatus("inprogress")
+	RequestStatusExpired    = RequestStatus("expired")
+	RequestStatusCompleted  = RequestStatus("completed")
+	RequestStatusFailed     = RequestStatus("failed")
+	RequestStatusCanceled   = RequestStatus("canceled")
 )
 
 // Request represents our requests queue in the database
@@ -126,6 +128,280 @@ func (r *Request) CreatedOn() time.Time { return r.r.Created }
 // UpdatedOn return time when request was updated
 func (r *Request) UpdatedOn() time.Time { return r.r.Updated }
 
+// Retries returns the number of failed attempts made for the request
+func (r *Request) Retries() int { return r.r.Retries }
+
+// Response returns the last response received from the destination
+func (r *Request) Response() string { return r.r.Response }
+
+// CCServers returns the ids of the servers receiving a copy of the request
+func (r *Request) CCServers() []int64 { return r.r.CCServers }
+
+// CCServerStatus is the delivery state of a request copy sent to one CC server
+type CCServerStatus struct {
+	Status     string `json:"status"`
+	Errors     string `json:"errors"`
+	Retries    int    `json:"retries"`
+	StatusCode string `json:"statusCode"`
+	Response   string `json:"response"`
+	Updated    string `json:"updated,omitempty"`
+	// set when the CC server imports the copy as a DHIS2 async job
+	AsyncJobID    string `json:"asyncJobID,omitempty"`
+	AsyncStatus   string `json:"asyncStatus,omitempty"`
+	AsyncResponse string `json:"asyncResponse,omitempty"`
+}
+
+// CCServersStatus returns the per server copy delivery state keyed by server id
+func (r *Request) CCServersStatus() map[string]CCServerStatus {
+	statuses := make(map[string]CCServerStatus)
+	if len(r.r.CCServersStatus) > 0 {
+		if err := json.Unmarshal(r.r.CCServersStatus, &statuses); err != nil {
+			log.WithError(err).WithField("RequestUID", r.r.UID).Error("Failed to parse cc_servers_status")
+		}
+	}
+	return statuses
+}
+
+// requestColumns lists the requests columns scanned into Request, coalescing nullable ones
+const requestColumns = `id, uid, batchid, depends_on, source, destination, cc_servers,
+	COALESCE(cc_servers_status, '{}'::jsonb) AS cc_servers_status, ctype, body, response, status,
+	COALESCE(statuscode, '') AS statuscode, retries, COALESCE(errors, '') AS errors, frequency_type, period,
+	COALESCE(week, '') AS week, COALESCE(month, '') AS month, COALESCE(year::text, '') AS year, msisdn, raw_msg,
+	facility, district, report_type, object_type, extras, suspended <> 0 AS suspended, body_is_query_param,
+	submissionid, COALESCE(url_suffix, '') AS url_suffix, async_jobid, async_response, async_status,
+	created, updated`
+
+// claimReadyRequestsSQL marks up to $1 dispatchable requests as inprogress and returns them.
+// A request is dispatchable when its destination is not suspended, is inside its submission
+// window, accepts the source, any request it depends on has completed and, after a failed
+// attempt, its retry backoff has passed: $2 seconds doubling with every retry up to $3.
+const claimReadyRequestsSQL = `
+UPDATE requests SET status = 'inprogress', updated = now()
+WHERE id IN (
+	SELECT r.id FROM requests r
+		JOIN servers s ON s.id = r.destination
+	WHERE r.status = 'ready' AND r.suspended = 0
+		AND NOT s.suspended
+		AND COALESCE(in_submission_period(s.id), FALSE)
+		AND COALESCE(is_allowed_source(r.source, r.destination), TRUE)
+		AND (r.depends_on IS NULL OR EXISTS (
+			SELECT 1 FROM requests d WHERE d.id = r.depends_on AND d.status = 'completed'))
+		AND (r.retries = 0 OR r.updated <= now() - LEAST($2 * power(2, r.retries - 1), $3) * interval '1 second')
+	ORDER BY r.created
+	LIMIT $1
+	FOR UPDATE OF r SKIP LOCKED)
+RETURNING ` + requestColumns
+
+// ClaimReadyRequests picks up to limit ready requests for processing
+func ClaimReadyRequests(db *sqlx.DB, limit int) ([]Request, error) {
+	base, ceiling := retryBackoffSeconds()
+	rows, err := db.Queryx(claimReadyRequestsSQL, limit, base, ceiling)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var requests []Request
+	for rows.Next() {
+		req := Request{}
+		if err := rows.StructScan(&req.r); err != nil {
+			return requests, fmt.Errorf("request loading: %w", err)
+		}
+		requests = append(requests, req)
+	}
+	return requests, rows.Err()
+}
+
+// retryBackoffSeconds returns the delay before the first retry of a failed request and the
+// longest delay between retries, from server.retry_base_delay and server.retry_max_delay
+func retryBackoffSeconds() (float64, float64) {
+	cfg := config.MustGet().Config.Server
+	base, ceiling := cfg.RetryBaseDelay, cfg.RetryMaxDelay
+	if base <= 0 {
+		base = 30 * time.Second
+	}
+	if ceiling < base {
+		ceiling = base
+	}
+	return base.Seconds(), ceiling.Seconds()
+}
+
+// ResetStaleRequests returns requests stuck inprogress for longer than olderThan to the ready state
+func ResetStaleRequests(db *sqlx.DB, olderThan time.Duration) (int64, error) {
+	res, err := db.Exec(
+		`UPDATE requests SET status = 'ready', updated = now()
+			WHERE status = 'inprogress' AND updated < now() - $1 * interval '1 second'`,
+		int64(olderThan.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ccPendingCondition matches a cc_servers_status entry, keyed by the text expression %[1]s,
+// that still needs to be sent. After a failed attempt the entry waits %[2]s seconds, doubling
+// with every retry up to %[3]s. Entries stuck inprogress for over ten minutes are picked up again.
+const ccPendingCondition = `((COALESCE(cc_servers_status -> %[1]s ->> 'status', '') IN ('', 'ready')
+		AND (COALESCE((cc_servers_status -> %[1]s ->> 'retries')::int, 0) = 0
+			OR (cc_servers_status -> %[1]s ->> 'updated')::timestamptz <= now() - LEAST(
+				%[2]s * power(2, (cc_servers_status -> %[1]s ->> 'retries')::int - 1), %[3]s) * interval '1 second'))
+	OR (cc_servers_status -> %[1]s ->> 'status' = 'inprogress'
+		AND (cc_servers_status -> %[1]s ->> 'updated')::timestamptz < now() - interval '10 minutes'))`
+
+var selectPendingCopiesSQL = `
+SELECT ` + requestColumns + ` FROM requests r
+WHERE r.status <> 'canceled' AND r.suspended = 0 AND cardinality(r.cc_servers) > 0
+	AND (r.depends_on IS NULL OR EXISTS (
+		SELECT 1 FROM requests d WHERE d.id = r.depends_on AND d.status = 'completed'))
+	AND EXISTS (
+		SELECT 1 FROM unnest(r.cc_servers) AS cc(id)
+			JOIN servers s ON s.id = cc.id
+		WHERE NOT s.suspended AND COALESCE(in_submission_period(s.id), FALSE)
+			AND ` + fmt.Sprintf(ccPendingCondition, "cc.id::text", "$2", "$3") + `)
+ORDER BY r.updated
+LIMIT $1`
+
+// GetRequestsWithPendingCopies returns up to limit requests with copies due for delivery
+func GetRequestsWithPendingCopies(db *sqlx.DB, limit int) ([]Request, error) {
+	base, ceiling := retryBackoffSeconds()
+	rows, err := db.Queryx(selectPendingCopiesSQL, limit, base, ceiling)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var requests []Request
+	for rows.Next() {
+		req := Request{}
+		if err := rows.StructScan(&req.r); err != nil {
+			return requests, fmt.Errorf("request loading: %w", err)
+		}
+		requests = append(requests, req)
+	}
+	return requests, rows.Err()
+}
+
+var claimCCServerSQL = `
+UPDATE requests SET cc_servers_status = jsonb_set(COALESCE(cc_servers_status, '{}'::jsonb), ARRAY[$2::text],
+		COALESCE(cc_servers_status -> $2::text, '{}'::jsonb)
+			|| jsonb_build_object('status', 'inprogress', 'updated', now()), true)
+WHERE id = $1 AND ` + fmt.Sprintf(ccPendingCondition, "$2::text", "$4", "$5") + `
+	AND EXISTS (SELECT 1 FROM servers s
+		WHERE s.id = $3 AND NOT s.suspended AND COALESCE(in_submission_period(s.id), FALSE))
+RETURNING cc_servers_status -> $2::text`
+
+// ClaimCCServer marks the copy for serverID as inprogress. It returns false when the copy
+// is not due, is being sent by another processor or the CC server is not accepting requests.
+func (r *Request) ClaimCCServer(db *sqlx.DB, serverID int64) (CCServerStatus, bool, error) {
+	var raw []byte
+	var st CCServerStatus
+	base, ceiling := retryBackoffSeconds()
+	err := db.Get(&raw, claimCCServerSQL, r.r.ID, strconv.FormatInt(serverID, 10), serverID, base, ceiling)
+	if errors.Is(err, sql.ErrNoRows) {
+		return st, false, nil
+	}
+	if err != nil {
+		return st, false, err
+	}
+	if err := json.Unmarshal(raw, &st); err != nil {
+		return st, false, err
+	}
+	return st, true, nil
+}
+
+// UpdateCCServerStatus records the outcome of sending the request copy to serverID
+func (r *Request) UpdateCCServerStatus(db *sqlx.DB, serverID int64, st CCServerStatus) error {
+	st.Updated = time.Now().Format(time.RFC3339)
+	raw, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(
+		`UPDATE requests SET cc_servers_status = jsonb_set(COALESCE(cc_servers_status, '{}'::jsonb),
+			ARRAY[$2::text], $3::jsonb, true) WHERE id = $1`,
+		r.r.ID, strconv.FormatInt(serverID, 10), string(raw))
+	return err
+}
+
+// SetAsyncJob records the DHIS2 async job created when the destination imported the request
+func (r *Request) SetAsyncJob(tx *sqlx.Tx, jobID string) error {
+	_, err := tx.Exec(`UPDATE requests SET (is_async, async_jobid, updated) = (TRUE, $1, now()) WHERE id = $2`,
+		jobID, r.r.ID)
+	if err == nil {
+		r.r.AsyncJobID = jobID
+	}
+	return err
+}
+
+// UpdateRequestAsyncResult stores the outcome of a DHIS2 async job on the request,
+// or on the cc_servers_status entry of serverID when the job belongs to a copy.
+func UpdateRequestAsyncResult(
+	tx *sqlx.Tx, id RequestID, serverID ServerID, serverInCC bool, status, response string) error {
+	if !serverInCC {
+		_, err := tx.Exec(
+			`UPDATE requests SET (async_status, async_response, updated) = ($1, $2, now()) WHERE id = $3`,
+			status, response, id)
+		return err
+	}
+	_, err := tx.Exec(`UPDATE requests SET cc_servers_status = jsonb_set(
+			COALESCE(cc_servers_status, '{}'::jsonb), ARRAY[$2::text],
+			COALESCE(cc_servers_status -> $2::text, '{}'::jsonb)
+				|| jsonb_build_object('asyncStatus', $3::text, 'asyncResponse', $4::text), true),
+			updated = now()
+		WHERE id = $1`,
+		id, strconv.FormatInt(int64(serverID), 10), status, response)
+	return err
+}
+
+// UpdateStatus sets the status of the request together with the destination's response
+func (r *Request) UpdateStatus(db *sqlx.DB, status RequestStatus, statusCode, response, errs string) error {
+	_, err := db.Exec(
+		`UPDATE requests SET (status, statuscode, response, errors, updated) = ($1, $2, $3, $4, now())
+			WHERE id = $5`,
+		status, statusCode, response, errs, r.r.ID)
+	if err == nil {
+		r.r.Status = status
+		r.r.StatusCode = statusCode
+		r.r.Response = response
+		r.r.Errors = errs
+	}
+	return err
+}
+
+// SubmissionID returns the reference ID the source system gave the submission
+func (r *Request) SubmissionID() string { return r.r.SubmissionID }
+
+// DeliveredDuplicate returns the uid of another request with the same submission ID that
+// was already delivered to serverID, either as its destination or as one of its copies.
+func (r *Request) DeliveredDuplicate(db *sqlx.DB, serverID int64) (string, bool, error) {
+	if r.r.SubmissionID == "" {
+		return "", false, nil
+	}
+	var uid string
+	err := db.Get(&uid, `SELECT uid FROM requests
+		WHERE submissionid = $1 AND id <> $2
+			AND ((destination = $3 AND status = 'completed')
+				OR cc_servers_status -> $4::text ->> 'status' = 'completed')
+		LIMIT 1`,
+		r.r.SubmissionID, r.r.ID, serverID, strconv.FormatInt(serverID, 10))
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return uid, true, nil
+}
+
+// IncrementRetries records a failed attempt for the request
+func (r *Request) IncrementRetries(db *sqlx.DB) error {
+	_, err := db.Exec(`UPDATE requests SET retries = retries + 1, updated = now() WHERE id = $1`, r.r.ID)
+	if err == nil {
+		r.r.Retries++
+	}
+	return err
+}
+
 // NewRequest creates new request and saves it in DB
 func NewRequest(c *gin.Context, db *sqlx.DB) (Request, error) {
 	source := utils.GetServer(c.Query("source"))
@@ -28,8 +28,29 @@ type PaginatedResponse[T any] struct {
 }
 
 type ImportResponse[T any] struct {
-	Items []T   `json:"items"`
-	Total int64 `json:"total" example:"100"`
+	Items     []T               `json:"items"`
+	Total     int64             `json:"total" example:"100"`
+	Mode      string            `json:"mode,omitempty" example:"upsert"`
+	DryRun    bool              `json:"dryRun" example:"false"`
+	ChangeSet string            `json:"changeSet,omitempty" example:"Xy12AbC34De"`
+	Summary   map[string]int    `json:"summary,omitempty"`
+	Rows      []ImportRowResult `json:"rows,omitempty"`
+}
+
+// ImportRowResult reports what an import did with one row of the uploaded file
+type ImportRowResult struct {
+	Row     int           `json:"row,omitempty" example:"2"`
+	Action  string        `json:"action" example:"updated"`
+	UID     string        `json:"uid,omitempty" example:"Xy12AbC34De"`
+	Code    string        `json:"code,omitempty" example:"ANC1"`
+	Changes []FieldChange `json:"changes,omitempty"`
+	Reason  string        `json:"reason,omitempty" example:"duplicate of row 2"`
+}
+
+type FieldChange struct {
+	Field string `json:"field" example:"dataElement"`
+	Old   string `json:"old" example:"fbfJHSPpUQD"`
+	New   string `json:"new" example:"cYeuwXTCPkU"`
 }
 
 type BatchReEnqueueResponse struct {
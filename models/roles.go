@@ -0,0 +1,293 @@
+package models
+
+import (
+	"database/sql"
+	"dhis2gw/auth"
+	"dhis2gw/db"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+)
+
+// Role is a user role with its permissions per module
+type Role struct {
+	ID          int64             `db:"id" json:"id" example:"2"`
+	Name        string            `db:"name" json:"name" example:"SMS User"`
+	Description string            `db:"description" json:"description" example:"For SMS third party apps"`
+	Permissions map[string]string `db:"-" json:"permissions"`
+	Users       int               `db:"users" json:"users" example:"4"` // users holding the role
+	Created     *time.Time        `db:"created" json:"created,omitempty"`
+	Updated     *time.Time        `db:"updated" json:"updated,omitempty"`
+}
+
+// RoleInput creates or updates a role. Permissions map module names to permission letters,
+// e.g. {"Mappings": "ra"}; on update a nil map leaves the permissions unchanged.
+type RoleInput struct {
+	Name        string            `json:"name" example:"Data Manager"`
+	Description string            `json:"description" example:"Maintains mappings"`
+	Permissions map[string]string `json:"permissions"`
+}
+
+var (
+	ErrRoleNotFound = errors.New("role not found")
+	ErrRoleExists   = errors.New("a role with this name already exists")
+	ErrRoleInUse    = errors.New("role is assigned to users")
+)
+
+// Validate checks the role name, the modules and the permission letters
+func (in *RoleInput) Validate() error {
+	var problems []string
+	if strings.TrimSpace(in.Name) == "" {
+		problems = append(problems, "name is required")
+	}
+	for module, perms := range in.Permissions {
+		if !slices.Contains(auth.Modules, module) {
+			problems = append(problems, fmt.Sprintf("unknown module %q, use one of %s", module,
+				strings.Join(auth.Modules, ", ")))
+		}
+		if !auth.ValidPerms(perms) {
+			problems = append(problems, fmt.Sprintf("invalid permissions %q on %s, use letters of %q", perms,
+				module, auth.AllPerms))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+const selectRolesSQL = `
+SELECT r.id, r.name, COALESCE(r.description, '') AS description, r.created, r.updated,
+       (SELECT COUNT(*) FROM users u WHERE u.user_role = r.id) AS users
+FROM user_roles r`
+
+// GetRoles returns all roles with their permissions, ordered by name
+func GetRoles() ([]Role, error) {
+	var roles []Role
+	if err := db.GetDB().Select(&roles, selectRolesSQL+" ORDER BY r.name"); err != nil {
+		log.WithError(err).Error("Failed to fetch roles")
+		return nil, err
+	}
+	perms, err := rolePermissions(db.GetDB())
+	if err != nil {
+		return nil, err
+	}
+	for i := range roles {
+		roles[i].Permissions = perms[roles[i].ID]
+		if roles[i].Permissions == nil {
+			roles[i].Permissions = map[string]string{}
+		}
+	}
+	return roles, nil
+}
+
+// GetRole returns a role with its permissions
+func GetRole(id int64) (*Role, error) {
+	return getRole(db.GetDB(), id)
+}
+
+func getRole(q sqlx.Queryer, id int64) (*Role, error) {
+	var role Role
+	if err := sqlx.Get(q, &role, selectRolesSQL+" WHERE r.id = $1", id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRoleNotFound
+		}
+		return nil, err
+	}
+	perms, err := rolePermissions(q, id)
+	if err != nil {
+		return nil, err
+	}
+	role.Permissions = perms[id]
+	if role.Permissions == nil {
+		role.Permissions = map[string]string{}
+	}
+	return &role, nil
+}
+
+// rolePermissions loads module permissions keyed by role, optionally limited to some roles
+func rolePermissions(q sqlx.Queryer, roleIDs ...int64) (map[int64]map[string]string, error) {
+	query := "SELECT user_role, sys_module, sys_perms FROM user_role_permissions"
+	var args []interface{}
+	if len(roleIDs) > 0 {
+		query += " WHERE user_role = ANY($1)"
+		args = append(args, pq.Array(roleIDs))
+	}
+	var rows []struct {
+		Role   int64  `db:"user_role"`
+		Module string `db:"sys_module"`
+		Perms  string `db:"sys_perms"`
+	}
+	if err := sqlx.Select(q, &rows, query, args...); err != nil {
+		log.WithError(err).Error("Failed to fetch role permissions")
+		return nil, err
+	}
+	perms := make(map[int64]map[string]string)
+	for _, row := range rows {
+		if perms[row.Role] == nil {
+			perms[row.Role] = make(map[string]string)
+		}
+		perms[row.Role][row.Module] = row.Perms
+	}
+	return perms, nil
+}
+
+// CreateRole adds a role with its permissions
+func CreateRole(in RoleInput) (*Role, error) {
+	var role *Role
+	err := withTx(func(tx *sqlx.Tx) error {
+		var id int64
+		err := tx.Get(&id, `INSERT INTO user_roles (name, description) VALUES ($1, $2) RETURNING id`,
+			strings.TrimSpace(in.Name), in.Description)
+		if err != nil {
+			return roleWriteError(err)
+		}
+		if err := replaceRolePermissions(tx, id, in.Permissions); err != nil {
+			return err
+		}
+		role, err = getRole(tx, id)
+		return err
+	})
+	return role, err
+}
+
+// UpdateRole changes the name and description of a role and, when given, replaces its permissions
+func UpdateRole(id int64, in RoleInput) (*Role, error) {
+	var role *Role
+	err := withTx(func(tx *sqlx.Tx) error {
+		res, err := tx.Exec(`UPDATE user_roles SET name = $1, description = $2, updated = NOW() WHERE id = $3`,
+			strings.TrimSpace(in.Name), in.Description, id)
+		if err != nil {
+			return roleWriteError(err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrRoleNotFound
+		}
+		if in.Permissions != nil {
+			if err := replaceRolePermissions(tx, id, in.Permissions); err != nil {
+				return err
+			}
+		}
+		role, err = getRole(tx, id)
+		return err
+	})
+	return role, err
+}
+
+// DeleteRole removes a role that no user holds
+func DeleteRole(id int64) error {
+	res, err := db.GetDB().Exec(`DELETE FROM user_roles WHERE id = $1`, id)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrRoleInUse
+		}
+		log.WithError(err).Error("Failed to delete role")
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrRoleNotFound
+	}
+	return nil
+}
+
+// SetUserRole assigns a role to the user with the given UID
+func SetUserRole(userUID string, roleID int64) error {
+	res, err := db.GetDB().Exec(`UPDATE users SET user_role = $1, updated = NOW() WHERE uid = $2`, roleID, userUID)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrRoleNotFound
+		}
+		log.WithError(err).Error("Failed to set user role")
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func replaceRolePermissions(tx *sqlx.Tx, roleID int64, perms map[string]string) error {
+	if _, err := tx.Exec(`DELETE FROM user_role_permissions WHERE user_role = $1`, roleID); err != nil {
+		log.WithError(err).Error("Failed to clear role permissions")
+		return err
+	}
+	for module, p := range perms {
+		if p == "" {
+			continue
+		}
+		if _, err := tx.Exec(`INSERT INTO user_role_permissions (user_role, sys_module, sys_perms) VALUES ($1, $2, $3)`,
+			roleID, module, p); err != nil {
+			log.WithError(err).Error("Failed to save role permission")
+			return err
+		}
+	}
+	return nil
+}
+
+func roleWriteError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrRoleExists
+	}
+	log.WithError(err).Error("Failed to save role")
+	return err
+}
+
+func withTx(fn func(tx *sqlx.Tx) error) error {
+	tx, err := db.GetDB().Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// LoadAuthUser loads an active user together with the permissions of their role and their
+// assigned org units
+func LoadAuthUser(userID int64) (*auth.User, error) {
+	var row struct {
+		ID       int64  `db:"id"`
+		Username string `db:"username"`
+		IsAdmin  bool   `db:"is_admin_user"`
+		RoleID   int64  `db:"user_role"`
+		Role     string `db:"role"`
+	}
+	err := db.GetDB().Get(&row, `
+		SELECT u.id, u.username, u.is_admin_user, u.user_role, r.name AS role
+		FROM users u JOIN user_roles r ON r.id = u.user_role
+		WHERE u.id = $1 AND u.is_active`, userID)
+	if err != nil {
+		return nil, err
+	}
+	perms, err := rolePermissions(db.GetDB(), row.RoleID)
+	if err != nil {
+		return nil, err
+	}
+	user := &auth.User{
+		ID:       row.ID,
+		Username: row.Username,
+		Role:     row.Role,
+		IsAdmin:  row.IsAdmin,
+		Perms:    perms[row.RoleID],
+	}
+	if user.Perms == nil {
+		user.Perms = map[string]string{}
+	}
+	if user.OrgUnits, err = loadUserOrgUnits(db.GetDB(), row.ID); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -5,8 +5,10 @@ import (
 	"dhis2gw/clients"
 	"dhis2gw/config"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/jmoiron/sqlx"
+	"github.com/robfig/cron/v3"
 	"github.com/samber/lo"
 	log "github.com/sirupsen/logrus"
 	"sync/atomic"
@@ -276,7 +278,7 @@ func CreateAsyncJobSchedule(
 
 }
 
-func CheckDhis2AsyncJobTaskSummary(tx *sqlx.Tx, schedule Schedule) (*AsyncJobImportSummary, error) {
+func CheckDhis2AsyncJobTaskSummary(schedule Schedule) (*AsyncJobImportSummary, error) {
 	//if server, ok := ServerMap[fmt.Sprintf("%d", schedule.ServerID)]; ok {
 	//
 	//}
@@ -377,3 +379,98 @@ func CheckDhis2AsyncJobStatus(schedule Schedule) (bool, bool, error) {
 
 	return false, false, nil
 }
+
+// scheduleColumns lists the schedules columns scanned into Schedule, coalescing nullable ones
+const scheduleColumns = `id, sched_type, params, COALESCE(sched_url, '') AS sched_url,
+	COALESCE(sched_content, '') AS sched_content, COALESCE(command, '') AS command,
+	COALESCE(command_args, '') AS command_args, first_run_at, repeat, COALESCE(repeat_interval, 0) AS repeat_interval,
+	COALESCE(cron_expression, '') AS cron_expression, last_run_at, next_run_at, status, is_active,
+	request_id, server_id, server_in_cc, COALESCE(async_job_type, '') AS async_job_type,
+	COALESCE(async_jobid, '') AS async_jobid, created_by, COALESCE(created, now()) AS created,
+	COALESCE(updated, now()) AS updated`
+
+// GetDueScheduleIDs returns the ids of up to limit active schedules whose next run is due
+func GetDueScheduleIDs(db *sqlx.DB, limit int) ([]int64, error) {
+	var ids []int64
+	err := db.Select(&ids, `SELECT id FROM schedules
+		WHERE is_active AND next_run_at <= now() AND status NOT IN ('canceled', 'expired')
+		ORDER BY next_run_at LIMIT $1`, limit)
+	return ids, err
+}
+
+// LockScheduleForProcessing loads and locks a schedule within tx. It returns false when
+// another processor holds the schedule or it is no longer due.
+func LockScheduleForProcessing(tx *sqlx.Tx, id int64) (Schedule, bool, error) {
+	var schedule Schedule
+	err := tx.Get(&schedule, `SELECT `+scheduleColumns+` FROM schedules
+		WHERE id = $1 AND is_active AND next_run_at <= now() FOR UPDATE SKIP LOCKED`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return schedule, false, nil
+	}
+	if err != nil {
+		return schedule, false, err
+	}
+	return schedule, true, nil
+}
+
+// ClaimSchedule takes a due schedule for one run: in a short transaction it locks the row and
+// moves next_run_at to leaseUntil, so that no other processor picks the schedule up while it
+// runs, or before leaseUntil should this processor die. It returns the schedule as it was
+// before the claim, or false when another processor holds it or it is no longer due.
+func ClaimSchedule(db *sqlx.DB, id int64, leaseUntil time.Time) (Schedule, bool, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return Schedule{}, false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	schedule, ok, err := LockScheduleForProcessing(tx, id)
+	if err != nil || !ok {
+		return schedule, false, err
+	}
+	if _, err := tx.Exec(`UPDATE schedules SET next_run_at = $1 WHERE id = $2`, leaseUntil, id); err != nil {
+		return schedule, false, err
+	}
+	return schedule, true, tx.Commit()
+}
+
+// NextRun computes when the schedule should run next, in the configured time zone.
+// The boolean is false for schedules that do not repeat.
+func (s *Schedule) NextRun(now time.Time) (time.Time, bool, error) {
+	loc := currentLocation()
+	now = now.In(loc)
+	next := s.NextRunAt.In(loc)
+	step := func(t time.Time) time.Time { return t }
+	switch s.Repeat {
+	case "", "never":
+		return time.Time{}, false, nil
+	case "interval":
+		if s.RepeatInterval <= 0 {
+			return time.Time{}, false, fmt.Errorf("schedule %d has no repeat interval", s.ID)
+		}
+		return now.Add(time.Duration(s.RepeatInterval) * time.Second), true, nil
+	case "cron":
+		sched, err := cron.ParseStandard(s.CronExpression)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("schedule %d has an invalid cron expression: %w", s.ID, err)
+		}
+		return sched.Next(now), true, nil
+	case "hourly":
+		step = func(t time.Time) time.Time { return t.Add(time.Hour) }
+	case "daily":
+		step = func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }
+	case "weekly":
+		step = func(t time.Time) time.Time { return t.AddDate(0, 0, 7) }
+	case "monthly":
+		step = func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }
+	case "yearly":
+		step = func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }
+	default:
+		return time.Time{}, false, fmt.Errorf("schedule %d has unknown repeat %q", s.ID, s.Repeat)
+	}
+	// keep the wall clock time of the original schedule and skip missed runs
+	for !next.After(now) {
+		next = step(next)
+	}
+	return next, true, nil
+}
@@ -0,0 +1,322 @@
+package models
+
+import (
+	"database/sql"
+	"dhis2gw/db"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrInvalidServer  = errors.New("invalid server")
+	ErrServerNotFound = errors.New("server not found")
+	ErrServerExists   = errors.New("a server with this name already exists")
+	ErrServerInUse    = errors.New("server is referenced by requests or schedules; suspend it instead")
+	ErrUnknownServers = errors.New("unknown servers")
+)
+
+var (
+	serverHTTPMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+	serverAuthMethods = []string{"", "Basic", "Token"}
+	// serverSecretFields are the JSON keys left out of API responses
+	serverSecretFields = []string{"password", "AuthToken"}
+)
+
+// ServerSourcesInput replaces the servers allowed to send requests to a server
+type ServerSourcesInput struct {
+	AllowedSources []string `json:"allowedSources" example:"localhost"`
+}
+
+// AllowedSources returns the names of the servers allowed to send requests to the server,
+// as loaded by the registry functions
+func (s *Server) AllowedSources() []string { return s.s.AllowedSources }
+
+// Public returns the server as a map without its password and auth token
+func (s *Server) Public() map[string]any {
+	srv := s.Self()
+	for _, field := range serverSecretFields {
+		delete(srv, field)
+	}
+	srv["allowedSources"] = s.s.AllowedSources
+	return srv
+}
+
+func (s *Server) validate() error {
+	var problems []string
+	if strings.TrimSpace(s.s.Name) == "" {
+		problems = append(problems, "name is required")
+	}
+	if u, err := url.ParseRequestURI(s.s.URL); err != nil || u.Host == "" {
+		problems = append(problems, "URL must be an absolute URL")
+	}
+	if !slices.Contains(serverHTTPMethods, s.s.HTTPMethod) {
+		problems = append(problems, fmt.Sprintf("HTTPMethod must be one of %s", strings.Join(serverHTTPMethods, ", ")))
+	}
+	if !slices.Contains(serverAuthMethods, s.s.AuthMethod) {
+		problems = append(problems, "AuthMethod must be Basic or Token")
+	}
+	if s.s.StartOfSubmissionPeriod < 0 || s.s.EndOfSubmissionPeriod > 24 ||
+		s.s.StartOfSubmissionPeriod > s.s.EndOfSubmissionPeriod {
+		problems = append(problems, "the submission period must be hours within 0 and 24, start before end")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidServer, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func decodeServer(body []byte, srv *Server) error {
+	if err := json.Unmarshal(body, &srv.s); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidServer, err.Error())
+	}
+	return nil
+}
+
+// ListServers returns a page of servers ordered by name
+func ListServers(page, pageSize int) ([]Server, int64, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = 20
+	}
+	dbConn := db.GetDB()
+	rows, total, err := GetServers(dbConn, strconv.Itoa(page), strconv.Itoa(pageSize),
+		[]string{"name:asc"}, "uid", nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	servers := make([]Server, 0, len(rows))
+	for _, row := range rows {
+		uid, _ := row["uid"].(string)
+		srv, err := getServerByUID(dbConn, uid, false)
+		if err != nil {
+			return nil, 0, err
+		}
+		servers = append(servers, srv)
+	}
+	return servers, total, nil
+}
+
+// GetServerByUID returns a server together with the names of its allowed sources
+func GetServerByUID(uid string) (Server, error) {
+	return getServerByUID(db.GetDB(), uid, false)
+}
+
+func getServerByUID(q sqlx.Queryer, uid string, forUpdate bool) (Server, error) {
+	query := `SELECT * FROM servers WHERE uid = $1`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+	srv := Server{}
+	err := sqlx.Get(q, &srv.s, query, uid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Server{}, ErrServerNotFound
+	}
+	if err != nil {
+		return Server{}, err
+	}
+	srv.s.AllowedSources, err = serverAllowedSources(q, int64(srv.s.ID))
+	return srv, err
+}
+
+func serverAllowedSources(q sqlx.Queryer, serverID int64) ([]string, error) {
+	names := []string{}
+	err := sqlx.Select(q, &names, `
+		SELECT s.name FROM server_allowed_sources a JOIN servers s ON s.id = ANY (a.allowed_sources)
+		WHERE a.server_id = $1 ORDER BY s.name`, serverID)
+	return names, err
+}
+
+// CreateServer registers a server decoded from the JSON body of the registry API
+func CreateServer(body []byte) (Server, error) {
+	srv := Server{}
+	if err := decodeServer(body, &srv); err != nil {
+		return Server{}, err
+	}
+	if srv.s.HTTPMethod == "" {
+		srv.s.HTTPMethod = "POST"
+	}
+	if srv.s.EndOfSubmissionPeriod == 0 {
+		srv.s.EndOfSubmissionPeriod = 24
+	}
+	if err := srv.validate(); err != nil {
+		return Server{}, err
+	}
+	if srv.ExistsInDB() {
+		return Server{}, ErrServerExists
+	}
+	if _, err := serverIDsByName(db.GetDB(), srv.s.AllowedSources); err != nil {
+		return Server{}, err
+	}
+	serverJSON, err := json.Marshal(srv.s)
+	if err != nil {
+		return Server{}, err
+	}
+	created, err := CreateServerFromJSON(db.GetDB(), serverJSON)
+	if err != nil {
+		return Server{}, serverWriteError(err)
+	}
+	if err := ReloadServers(); err != nil {
+		log.WithError(err).Error("Failed to reload server cache")
+		return Server{}, err
+	}
+	return GetServerByUID(created.UID())
+}
+
+// UpdateServer applies the fields present in a JSON body to a server; fields left out keep
+// their values, and allowedSources replaces the allowed sources when given.
+func UpdateServer(uid string, body []byte) (Server, error) {
+	var srv Server
+	err := withServerChanges(func(tx *sqlx.Tx) error {
+		var err error
+		if srv, err = getServerByUID(tx, uid, true); err != nil {
+			return err
+		}
+		id := srv.s.ID
+		if err := decodeServer(body, &srv); err != nil {
+			return err
+		}
+		srv.s.ID, srv.s.UID = id, uid
+		if err := srv.validate(); err != nil {
+			return err
+		}
+		if _, err := tx.NamedExec(updateServerSQL, srv.s); err != nil {
+			return err
+		}
+		return setServerAllowedSources(tx, int64(srv.s.ID), srv.s.AllowedSources)
+	})
+	if err != nil {
+		return Server{}, err
+	}
+	return GetServerByUID(uid)
+}
+
+// SetServerSuspended suspends or resumes a server
+func SetServerSuspended(uid string, suspended bool) (Server, error) {
+	err := withServerChanges(func(tx *sqlx.Tx) error {
+		res, err := tx.Exec(`UPDATE servers SET suspended = $1, updated = NOW() WHERE uid = $2`, suspended, uid)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrServerNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return Server{}, err
+	}
+	return GetServerByUID(uid)
+}
+
+// SetServerSources replaces the servers, named in sources, that may send requests to a server
+func SetServerSources(uid string, sources []string) (Server, error) {
+	dbConn := db.GetDB()
+	srv, err := getServerByUID(dbConn, uid, false)
+	if err != nil {
+		return Server{}, err
+	}
+	ids, err := serverIDsByName(dbConn, sources)
+	if err != nil {
+		return Server{}, err
+	}
+	allowedSources := ServerAllowedApps{ServerID: int64(srv.s.ID), AllowedServers: ids}
+	if err := allowedSources.Save(); err != nil {
+		return Server{}, err
+	}
+	if err := ReloadServers(); err != nil {
+		log.WithError(err).Error("Failed to reload server cache")
+		return Server{}, err
+	}
+	return GetServerByUID(uid)
+}
+
+// DeleteServer removes a server and its allowed sources, and drops it from the allowed
+// sources of other servers. Servers that requests or schedules refer to cannot be deleted.
+func DeleteServer(uid string) error {
+	return withServerChanges(func(tx *sqlx.Tx) error {
+		srv, err := getServerByUID(tx, uid, true)
+		if err != nil {
+			return err
+		}
+		id := int64(srv.s.ID)
+		if _, err := tx.Exec(`DELETE FROM server_allowed_sources WHERE server_id = $1`, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE server_allowed_sources SET allowed_sources = array_remove(allowed_sources, $1),
+			updated = NOW() WHERE $1 = ANY (allowed_sources)`, id); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`DELETE FROM servers WHERE id = $1`, id)
+		return err
+	})
+}
+
+// setServerAllowedSources resolves source server names and stores them as the allowed
+// sources of a server
+func setServerAllowedSources(tx *sqlx.Tx, serverID int64, sources []string) error {
+	ids, err := serverIDsByName(tx, sources)
+	if err != nil {
+		return err
+	}
+	allowedSources := ServerAllowedApps{ServerID: serverID, AllowedServers: ids}
+	return allowedSources.save(tx)
+}
+
+// serverIDsByName returns the ids of the named servers, failing with ErrUnknownServers when
+// any of them is not registered
+func serverIDsByName(q sqlx.Queryer, names []string) (pq.Int64Array, error) {
+	var found []struct {
+		ID   int64  `db:"id"`
+		Name string `db:"name"`
+	}
+	if err := sqlx.Select(q, &found, `SELECT id, name FROM servers WHERE name = ANY($1)`, pq.Array(names)); err != nil {
+		return nil, err
+	}
+	ids := make(pq.Int64Array, 0, len(found))
+	foundNames := make([]string, 0, len(found))
+	for _, f := range found {
+		ids = append(ids, f.ID)
+		foundNames = append(foundNames, f.Name)
+	}
+	if missing := missingFrom(names, foundNames); len(missing) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownServers, strings.Join(missing, ", "))
+	}
+	return ids, nil
+}
+
+// withServerChanges runs fn in a transaction and reloads the server caches once it commits,
+// so the change takes effect without a restart
+func withServerChanges(fn func(tx *sqlx.Tx) error) error {
+	if err := withTx(fn); err != nil {
+		return serverWriteError(err)
+	}
+	if err := ReloadServers(); err != nil {
+		log.WithError(err).Error("Failed to reload server cache")
+		return err
+	}
+	return nil
+}
+
+func serverWriteError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "23505":
+			return ErrServerExists
+		case "23503":
+			return ErrServerInUse
+		}
+	}
+	return err
+}
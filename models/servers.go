@@ -1,6 +1,7 @@
 package models
 
 import (
+	"database/sql"
 	"dhis2gw/config"
 	"dhis2gw/db"
 	"dhis2gw/utils"
@@ -57,6 +58,11 @@ func InitServers() error {
 	if err := CreateBaseDHIS2Server(); err != nil {
 		return err
 	}
+	return ReloadServers()
+}
+
+// ReloadServers reloads the ServerMap and ServerMapByName caches from the servers table
+func ReloadServers() error {
 	rows, err := db.GetDB().Queryx("SELECT * FROM servers")
 
 	if err != nil {
@@ -98,6 +104,19 @@ func getServerFromCacheByName(name string) (Server, bool) {
 	return srv, ok
 }
 
+// GetServerFromCacheByName returns the cached server with the given name
+func GetServerFromCacheByName(name string) (Server, bool) {
+	return getServerFromCacheByName(name)
+}
+
+// GetServerFromCacheByID returns the cached server with the given id
+func GetServerFromCacheByID(id int64) (Server, bool) {
+	serverCacheMu.RLock()
+	defer serverCacheMu.RUnlock()
+	srv, ok := ServerMap[strconv.FormatInt(id, 10)]
+	return srv, ok
+}
+
 // ServerID is the id for the server
 type ServerID int64
 
@@ -144,13 +163,19 @@ type ServerAllowedApps struct {
 	AllowedServers pq.Int64Array `db:"allowed_sources" json:"allowed_sources"`
 }
 
-func (sa *ServerAllowedApps) Save() {
-	dbConn := db.GetDB()
-	_, err := dbConn.NamedExec(`INSERT INTO server_allowed_sources (server_id, allowed_sources)
-			VALUES(:server_id, :allowed_sources)`, sa)
+// Save stores the allowed sources of the server, replacing any it had
+func (sa *ServerAllowedApps) Save() error {
+	return sa.save(db.GetDB())
+}
+
+func (sa *ServerAllowedApps) save(e sqlx.Ext) error {
+	_, err := sqlx.NamedExec(e, `INSERT INTO server_allowed_sources (server_id, allowed_sources)
+			VALUES(:server_id, :allowed_sources)
+		ON CONFLICT (server_id) DO UPDATE SET allowed_sources = EXCLUDED.allowed_sources, updated = NOW()`, sa)
 	if err != nil {
 		log.WithError(err).Error("Failed to save server allowed sources")
 	}
+	return err
 }
 
 // ID return the id of this server
@@ -189,6 +214,9 @@ func (s *Server) AllowCallbacks() bool { return s.s.AllowCallbacks }
 // UseAsync ...
 func (s *Server) UseAsync() bool { return s.s.UseAsync }
 
+// AllowCopies returns whether server accepts a submission it has already received
+func (s *Server) AllowCopies() bool { return s.s.AllowCopies }
+
 // CallbackURL return the server callback url
 func (s *Server) CallbackURL() string { return s.s.CallbackURL }
 
@@ -251,7 +279,9 @@ func GetServerByName(name string) (Server, error) {
 	err := db.GetDB().Get(&srv.s, "SELECT * FROM servers WHERE name = $1", name)
 
 	if err != nil {
-		fmt.Printf("Error geting server: [%v]", err)
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.WithError(err).WithField("Name", name).Error("Failed to get server")
+		}
 		return Server{}, errors.New(fmt.Sprintf("Server with name '%s' Not found!", name))
 	}
 	return srv, nil
@@ -316,7 +346,7 @@ func (s *Server) InSubmissionPeriod(tx *sqlx.Tx) bool {
 
 // ServerDBFields returns the fields in the servers table
 func (s *Server) ServerDBFields() []string {
-	e := reflect.ValueOf(s).Elem()
+	e := reflect.ValueOf(&s.s).Elem()
 	var ret []string
 	for i := 0; i < e.NumField(); i++ {
 		t := e.Type().Field(i).Tag.Get("db")
@@ -340,8 +370,10 @@ func (s *Server) SetUID(uid string) {
 
 var serversFields = new(Server).ServerDBFields()
 
+// GetServers returns a page of servers as maps of the requested fields, together with the
+// number of servers matching filters
 func GetServers(db *sqlx.DB, page string, pageSize string,
-	orderBy []string, fields string, filters []string) []dbutils.MapAnything {
+	orderBy []string, fields string, filters []string) ([]dbutils.MapAnything, int64, error) {
 
 	filtered, _ := utils.GetFieldsAndRelationships(serversFields, fields)
 	serversTable := dbutils.Table{Name: "servers", Alias: "s"}
@@ -368,7 +400,8 @@ func GetServers(db *sqlx.DB, page string, pageSize string,
 	var count int64
 	err := db.Get(&count, countquery)
 	if err != nil {
-		return nil
+		log.WithError(err).Error("Failed to count servers")
+		return nil, 0, err
 	}
 	pager := dbutils.GetPaginator(count, pageSize, page, true)
 	qbuild.Limit = pager.PageSize
@@ -380,17 +413,20 @@ func GetServers(db *sqlx.DB, page string, pageSize string,
 	err = db.Select(&results, jsonquery)
 	if err != nil {
 		log.WithError(err).Error("Failed to get query results")
+		return nil, 0, err
 	}
-	return results
+	return results, count, nil
 }
 
 const insertServerSQL = `
 INSERT INTO servers(uid, name, username, password, url, ipaddress, http_method, auth_method, auth_token,
        callback_url, allow_callbacks, cc_urls, allow_copies, start_submission_period, end_submission_period,
-       parse_responses, use_async, use_ssl, suspended, ssl_client_certkey_file, json_response_xpath, xml_response_xpath, endpoint_type, url_params)
+       parse_responses, use_async, use_ssl, suspended, ssl_client_certkey_file, json_response_xpath, xml_response_xpath, endpoint_type, url_params,
+       system_type)
        VALUES (:uid,:name,:username,:password,:url,:ipaddress,:http_method,:auth_method,:auth_token, :callback_url,:allow_callbacks, 
                :cc_urls,:allow_copies,:start_submission_period,:end_submission_period,:parse_responses,:use_async, :use_ssl,
-               :suspended,:ssl_client_certkey_file,:json_response_xpath,:xml_response_xpath, :endpoint_type, :url_params)
+               :suspended,:ssl_client_certkey_file,:json_response_xpath,:xml_response_xpath, :endpoint_type, :url_params,
+               :system_type)
 	RETURNING id
 `
 
@@ -469,7 +505,9 @@ func CreateServerFromJSON(db *sqlx.DB, serverJSON []byte) (Server, error) {
 		return GetServerByName(srv.Name())
 	} else {
 		// create server
-		srv.SetUID(utils.GetUID())
+		if !srv.ValidateUID() {
+			srv.SetUID(utils.GetUID())
+		}
 		rows, err := db.NamedQuery(insertServerSQL, srv.s)
 		if err != nil {
 			log.WithError(err).Error("Failed to save server to database")
@@ -497,10 +535,12 @@ func CreateServerFromJSON(db *sqlx.DB, serverJSON []byte) (Server, error) {
 const updateServerSQL = `
 UPDATE servers SET (name, username, password, url, ipaddress, http_method,auth_method, auth_token,
        callback_url, allow_callbacks, cc_urls, allow_copies, start_submission_period, end_submission_period,
-       parse_responses, use_async, use_ssl, suspended, ssl_client_certkey_file, json_response_xpath, xml_response_xpath, endpoint_type, url_params)
+       parse_responses, use_async, use_ssl, suspended, ssl_client_certkey_file, json_response_xpath, xml_response_xpath, endpoint_type, url_params,
+       system_type, updated)
 	= (:name,:username,:password,:url,:ipaddress,:http_method,:auth_method,:auth_token, :callback_url,:allow_callbacks, 
                :cc_urls,:allow_copies,:start_submission_period,:end_submission_period,:parse_responses,:use_async, :use_ssl,
-               :suspended,:ssl_client_certkey_file,:json_response_xpath,:xml_response_xpath, :endpoint_type, :url_params)
+               :suspended,:ssl_client_certkey_file,:json_response_xpath,:xml_response_xpath, :endpoint_type, :url_params,
+               :system_type, NOW())
 	WHERE uid = :uid
 `
 
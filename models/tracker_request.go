@@ -0,0 +1,87 @@
+package models
+
+import (
+	"fmt"
+
+	"github.com/HISP-Uganda/go-dhis2-sdk/tracker"
+)
+
+// TrackerRequest is the body accepted by POST /tracker. The import options are
+// forwarded to DHIS2 as query parameters while the nested tracked entities
+// form the /tracker payload.
+type TrackerRequest struct {
+	ImportStrategy     string                        `json:"importStrategy,omitempty" example:"CREATE_AND_UPDATE"`
+	AtomicMode         string                        `json:"atomicMode,omitempty" example:"OBJECT"`
+	FlushMode          string                        `json:"flushMode,omitempty" example:"AUTO"`
+	ValidationMode     string                        `json:"validationMode,omitempty" example:"FULL"`
+	DryRun             bool                          `json:"dryRun,omitempty"`
+	SkipTextValidation bool                          `json:"skipTextValidation,omitempty"`
+	TrackedEntities    []tracker.NestedTrackedEntity `json:"trackedEntities"`
+}
+
+type TrackerResponse struct {
+	Message      string `json:"message" example:"Tracker request queued for processing"`
+	SubmissionID int64  `json:"submission_id" example:"1035"`
+	TaskID       string `json:"task_id" example:"c5265e8f-2f15-4090-b25e-303d748adfce"`
+}
+
+// ToDHIS2TrackerPayload returns the nested payload posted to DHIS2.
+func (r *TrackerRequest) ToDHIS2TrackerPayload() tracker.NestedPayload {
+	return tracker.NestedPayload{TrackedEntities: r.TrackedEntities}
+}
+
+// ImportParams returns the query parameters for the DHIS2 /tracker endpoint.
+// Imports are always synchronous so the worker can store the import report.
+func (r *TrackerRequest) ImportParams() map[string]string {
+	params := map[string]string{"async": "false"}
+	if r.ImportStrategy != "" {
+		params["importStrategy"] = r.ImportStrategy
+	}
+	if r.AtomicMode != "" {
+		params["atomicMode"] = r.AtomicMode
+	}
+	if r.FlushMode != "" {
+		params["flushMode"] = r.FlushMode
+	}
+	if r.ValidationMode != "" {
+		params["validationMode"] = r.ValidationMode
+	}
+	if r.DryRun {
+		params["dryRun"] = "true"
+	}
+	if r.SkipTextValidation {
+		params["skipTextPatternValidation"] = "true"
+	}
+	return params
+}
+
+// Validate checks constraints that the JSON schema cannot express.
+func (r *TrackerRequest) Validate() error {
+	if len(r.TrackedEntities) == 0 {
+		return fmt.Errorf("at least one tracked entity is required")
+	}
+	return nil
+}
+
+// OrgUnits returns the distinct org units the tracked entities, enrollments and events are
+// written to, in the order they first appear.
+func (r *TrackerRequest) OrgUnits() []string {
+	var orgUnits []string
+	seen := map[string]bool{}
+	add := func(ou string) {
+		if ou != "" && !seen[ou] {
+			seen[ou] = true
+			orgUnits = append(orgUnits, ou)
+		}
+	}
+	for _, te := range r.TrackedEntities {
+		add(te.OrgUnit)
+		for _, en := range te.Enrollments {
+			add(en.OrgUnit)
+			for _, ev := range en.Events {
+				add(ev.OrgUnit)
+			}
+		}
+	}
+	return orgUnits
+}
@@ -0,0 +1,141 @@
+package models
+
+import (
+	"database/sql"
+	"dhis2gw/auth"
+	"dhis2gw/db"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+)
+
+// UserOrgUnit is an org unit a user may submit data for, including everything below it
+type UserOrgUnit struct {
+	UID   string `db:"uid" json:"uid" example:"akV6429SUqu"`
+	Code  string `db:"code" json:"code,omitempty"`
+	Name  string `db:"name" json:"name" example:"Kampala District"`
+	Path  string `db:"path" json:"path" example:"/akV6429SUqu/vbZsvaOnYph"`
+	Level int    `db:"hierarchylevel" json:"level" example:"3"`
+}
+
+// UserOrgUnitsInput replaces the org units assigned to a user
+type UserOrgUnitsInput struct {
+	OrgUnits []string `json:"orgUnits" example:"akV6429SUqu"`
+}
+
+// ErrUnknownOrgUnits is returned when assigning org units that are not in the organisationunit table
+var ErrUnknownOrgUnits = errors.New("unknown org units")
+
+const userOrgUnitsSQL = `
+	SELECT ou.id, ou.uid, COALESCE(ou.code, '') AS code, ou.name, ou.path, ou.hierarchylevel
+	FROM user_orgunits uo JOIN organisationunit ou ON ou.id = uo.orgunit_id
+	WHERE uo.user_id = $1 AND NOT ou.deleted ORDER BY ou.path`
+
+func loadUserOrgUnits(q sqlx.Queryer, userID int64) ([]auth.OrgUnit, error) {
+	rows, err := q.Queryx(userOrgUnitsSQL, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var orgUnits []auth.OrgUnit
+	for rows.Next() {
+		var ou auth.OrgUnit
+		if err := rows.Scan(&ou.ID, &ou.UID, &ou.Code, &ou.Name, &ou.Path, &ou.Level); err != nil {
+			return nil, err
+		}
+		orgUnits = append(orgUnits, ou)
+	}
+	return orgUnits, rows.Err()
+}
+
+// GetUserOrgUnits returns the org units assigned to a user. It returns sql.ErrNoRows when the
+// user does not exist.
+func GetUserOrgUnits(userUID string) ([]UserOrgUnit, error) {
+	dbConn := db.GetDB()
+	var userID int64
+	if err := dbConn.Get(&userID, `SELECT id FROM users WHERE uid = $1`, userUID); err != nil {
+		return nil, err
+	}
+	orgUnits := []UserOrgUnit{}
+	if err := dbConn.Select(&orgUnits, `
+		SELECT ou.uid, COALESCE(ou.code, '') AS code, ou.name, ou.path, ou.hierarchylevel
+		FROM user_orgunits uo JOIN organisationunit ou ON ou.id = uo.orgunit_id
+		WHERE uo.user_id = $1 AND NOT ou.deleted ORDER BY ou.path`, userID); err != nil {
+		log.WithError(err).Error("Failed to fetch user org units")
+		return nil, err
+	}
+	return orgUnits, nil
+}
+
+// SetUserOrgUnits replaces the org units assigned to a user. An empty list removes every
+// assignment. It returns sql.ErrNoRows when the user does not exist and ErrUnknownOrgUnits
+// naming the UIDs that are not known org units.
+func SetUserOrgUnits(userUID string, orgUnitUIDs []string) ([]UserOrgUnit, error) {
+	err := withTx(func(tx *sqlx.Tx) error {
+		var userID int64
+		if err := tx.Get(&userID, `SELECT id FROM users WHERE uid = $1 FOR UPDATE`, userUID); err != nil {
+			return err
+		}
+		var found []string
+		if err := tx.Select(&found, `SELECT uid FROM organisationunit WHERE uid = ANY($1) AND NOT deleted`,
+			pq.Array(orgUnitUIDs)); err != nil {
+			return err
+		}
+		if missing := missingFrom(orgUnitUIDs, found); len(missing) > 0 {
+			return fmt.Errorf("%w: %s", ErrUnknownOrgUnits, strings.Join(missing, ", "))
+		}
+		if _, err := tx.Exec(`DELETE FROM user_orgunits WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`
+			INSERT INTO user_orgunits (user_id, orgunit_id)
+			SELECT $1, id FROM organisationunit WHERE uid = ANY($2)
+			ON CONFLICT (user_id, orgunit_id) DO NOTHING`, userID, pq.Array(orgUnitUIDs))
+		return err
+	})
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) && !errors.Is(err, ErrUnknownOrgUnits) {
+			log.WithError(err).Error("Failed to set user org units")
+		}
+		return nil, err
+	}
+	return GetUserOrgUnits(userUID)
+}
+
+// GetOrgUnitPaths returns the paths of the given org unit UIDs; unknown and deleted UIDs are left out
+func GetOrgUnitPaths(uids []string) (map[string]string, error) {
+	var rows []struct {
+		UID  string `db:"uid"`
+		Path string `db:"path"`
+	}
+	if err := db.GetDB().Select(&rows, `SELECT uid, path FROM organisationunit WHERE uid = ANY($1) AND NOT deleted`,
+		pq.Array(uids)); err != nil {
+		log.WithError(err).Error("Failed to fetch org unit paths")
+		return nil, err
+	}
+	paths := make(map[string]string, len(rows))
+	for _, r := range rows {
+		paths[r.UID] = r.Path
+	}
+	return paths, nil
+}
+
+// missingFrom returns the distinct values of wanted that are not in found
+func missingFrom(wanted, found []string) []string {
+	known := make(map[string]bool, len(found))
+	for _, uid := range found {
+		known[uid] = true
+	}
+	var missing []string
+	for _, uid := range wanted {
+		if !known[uid] {
+			missing = append(missing, uid)
+			known[uid] = true
+		}
+	}
+	return missing
+}
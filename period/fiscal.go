@@ -0,0 +1,113 @@
+package period
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FiscalCalendar converts fiscal year and fiscal quarter labels of a source system into
+// DHIS2 periods. The fiscal year is named after the calendar year it starts in, so with a
+// July start "2025-2026" runs from July 2025 to June 2026.
+type FiscalCalendar struct {
+	StartMonth time.Month
+}
+
+var financialTypes = map[time.Month]Type{
+	time.January:   Yearly,
+	time.April:     FinancialApril,
+	time.July:      FinancialJuly,
+	time.September: FinancialSep,
+	time.October:   FinancialOct,
+	time.November:  FinancialNov,
+}
+
+// fiscalYearPattern matches labels like 2025, 2025-2026, 2025/26 and FY2025-26
+var fiscalYearPattern = regexp.MustCompile(`^(?i:FY)?\s*(\d{4})(?:\s*[-/]\s*(\d{2}|\d{4}))?$`)
+
+func (fc FiscalCalendar) validate() error {
+	if fc.StartMonth < time.January || fc.StartMonth > time.December {
+		return fmt.Errorf("invalid fiscal year start month %d", fc.StartMonth)
+	}
+	return nil
+}
+
+// YearType returns the DHIS2 period type of a whole fiscal year
+func (fc FiscalCalendar) YearType() (Type, error) {
+	if err := fc.validate(); err != nil {
+		return "", err
+	}
+	typ, ok := financialTypes[fc.StartMonth]
+	if !ok {
+		return "", fmt.Errorf("DHIS2 has no financial year period type starting in %s", fc.StartMonth)
+	}
+	return typ, nil
+}
+
+// ParseFiscalYear returns the calendar year a fiscal year label starts in
+func ParseFiscalYear(label string) (int, error) {
+	m := fiscalYearPattern.FindStringSubmatch(strings.TrimSpace(label))
+	if m == nil {
+		return 0, fmt.Errorf("invalid fiscal year %q", label)
+	}
+	start, _ := strconv.Atoi(m[1])
+	if m[2] != "" {
+		end, _ := strconv.Atoi(m[2])
+		if len(m[2]) == 2 {
+			end += start / 100 * 100
+			if end < start {
+				end += 100
+			}
+		}
+		if end != start+1 {
+			return 0, fmt.Errorf("invalid fiscal year %q: years are not consecutive", label)
+		}
+	}
+	return start, nil
+}
+
+// Year returns the DHIS2 financial year period of a fiscal year label, e.g. 2025July for
+// "2025-2026" when the fiscal year starts in July.
+func (fc FiscalCalendar) Year(label string) (Period, error) {
+	typ, err := fc.YearType()
+	if err != nil {
+		return Period{}, err
+	}
+	year, err := ParseFiscalYear(label)
+	if err != nil {
+		return Period{}, err
+	}
+	return ForDate(day(year, fc.StartMonth, 1), typ)
+}
+
+// Quarter returns the calendar quarter period of a fiscal quarter label such as "Q1". With
+// a July start, Q1 of "2025-2026" is 2025Q3 and Q3 is 2026Q1. Fiscal quarters only line up
+// with calendar quarters when the fiscal year starts in January, April, July or October.
+func (fc FiscalCalendar) Quarter(fiscalYear, quarter string) (Period, error) {
+	if err := fc.validate(); err != nil {
+		return Period{}, err
+	}
+	if (fc.StartMonth-time.January)%3 != 0 {
+		return Period{}, fmt.Errorf("fiscal quarters starting in %s are not calendar quarters", fc.StartMonth)
+	}
+	year, err := ParseFiscalYear(fiscalYear)
+	if err != nil {
+		return Period{}, err
+	}
+	q := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(quarter)), "Q")
+	n, err := strconv.Atoi(q)
+	if err != nil || n < 1 || n > 4 {
+		return Period{}, fmt.Errorf("invalid fiscal quarter %q", quarter)
+	}
+	return ForDate(day(year, fc.StartMonth+time.Month(3*(n-1)), 1), Quarterly)
+}
+
+// YearOf returns the calendar year the fiscal year containing t starts in
+func (fc FiscalCalendar) YearOf(t time.Time) int {
+	if t.Month() < fc.StartMonth {
+		return t.Year() - 1
+	}
+	return t.Year()
+}
@@ -0,0 +1,97 @@
+package period
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseFiscalYear(t *testing.T) {
+	tests := []struct {
+		label string
+		want  int
+		ok    bool
+	}{
+		{"2025", 2025, true},
+		{"2025-2026", 2025, true},
+		{"2025/26", 2025, true},
+		{"FY2025-26", 2025, true},
+		{"fy 2099-00", 2099, true},
+		{"2025-2027", 0, false},
+		{"2025-24", 0, false},
+		{"FY25", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseFiscalYear(tt.label)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseFiscalYear(%q) = %d, %v; want %d, ok=%v", tt.label, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestFiscalCalendarYear(t *testing.T) {
+	tests := []struct {
+		start time.Month
+		label string
+		want  string
+	}{
+		{time.January, "2025", "2025"},
+		{time.April, "2025-2026", "2025April"},
+		{time.July, "2025-2026", "2025July"},
+		{time.September, "2025/26", "2025Sep"},
+		{time.October, "FY2025-26", "2025Oct"},
+		{time.November, "2024-2025", "2025Nov"},
+	}
+	for _, tt := range tests {
+		p, err := FiscalCalendar{StartMonth: tt.start}.Year(tt.label)
+		if err != nil || p.ID != tt.want {
+			t.Errorf("Year(%q) starting in %s = %v, %v; want %s", tt.label, tt.start, p, err, tt.want)
+		}
+	}
+	if _, err := (FiscalCalendar{StartMonth: time.March}).Year("2025-2026"); err == nil {
+		t.Error("Year accepted a start month DHIS2 has no financial year type for")
+	}
+}
+
+func TestFiscalCalendarQuarter(t *testing.T) {
+	tests := []struct {
+		start   time.Month
+		year    string
+		quarter string
+		want    string
+	}{
+		{time.January, "2025", "Q1", "2025Q1"},
+		{time.April, "2025-2026", "Q4", "2026Q1"},
+		{time.July, "2025-2026", "Q1", "2025Q3"},
+		{time.July, "2025-2026", "q3", "2026Q1"},
+		{time.October, "2025-2026", "2", "2026Q1"},
+	}
+	for _, tt := range tests {
+		p, err := FiscalCalendar{StartMonth: tt.start}.Quarter(tt.year, tt.quarter)
+		if err != nil || p.ID != tt.want {
+			t.Errorf("Quarter(%q, %q) starting in %s = %v, %v; want %s",
+				tt.year, tt.quarter, tt.start, p, err, tt.want)
+		}
+	}
+	for _, tt := range []struct {
+		start   time.Month
+		quarter string
+	}{
+		{time.November, "Q1"},
+		{time.July, "Q5"},
+		{time.July, "Q0"},
+	} {
+		if _, err := (FiscalCalendar{StartMonth: tt.start}).Quarter("2025-2026", tt.quarter); err == nil {
+			t.Errorf("Quarter(%q) starting in %s was accepted", tt.quarter, tt.start)
+		}
+	}
+}
+
+func TestFiscalCalendarYearOf(t *testing.T) {
+	fc := FiscalCalendar{StartMonth: time.July}
+	if got := fc.YearOf(date("2025-06-30")); got != 2024 {
+		t.Errorf("YearOf(2025-06-30) = %d, want 2024", got)
+	}
+	if got := fc.YearOf(date("2025-07-01")); got != 2025 {
+		t.Errorf("YearOf(2025-07-01) = %d, want 2025", got)
+	}
+}
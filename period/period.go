@@ -0,0 +1,320 @@
+// Package period parses DHIS2 period identifiers and converts source system dates and
+// fiscal labels into them.
+package period
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Type is a DHIS2 period type as used in a data set's periodType
+type Type string
+
+const (
+	Daily           Type = "Daily"
+	Weekly          Type = "Weekly"
+	WeeklyWednesday Type = "WeeklyWednesday"
+	WeeklyThursday  Type = "WeeklyThursday"
+	WeeklySaturday  Type = "WeeklySaturday"
+	WeeklySunday    Type = "WeeklySunday"
+	BiWeekly        Type = "BiWeekly"
+	Monthly         Type = "Monthly"
+	BiMonthly       Type = "BiMonthly"
+	Quarterly       Type = "Quarterly"
+	QuarterlyNov    Type = "QuarterlyNov"
+	SixMonthly      Type = "SixMonthly"
+	SixMonthlyApril Type = "SixMonthlyApril"
+	SixMonthlyNov   Type = "SixMonthlyNov"
+	Yearly          Type = "Yearly"
+	FinancialApril  Type = "FinancialApril"
+	FinancialJuly   Type = "FinancialJuly"
+	FinancialSep    Type = "FinancialSep"
+	FinancialOct    Type = "FinancialOct"
+	FinancialNov    Type = "FinancialNov"
+)
+
+// ErrInvalidPeriod is returned for identifiers that are not a valid DHIS2 period
+var ErrInvalidPeriod = errors.New("invalid period")
+
+// Period is a parsed DHIS2 period. Start and End are the first and last day, in UTC.
+type Period struct {
+	ID    string
+	Type  Type
+	Start time.Time
+	End   time.Time
+}
+
+func (p Period) String() string {
+	return p.ID
+}
+
+// Contains reports whether the day of t falls within the period
+func (p Period) Contains(t time.Time) bool {
+	d := day(t.Year(), t.Month(), t.Day())
+	return !d.Before(p.Start) && !d.After(p.End)
+}
+
+// monthSpec describes the period types built from whole months. Periods of a year start in
+// startMonth and are numbered from 1. DHIS2 names the November based types after the year
+// they end in, so their year starts in November of the year before, as labelOffset says.
+type monthSpec struct {
+	months      int
+	startMonth  time.Month
+	format      func(year, index int) string
+	labelOffset int
+}
+
+func numbered(sep string) func(int, int) string {
+	return func(year, index int) string { return fmt.Sprintf("%d%s%d", year, sep, index) }
+}
+
+func named(suffix string) func(int, int) string {
+	return func(year, _ int) string { return fmt.Sprintf("%d%s", year, suffix) }
+}
+
+var monthSpecs = map[Type]monthSpec{
+	Monthly:         {1, time.January, func(y, i int) string { return fmt.Sprintf("%d%02d", y, i) }, 0},
+	BiMonthly:       {2, time.January, func(y, i int) string { return fmt.Sprintf("%d%02dB", y, i) }, 0},
+	Quarterly:       {3, time.January, numbered("Q"), 0},
+	QuarterlyNov:    {3, time.November, numbered("NovQ"), 1},
+	SixMonthly:      {6, time.January, numbered("S"), 0},
+	SixMonthlyApril: {6, time.April, numbered("AprilS"), 0},
+	SixMonthlyNov:   {6, time.November, numbered("NovS"), 1},
+	Yearly:          {12, time.January, named(""), 0},
+	FinancialApril:  {12, time.April, named("April"), 0},
+	FinancialJuly:   {12, time.July, named("July"), 0},
+	FinancialSep:    {12, time.September, named("Sep"), 0},
+	FinancialOct:    {12, time.October, named("Oct"), 0},
+	FinancialNov:    {12, time.November, named("Nov"), 1},
+}
+
+// weekSpec describes the weekly period types. Week 1 is the week, starting on startDay,
+// that contains 4 January, as in ISO 8601.
+type weekSpec struct {
+	startDay time.Weekday
+	prefix   string
+}
+
+var weekSpecs = map[Type]weekSpec{
+	Weekly:          {time.Monday, "W"},
+	WeeklyWednesday: {time.Wednesday, "WedW"},
+	WeeklyThursday:  {time.Thursday, "ThuW"},
+	WeeklySaturday:  {time.Saturday, "SatW"},
+	WeeklySunday:    {time.Sunday, "SunW"},
+}
+
+var patterns = []struct {
+	typ Type
+	re  *regexp.Regexp
+}{
+	{Daily, regexp.MustCompile(`^(\d{4})(\d{2})(\d{2})$`)},
+	{Weekly, regexp.MustCompile(`^(\d{4})W(\d{1,2})$`)},
+	{WeeklyWednesday, regexp.MustCompile(`^(\d{4})WedW(\d{1,2})$`)},
+	{WeeklyThursday, regexp.MustCompile(`^(\d{4})ThuW(\d{1,2})$`)},
+	{WeeklySaturday, regexp.MustCompile(`^(\d{4})SatW(\d{1,2})$`)},
+	{WeeklySunday, regexp.MustCompile(`^(\d{4})SunW(\d{1,2})$`)},
+	{BiWeekly, regexp.MustCompile(`^(\d{4})BiW(\d{1,2})$`)},
+	{Monthly, regexp.MustCompile(`^(\d{4})(\d{2})$`)},
+	{BiMonthly, regexp.MustCompile(`^(\d{4})(\d{2})B$`)},
+	{Quarterly, regexp.MustCompile(`^(\d{4})Q(\d)$`)},
+	{QuarterlyNov, regexp.MustCompile(`^(\d{4})NovQ(\d)$`)},
+	{SixMonthly, regexp.MustCompile(`^(\d{4})S(\d)$`)},
+	{SixMonthlyApril, regexp.MustCompile(`^(\d{4})AprilS(\d)$`)},
+	{SixMonthlyNov, regexp.MustCompile(`^(\d{4})NovS(\d)$`)},
+	{Yearly, regexp.MustCompile(`^(\d{4})$`)},
+	{FinancialApril, regexp.MustCompile(`^(\d{4})April$`)},
+	{FinancialJuly, regexp.MustCompile(`^(\d{4})July$`)},
+	{FinancialSep, regexp.MustCompile(`^(\d{4})Sep$`)},
+	{FinancialOct, regexp.MustCompile(`^(\d{4})Oct$`)},
+	{FinancialNov, regexp.MustCompile(`^(\d{4})Nov$`)},
+}
+
+// Valid reports whether t is a period type this package knows
+func (t Type) Valid() bool {
+	_, monthly := monthSpecs[t]
+	_, weekly := weekSpecs[t]
+	return monthly || weekly || t == Daily || t == BiWeekly
+}
+
+// Parse parses a DHIS2 period identifier such as 20240115, 2024W3, 2024BiW2, 202401,
+// 202401B, 2024Q1, 2024NovQ1, 2024S1, 2024AprilS1, 2024 or 2024July.
+func Parse(id string) (Period, error) {
+	for _, p := range patterns {
+		m := p.re.FindStringSubmatch(id)
+		if m == nil {
+			continue
+		}
+		year, _ := strconv.Atoi(m[1])
+		var (
+			period Period
+			ok     bool
+		)
+		switch {
+		case p.typ == Daily:
+			month, _ := strconv.Atoi(m[2])
+			dom, _ := strconv.Atoi(m[3])
+			period, ok = dailyPeriod(year, month, dom)
+		case p.typ == BiWeekly:
+			index, _ := strconv.Atoi(m[2])
+			period, ok = biWeeklyPeriod(year, index)
+		case p.typ == Yearly || len(m) == 2:
+			period, ok = monthPeriod(p.typ, year, 1)
+		default:
+			index, _ := strconv.Atoi(m[2])
+			if _, weekly := weekSpecs[p.typ]; weekly {
+				period, ok = weekPeriod(p.typ, year, index)
+			} else {
+				period, ok = monthPeriod(p.typ, year, index)
+			}
+		}
+		if !ok {
+			return Period{}, fmt.Errorf("%w: %s", ErrInvalidPeriod, id)
+		}
+		return period, nil
+	}
+	return Period{}, fmt.Errorf("%w: %s", ErrInvalidPeriod, id)
+}
+
+// ForDate returns the period of type typ that contains the day of t
+func ForDate(t time.Time, typ Type) (Period, error) {
+	d := day(t.Year(), t.Month(), t.Day())
+	if typ == Daily {
+		p, _ := dailyPeriod(d.Year(), int(d.Month()), d.Day())
+		return p, nil
+	}
+	if spec, ok := monthSpecs[typ]; ok {
+		year := d.Year() + spec.labelOffset
+		if d.Month() < spec.startMonth {
+			year--
+		}
+		monthsIn := (int(d.Month()) - int(spec.startMonth) + 12) % 12
+		p, _ := monthPeriod(typ, year, monthsIn/spec.months+1)
+		return p, nil
+	}
+	if spec, ok := weekSpecs[typ]; ok {
+		year, week := weekOf(d, spec.startDay)
+		p, _ := weekPeriod(typ, year, week)
+		return p, nil
+	}
+	if typ == BiWeekly {
+		year, week := weekOf(d, time.Monday)
+		p, _ := biWeeklyPeriod(year, (week+1)/2)
+		return p, nil
+	}
+	return Period{}, fmt.Errorf("unsupported period type %q", typ)
+}
+
+// dateLayouts are the date formats accepted from source systems
+var dateLayouts = []string{"2006-01-02", time.RFC3339, "2006-01-02 15:04:05", "2006/01/02", "02/01/2006"}
+
+// ParseDate parses a date as source systems commonly send it
+func ParseDate(s string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised date %q", s)
+}
+
+// IsDate reports whether s is a date rather than a period identifier
+func IsDate(s string) bool {
+	_, err := ParseDate(s)
+	return err == nil
+}
+
+// FromDate converts a source system date into the period of type typ that contains it
+func FromDate(s string, typ Type) (Period, error) {
+	t, err := ParseDate(s)
+	if err != nil {
+		return Period{}, err
+	}
+	return ForDate(t, typ)
+}
+
+// CheckType returns an error unless p has the period type of a data set
+func CheckType(p Period, periodType string) error {
+	if !Type(periodType).Valid() {
+		return fmt.Errorf("unsupported data set period type %q", periodType)
+	}
+	if p.Type != Type(periodType) {
+		return fmt.Errorf("period %s is %s but the data set expects %s periods", p.ID, p.Type, periodType)
+	}
+	return nil
+}
+
+func day(year int, month time.Month, dom int) time.Time {
+	return time.Date(year, month, dom, 0, 0, 0, 0, time.UTC)
+}
+
+func dailyPeriod(year, month, dom int) (Period, bool) {
+	d := day(year, time.Month(month), dom)
+	if d.Year() != year || int(d.Month()) != month || d.Day() != dom {
+		return Period{}, false
+	}
+	return Period{ID: d.Format("20060102"), Type: Daily, Start: d, End: d}, true
+}
+
+func monthPeriod(typ Type, year, index int) (Period, bool) {
+	spec := monthSpecs[typ]
+	if index < 1 || index > 12/spec.months {
+		return Period{}, false
+	}
+	start := day(year-spec.labelOffset, spec.startMonth+time.Month((index-1)*spec.months), 1)
+	return Period{
+		ID:    spec.format(year, index),
+		Type:  typ,
+		Start: start,
+		End:   start.AddDate(0, spec.months, -1),
+	}, true
+}
+
+// firstWeekStart returns the first day of week 1 of year for weeks starting on startDay
+func firstWeekStart(year int, startDay time.Weekday) time.Time {
+	jan4 := day(year, time.January, 4)
+	return jan4.AddDate(0, 0, -((int(jan4.Weekday()) - int(startDay) + 7) % 7))
+}
+
+func weeksInYear(year int, startDay time.Weekday) int {
+	return int(firstWeekStart(year+1, startDay).Sub(firstWeekStart(year, startDay)).Hours()/24) / 7
+}
+
+// weekOf returns the week-numbering year and week of d for weeks starting on startDay
+func weekOf(d time.Time, startDay time.Weekday) (int, int) {
+	start := d.AddDate(0, 0, -((int(d.Weekday()) - int(startDay) + 7) % 7))
+	year := start.Year() + 1
+	for firstWeekStart(year, startDay).After(start) {
+		year--
+	}
+	return year, int(start.Sub(firstWeekStart(year, startDay)).Hours()/24)/7 + 1
+}
+
+func weekPeriod(typ Type, year, week int) (Period, bool) {
+	spec := weekSpecs[typ]
+	if week < 1 || week > weeksInYear(year, spec.startDay) {
+		return Period{}, false
+	}
+	start := firstWeekStart(year, spec.startDay).AddDate(0, 0, 7*(week-1))
+	return Period{
+		ID:    fmt.Sprintf("%d%s%d", year, spec.prefix, week),
+		Type:  typ,
+		Start: start,
+		End:   start.AddDate(0, 0, 6),
+	}, true
+}
+
+func biWeeklyPeriod(year, index int) (Period, bool) {
+	if index < 1 || index > (weeksInYear(year, time.Monday)+1)/2 {
+		return Period{}, false
+	}
+	start := firstWeekStart(year, time.Monday).AddDate(0, 0, 14*(index-1))
+	return Period{
+		ID:    fmt.Sprintf("%dBiW%d", year, index),
+		Type:  BiWeekly,
+		Start: start,
+		End:   start.AddDate(0, 0, 13),
+	}, true
+}
@@ -0,0 +1,171 @@
+package period
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		id    string
+		typ   Type
+		start string
+		end   string
+	}{
+		{"20240229", Daily, "2024-02-29", "2024-02-29"},
+		{"2024W1", Weekly, "2024-01-01", "2024-01-07"},
+		{"2020W53", Weekly, "2020-12-28", "2021-01-03"},
+		{"2024WedW1", WeeklyWednesday, "2024-01-03", "2024-01-09"},
+		{"2024ThuW1", WeeklyThursday, "2024-01-04", "2024-01-10"},
+		{"2024SatW1", WeeklySaturday, "2023-12-30", "2024-01-05"},
+		{"2024SunW1", WeeklySunday, "2023-12-31", "2024-01-06"},
+		{"2024BiW1", BiWeekly, "2024-01-01", "2024-01-14"},
+		{"202402", Monthly, "2024-02-01", "2024-02-29"},
+		{"202402B", BiMonthly, "2024-03-01", "2024-04-30"},
+		{"2024Q1", Quarterly, "2024-01-01", "2024-03-31"},
+		{"2025NovQ1", QuarterlyNov, "2024-11-01", "2025-01-31"},
+		{"2025NovQ4", QuarterlyNov, "2025-08-01", "2025-10-31"},
+		{"2024S2", SixMonthly, "2024-07-01", "2024-12-31"},
+		{"2024AprilS2", SixMonthlyApril, "2024-10-01", "2025-03-31"},
+		{"2025NovS1", SixMonthlyNov, "2024-11-01", "2025-04-30"},
+		{"2025NovS2", SixMonthlyNov, "2025-05-01", "2025-10-31"},
+		{"2024", Yearly, "2024-01-01", "2024-12-31"},
+		{"2024April", FinancialApril, "2024-04-01", "2025-03-31"},
+		{"2024July", FinancialJuly, "2024-07-01", "2025-06-30"},
+		{"2024Sep", FinancialSep, "2024-09-01", "2025-08-31"},
+		{"2024Oct", FinancialOct, "2024-10-01", "2025-09-30"},
+		{"2025Nov", FinancialNov, "2024-11-01", "2025-10-31"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			p, err := Parse(tt.id)
+			if err != nil {
+				t.Fatalf("Parse(%q) failed: %v", tt.id, err)
+			}
+			if p.ID != tt.id || p.Type != tt.typ {
+				t.Errorf("Parse(%q) = %s %s, want %s %s", tt.id, p.ID, p.Type, tt.id, tt.typ)
+			}
+			if !p.Start.Equal(date(tt.start)) || !p.End.Equal(date(tt.end)) {
+				t.Errorf("Parse(%q) runs %s to %s, want %s to %s", tt.id,
+					p.Start.Format("2006-01-02"), p.End.Format("2006-01-02"), tt.start, tt.end)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, id := range []string{
+		"", "2024-01", "20230229", "2021W53", "2024W0", "2024BiW28", "202413", "202407B",
+		"2024Q5", "2024NovQ0", "2024S3", "2024NovS3", "2024Dec", "24Q1",
+	} {
+		if p, err := Parse(id); !errors.Is(err, ErrInvalidPeriod) {
+			t.Errorf("Parse(%q) = %v, %v; want ErrInvalidPeriod", id, p, err)
+		}
+	}
+}
+
+func TestForDate(t *testing.T) {
+	tests := []struct {
+		date string
+		typ  Type
+		want string
+	}{
+		{"2024-02-29", Daily, "20240229"},
+		{"2024-01-10", Weekly, "2024W2"},
+		{"2024-12-30", Weekly, "2025W1"},
+		{"2021-01-03", Weekly, "2020W53"},
+		{"2024-01-02", WeeklyWednesday, "2023WedW52"},
+		{"2024-01-03", WeeklyWednesday, "2024WedW1"},
+		{"2024-01-04", WeeklyThursday, "2024ThuW1"},
+		{"2023-12-30", WeeklySaturday, "2024SatW1"},
+		{"2023-12-31", WeeklySunday, "2024SunW1"},
+		{"2024-01-15", BiWeekly, "2024BiW2"},
+		{"2024-02-15", Monthly, "202402"},
+		{"2024-04-30", BiMonthly, "202402B"},
+		{"2024-05-01", Quarterly, "2024Q2"},
+		{"2024-11-01", QuarterlyNov, "2025NovQ1"},
+		{"2025-01-31", QuarterlyNov, "2025NovQ1"},
+		{"2024-10-31", QuarterlyNov, "2024NovQ4"},
+		{"2024-07-01", SixMonthly, "2024S2"},
+		{"2025-03-31", SixMonthlyApril, "2024AprilS2"},
+		{"2024-11-15", SixMonthlyNov, "2025NovS1"},
+		{"2025-05-01", SixMonthlyNov, "2025NovS2"},
+		{"2024-12-31", Yearly, "2024"},
+		{"2025-03-31", FinancialApril, "2024April"},
+		{"2025-06-30", FinancialJuly, "2024July"},
+		{"2024-09-01", FinancialSep, "2024Sep"},
+		{"2024-09-30", FinancialOct, "2023Oct"},
+		{"2024-11-01", FinancialNov, "2025Nov"},
+		{"2024-10-31", FinancialNov, "2024Nov"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.typ)+"/"+tt.date, func(t *testing.T) {
+			p, err := ForDate(date(tt.date), tt.typ)
+			if err != nil {
+				t.Fatalf("ForDate(%s, %s) failed: %v", tt.date, tt.typ, err)
+			}
+			if p.ID != tt.want {
+				t.Errorf("ForDate(%s, %s) = %s, want %s", tt.date, tt.typ, p.ID, tt.want)
+			}
+			if !p.Contains(date(tt.date)) {
+				t.Errorf("%s runs %s to %s and does not contain %s", p.ID,
+					p.Start.Format("2006-01-02"), p.End.Format("2006-01-02"), tt.date)
+			}
+			parsed, err := Parse(p.ID)
+			if err != nil || !parsed.Start.Equal(p.Start) || !parsed.End.Equal(p.End) {
+				t.Errorf("Parse(%q) = %v, %v; want the period ForDate returned", p.ID, parsed, err)
+			}
+		})
+	}
+}
+
+func TestForDateUnsupported(t *testing.T) {
+	if _, err := ForDate(date("2024-01-01"), Type("Fortnightly")); err == nil {
+		t.Error("ForDate accepted an unknown period type")
+	}
+}
+
+func TestFromDate(t *testing.T) {
+	tests := []struct {
+		date string
+		typ  Type
+		want string
+	}{
+		{"2024-11-15", FinancialNov, "2025Nov"},
+		{"2024-11-15T10:00:00Z", Monthly, "202411"},
+		{"2024-11-15 10:00:00", Quarterly, "2024Q4"},
+		{"2024/11/15", Weekly, "2024W46"},
+		{"15/11/2024", Daily, "20241115"},
+	}
+	for _, tt := range tests {
+		p, err := FromDate(tt.date, tt.typ)
+		if err != nil || p.ID != tt.want {
+			t.Errorf("FromDate(%q, %s) = %v, %v; want %s", tt.date, tt.typ, p, err, tt.want)
+		}
+	}
+	if _, err := FromDate("Nov 2024", Monthly); err == nil {
+		t.Error("FromDate accepted an unrecognised date")
+	}
+}
+
+func TestCheckType(t *testing.T) {
+	p, _ := Parse("2025NovQ1")
+	if err := CheckType(p, "QuarterlyNov"); err != nil {
+		t.Errorf("CheckType(%s, QuarterlyNov) = %v", p, err)
+	}
+	if err := CheckType(p, "Quarterly"); err == nil {
+		t.Errorf("CheckType(%s, Quarterly) accepted a period of another type", p)
+	}
+	if err := CheckType(p, "Fortnightly"); err == nil {
+		t.Error("CheckType accepted an unknown period type")
+	}
+}
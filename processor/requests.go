@@ -0,0 +1,298 @@
+package processor
+
+import (
+	"context"
+	"crypto/tls"
+	"dhis2gw/config"
+	"dhis2gw/db"
+	"dhis2gw/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// requestTimeout caps how long sending one request may take
+const requestTimeout = 60 * time.Second
+
+// errInvalidQueryBody marks requests whose body can never be sent as query parameters
+var errInvalidQueryBody = errors.New("request body is not a valid query parameter object")
+
+var (
+	httpClient     *resty.Client
+	httpClientOnce sync.Once
+)
+
+// requestClient returns the client requests and url schedules are sent with. TLS
+// certificates are verified unless server.request_tls_insecure_skip_verify is set.
+func requestClient() *resty.Client {
+	httpClientOnce.Do(func() {
+		httpClient = resty.New().SetTimeout(requestTimeout)
+		if config.MustGet().Config.Server.RequestTLSInsecureSkipVerify {
+			httpClient.SetTLSClientConfig(&tls.Config{InsecureSkipVerify: true})
+		}
+	})
+	return httpClient
+}
+
+// StartRequestProcessor dispatches ready requests to their destination servers every
+// RequestProcessInterval seconds until ctx is cancelled.
+func StartRequestProcessor(ctx context.Context) {
+	cfg := config.MustGet().Config
+	interval := time.Duration(cfg.Server.RequestProcessInterval) * time.Second
+	if interval <= 0 {
+		interval = 4 * time.Second
+	}
+	log.WithField("Interval", interval).Info("Starting request processor")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Request processor stopped")
+			return
+		case <-ticker.C:
+			ProcessRequests(ctx, db.GetDB())
+		}
+	}
+}
+
+// ProcessRequests claims a batch of ready requests and sends each to its destination
+func ProcessRequests(ctx context.Context, dbConn *sqlx.DB) {
+	batchSize := config.MustGet().Config.Server.MaxConcurrent * 10
+	if batchSize <= 0 {
+		batchSize = 50
+	}
+	// A claimed batch is sent one request at a time, so its last request may wait for all
+	// the others to time out. Only requests inprogress for longer than that are abandoned.
+	staleAfter := time.Duration(batchSize)*requestTimeout + time.Minute
+	if n, err := models.ResetStaleRequests(dbConn, staleAfter); err != nil {
+		log.WithError(err).Error("Failed to reset stale requests")
+	} else if n > 0 {
+		log.WithField("Count", n).Warn("Reset stale inprogress requests")
+	}
+	requests, err := models.ClaimReadyRequests(dbConn, batchSize)
+	if err != nil {
+		log.WithError(err).Error("Failed to fetch ready requests")
+		return
+	}
+	for i := range requests {
+		if ctx.Err() != nil {
+			// hand back the claimed requests we did not get to
+			for _, req := range requests[i:] {
+				_ = req.UpdateStatus(dbConn, models.RequestStatusReady, req.StatusCode(), req.Response(), req.Errors())
+			}
+			return
+		}
+		processRequest(ctx, dbConn, &requests[i])
+	}
+
+	ProcessCopies(ctx, dbConn, batchSize)
+}
+
+// ProcessCopies delivers pending request copies to their CC servers. Each CC server is
+// attempted independently and keeps its own retry count in cc_servers_status, so the
+// primary destination is never held back by a failing copy target.
+func ProcessCopies(ctx context.Context, dbConn *sqlx.DB, limit int) {
+	requests, err := models.GetRequestsWithPendingCopies(dbConn, limit)
+	if err != nil {
+		log.WithError(err).Error("Failed to fetch requests with pending copies")
+		return
+	}
+	for i := range requests {
+		for _, serverID := range requests[i].CCServers() {
+			if ctx.Err() != nil {
+				return
+			}
+			processCopy(ctx, dbConn, &requests[i], serverID)
+		}
+	}
+}
+
+func processCopy(ctx context.Context, dbConn *sqlx.DB, req *models.Request, serverID int64) {
+	logger := log.WithFields(log.Fields{"RequestUID": req.UID(), "CCServer": serverID})
+	st, claimed, err := req.ClaimCCServer(dbConn, serverID)
+	if err != nil {
+		logger.WithError(err).Error("Failed to claim request copy")
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	server, ok := models.GetServerFromCacheByID(serverID)
+	if !ok {
+		server = models.GetServerByID(serverID)
+	}
+	if server.ID() == 0 {
+		st.Status, st.Errors = string(models.RequestStatusFailed), "cc server not found"
+		_ = req.UpdateCCServerStatus(dbConn, serverID, st)
+		return
+	}
+	if dup, ok := alreadyDelivered(dbConn, req, server); ok {
+		st.Status, st.Errors = string(models.RequestStatusCanceled), "already delivered by request "+dup
+		_ = req.UpdateCCServerStatus(dbConn, serverID, st)
+		logger.WithField("Duplicate", dup).Info("Skipped request copy already delivered")
+		return
+	}
+
+	resp, err := SendRequest(ctx, server, req)
+	status, errMsg := classifyResponse(resp, err)
+	st.StatusCode, st.Response = "", ""
+	if resp != nil {
+		st.StatusCode = fmt.Sprintf("%d", resp.StatusCode())
+		st.Response = resp.String()
+	}
+	if status == models.RequestStatusCompleted && server.UseAsync() && resp != nil {
+		st.AsyncJobID = registerAsyncJob(dbConn, req, server, true, resp.Body())
+	}
+	if status == models.RequestStatusReady {
+		st.Retries++
+		if st.Retries >= maxRetries() {
+			status = models.RequestStatusExpired
+		}
+	}
+	st.Status, st.Errors = string(status), errMsg
+
+	if err := req.UpdateCCServerStatus(dbConn, serverID, st); err != nil {
+		logger.WithError(err).Error("Failed to update request copy status")
+		return
+	}
+	logger.WithFields(log.Fields{
+		"Status": st.Status, "StatusCode": st.StatusCode, "Retries": st.Retries}).Info("Processed request copy")
+}
+
+func processRequest(ctx context.Context, dbConn *sqlx.DB, req *models.Request) {
+	logger := log.WithFields(log.Fields{"RequestUID": req.UID(), "Destination": req.Destination()})
+	server, ok := models.GetServerFromCacheByID(int64(req.Destination()))
+	if !ok {
+		server = models.GetServerByID(int64(req.Destination()))
+	}
+	if server.ID() == 0 {
+		logger.Error("Destination server not found")
+		_ = req.UpdateStatus(dbConn, models.RequestStatusFailed, "", "", "destination server not found")
+		return
+	}
+	if dup, ok := alreadyDelivered(dbConn, req, server); ok {
+		_ = req.UpdateStatus(dbConn, models.RequestStatusCanceled, "", "", "already delivered by request "+dup)
+		logger.WithField("Duplicate", dup).Info("Skipped request already delivered")
+		return
+	}
+
+	resp, err := SendRequest(ctx, server, req)
+	status, errMsg := classifyResponse(resp, err)
+	statusCode, body := "", ""
+	if resp != nil {
+		statusCode = fmt.Sprintf("%d", resp.StatusCode())
+		body = resp.String()
+	}
+
+	if status == models.RequestStatusCompleted && server.UseAsync() && resp != nil {
+		registerAsyncJob(dbConn, req, server, false, resp.Body())
+	}
+	if status == models.RequestStatusReady {
+		if err := req.IncrementRetries(dbConn); err != nil {
+			logger.WithError(err).Error("Failed to increment request retries")
+		}
+		if req.Retries() >= maxRetries() {
+			status = models.RequestStatusExpired
+		}
+	}
+	if err := req.UpdateStatus(dbConn, status, statusCode, body, errMsg); err != nil {
+		logger.WithError(err).Error("Failed to update request status")
+		return
+	}
+	logger.WithFields(log.Fields{
+		"Status": status, "StatusCode": statusCode, "Retries": req.Retries()}).Info("Processed request")
+}
+
+// alreadyDelivered reports whether another request with the same submission ID was delivered
+// to server. Servers that allow copies always receive the submission again.
+func alreadyDelivered(dbConn *sqlx.DB, req *models.Request, server models.Server) (string, bool) {
+	if server.AllowCopies() {
+		return "", false
+	}
+	dup, found, err := req.DeliveredDuplicate(dbConn, int64(server.ID()))
+	if err != nil {
+		log.WithError(err).WithField("RequestUID", req.UID()).Warn("Failed to check for delivered duplicates")
+		return "", false
+	}
+	return dup, found
+}
+
+// classifyResponse maps a send outcome to the next request status. Transport errors,
+// timeouts, throttling and server errors leave the request ready for another attempt.
+func classifyResponse(resp *resty.Response, err error) (models.RequestStatus, string) {
+	if errors.Is(err, errInvalidQueryBody) {
+		return models.RequestStatusFailed, err.Error()
+	}
+	if err != nil {
+		return models.RequestStatusReady, err.Error()
+	}
+	code := resp.StatusCode()
+	switch {
+	case code >= 200 && code < 300:
+		return models.RequestStatusCompleted, ""
+	case code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500:
+		return models.RequestStatusReady, resp.Status()
+	default:
+		return models.RequestStatusFailed, resp.Status()
+	}
+}
+
+func maxRetries() int {
+	if n := config.MustGet().Config.Server.MaxRetries; n > 0 {
+		return n
+	}
+	return 3
+}
+
+// SendRequest sends the request body to server using the server's HTTP method,
+// authentication method and URL parameters.
+func SendRequest(ctx context.Context, server models.Server, req *models.Request) (*resty.Response, error) {
+	r := requestClient().R().SetContext(ctx)
+
+	params := map[string]string{}
+	for k, v := range server.URLParams() {
+		params[k] = fmt.Sprintf("%v", v)
+	}
+	if req.BodyIsQueryParams() {
+		var bodyParams map[string]any
+		if err := json.Unmarshal([]byte(req.Body()), &bodyParams); err != nil {
+			return nil, fmt.Errorf("%w: %v", errInvalidQueryBody, err)
+		}
+		for k, v := range bodyParams {
+			params[k] = fmt.Sprintf("%v", v)
+		}
+	}
+	r.SetQueryParams(params)
+
+	switch server.AuthMethod() {
+	case "Basic":
+		r.SetBasicAuth(server.Username(), server.Password())
+	case "Token":
+		r.SetHeader("Authorization", "ApiToken "+server.AuthToken())
+	}
+
+	method := strings.ToUpper(server.HTTPMethod())
+	if method == "" {
+		method = http.MethodPost
+	}
+	if !req.BodyIsQueryParams() && method != http.MethodGet {
+		contentType := req.ContentType()
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		r.SetHeader("Content-Type", contentType).SetBody(req.Body())
+	}
+
+	return r.Execute(method, server.URL()+req.URLSurffix())
+}
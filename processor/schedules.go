@@ -0,0 +1,263 @@
+package processor
+
+import (
+	"context"
+	"dhis2gw/db"
+	"dhis2gw/models"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	schedulePollInterval = 10 * time.Second
+	scheduleBatchSize    = 50
+	// commandTimeout caps how long a scheduled command may run
+	commandTimeout = 30 * time.Minute
+	// asyncJobCheckTimeout is how long we wait for DHIS2 to report on an async job before giving up
+	asyncJobCheckTimeout = 24 * time.Hour
+	// scheduleLease keeps a claimed schedule from being run again while its handler runs
+	scheduleLease = commandTimeout + time.Minute
+)
+
+// Schedule statuses as allowed by the schedules table
+const (
+	ScheduleStatusReady     = "ready"
+	ScheduleStatusCompleted = "completed"
+	ScheduleStatusFailed    = "failed"
+	ScheduleStatusExpired   = "expired"
+	ScheduleStatusSkipped   = "skipped"
+)
+
+// scheduleHandler runs a due schedule and returns the status to record for the run.
+// Handlers that return done=true deactivate the schedule regardless of its repeat setting.
+// They run outside any transaction and must keep their own writes short.
+type scheduleHandler func(ctx context.Context, dbConn *sqlx.DB, s *models.Schedule) (status string, done bool, err error)
+
+var scheduleHandlers = map[string]scheduleHandler{
+	"url":                   runURLSchedule,
+	"command":               runCommandSchedule,
+	"dhis2_async_job_check": runAsyncJobCheckSchedule,
+}
+
+// StartScheduleProcessor runs due schedules until ctx is cancelled
+func StartScheduleProcessor(ctx context.Context) {
+	log.WithField("Interval", schedulePollInterval).Info("Starting schedule processor")
+	ticker := time.NewTicker(schedulePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Schedule processor stopped")
+			return
+		case <-ticker.C:
+			ProcessSchedules(ctx, db.GetDB())
+		}
+	}
+}
+
+// ProcessSchedules runs every schedule that is currently due
+func ProcessSchedules(ctx context.Context, dbConn *sqlx.DB) {
+	ids, err := models.GetDueScheduleIDs(dbConn, scheduleBatchSize)
+	if err != nil {
+		log.WithError(err).Error("Failed to fetch due schedules")
+		return
+	}
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := processSchedule(ctx, dbConn, id); err != nil {
+			log.WithError(err).WithField("ScheduleID", id).Error("Failed to process schedule")
+		}
+	}
+}
+
+// processSchedule claims a due schedule, runs its handler and records the run. The claim and
+// the record are separate short transactions, so that the schedule row is not locked while
+// the handler runs.
+func processSchedule(ctx context.Context, dbConn *sqlx.DB, id int64) error {
+	schedule, ok, err := models.ClaimSchedule(dbConn, id, time.Now().Add(scheduleLease))
+	if err != nil || !ok {
+		return err
+	}
+	logger := log.WithFields(log.Fields{"ScheduleID": schedule.ID, "Type": schedule.ScheduleType})
+
+	status, done := ScheduleStatusSkipped, false
+	if handler, found := scheduleHandlers[schedule.ScheduleType]; found {
+		var runErr error
+		status, done, runErr = handler(ctx, dbConn, &schedule)
+		if runErr != nil {
+			logger.WithError(runErr).Warn("Schedule run failed")
+		}
+	} else {
+		logger.Warn("No handler for schedule type")
+	}
+
+	nextRun, repeats, err := schedule.NextRun(time.Now())
+	if err != nil {
+		logger.WithError(err).Error("Could not compute next run")
+		repeats = false
+	}
+
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if !repeats || done {
+		nextRun = schedule.NextRunAt
+		if err := schedule.Deactivate(tx); err != nil {
+			return err
+		}
+	}
+	if err := schedule.UpdateRunDetails(tx, status, nextRun); err != nil {
+		return err
+	}
+	logger.WithFields(log.Fields{"Status": status, "NextRun": nextRun, "Active": schedule.IsActive}).Info(
+		"Schedule run recorded")
+	return tx.Commit()
+}
+
+// urlScheduleParams are the optional params of a url schedule
+type urlScheduleParams struct {
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers"`
+}
+
+func runURLSchedule(ctx context.Context, _ *sqlx.DB, s *models.Schedule) (string, bool, error) {
+	var params urlScheduleParams
+	if len(s.Params) > 0 {
+		if err := json.Unmarshal(s.Params, &params); err != nil {
+			return ScheduleStatusFailed, false, fmt.Errorf("invalid url schedule params: %w", err)
+		}
+	}
+	method := strings.ToUpper(params.Method)
+	if method == "" {
+		method = http.MethodGet
+		if s.ScheduleContent != "" {
+			method = http.MethodPost
+		}
+	}
+
+	r := requestClient().R().SetContext(ctx).SetHeaders(params.Headers)
+	if s.ServerID != nil && *s.ServerID > 0 {
+		if server, ok := models.GetServerFromCacheByID(int64(*s.ServerID)); ok {
+			switch server.AuthMethod() {
+			case "Basic":
+				r.SetBasicAuth(server.Username(), server.Password())
+			case "Token":
+				r.SetHeader("Authorization", "ApiToken "+server.AuthToken())
+			}
+		}
+	}
+	if s.ScheduleContent != "" {
+		r.SetBody(s.ScheduleContent)
+		if _, ok := params.Headers["Content-Type"]; !ok {
+			r.SetHeader("Content-Type", "application/json")
+		}
+	}
+	resp, err := r.Execute(method, s.ScheduleURL)
+	if err != nil {
+		return ScheduleStatusFailed, false, err
+	}
+	if resp.IsError() {
+		return ScheduleStatusFailed, false, fmt.Errorf("%s returned %s", s.ScheduleURL, resp.Status())
+	}
+	return ScheduleStatusCompleted, false, nil
+}
+
+func runCommandSchedule(ctx context.Context, _ *sqlx.DB, s *models.Schedule) (string, bool, error) {
+	if s.Command == "" {
+		return ScheduleStatusFailed, false, fmt.Errorf("schedule has no command")
+	}
+	cmdCtx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(cmdCtx, s.Command, strings.Fields(s.CommandArgs)...).CombinedOutput()
+	log.WithFields(log.Fields{"ScheduleID": s.ID, "Command": s.Command, "Output": string(out)}).Debug(
+		"Scheduled command output")
+	if err != nil {
+		return ScheduleStatusFailed, false, err
+	}
+	return ScheduleStatusCompleted, false, nil
+}
+
+// runAsyncJobCheckSchedule polls DHIS2 for an async import job and, once the job has
+// completed, stores its import summary on the request that started it.
+func runAsyncJobCheckSchedule(_ context.Context, dbConn *sqlx.DB, s *models.Schedule) (string, bool, error) {
+	if s.ServerID == nil || s.RequestID == nil {
+		return ScheduleStatusFailed, true, fmt.Errorf("async job check schedule has no request or server")
+	}
+	completed, hasStatus, err := models.CheckDhis2AsyncJobStatus(*s)
+	if !completed {
+		if !hasStatus && time.Since(s.Created) > asyncJobCheckTimeout {
+			return ScheduleStatusExpired, true, fmt.Errorf("no status reported for job %s", s.AsyncJobID)
+		}
+		return ScheduleStatusReady, false, err
+	}
+
+	summary, err := models.CheckDhis2AsyncJobTaskSummary(*s)
+	if err != nil {
+		return ScheduleStatusReady, false, err
+	}
+	status, response := "", ""
+	if summary != nil {
+		status = summary.Status
+		if raw, err := json.Marshal(summary); err == nil {
+			response = string(raw)
+		}
+	}
+	inCC := s.ServerInCC != nil && *s.ServerInCC
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		return ScheduleStatusFailed, false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if err := models.UpdateRequestAsyncResult(tx, *s.RequestID, *s.ServerID, inCC, status, response); err != nil {
+		return ScheduleStatusFailed, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return ScheduleStatusFailed, false, err
+	}
+	return ScheduleStatusCompleted, true, nil
+}
+
+// registerAsyncJob creates a dhis2_async_job_check schedule when a server that imports
+// asynchronously accepted the request. It returns the job id, or "" when no job was started.
+func registerAsyncJob(dbConn *sqlx.DB, req *models.Request, server models.Server, inCC bool, body []byte) string {
+	var summary models.ImportJobSummary
+	if err := json.Unmarshal(body, &summary); err != nil || summary.Response.ID == "" {
+		return ""
+	}
+	jobID := summary.Response.ID
+	logger := log.WithFields(log.Fields{"RequestUID": req.UID(), "Server": server.Name(), "JobID": jobID})
+
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		logger.WithError(err).Error("Failed to start async job registration")
+		return ""
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := models.CreateAsyncJobSchedule(
+		tx, req.ID(), server.ID(), inCC, summary.Response.JobType, jobID); err != nil {
+		logger.WithError(err).Error("Failed to schedule async job check")
+		return ""
+	}
+	if !inCC {
+		if err := req.SetAsyncJob(tx, jobID); err != nil {
+			logger.WithError(err).Error("Failed to record async job on request")
+			return ""
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		logger.WithError(err).Error("Failed to commit async job registration")
+		return ""
+	}
+	return jobID
+}
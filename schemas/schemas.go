@@ -0,0 +1,9 @@
+// Package schemas holds the JSON schemas that API submissions are validated against.
+package schemas
+
+import _ "embed"
+
+// Tracker is the schema of tracker submissions
+//
+//go:embed tracker.json
+var Tracker string
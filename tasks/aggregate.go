@@ -5,9 +5,15 @@ import (
 	"dhis2gw/config"
 	"dhis2gw/db"
 	"dhis2gw/joblog"
+	"dhis2gw/metrics"
 	"dhis2gw/models"
+	"fmt"
+	"strings"
 
 	sdk "github.com/HISP-Uganda/go-dhis2-sdk"
+	"github.com/HISP-Uganda/go-dhis2-sdk/aggregate"
+	"github.com/HISP-Uganda/go-dhis2-sdk/dhis2/schema"
+	"github.com/go-resty/resty/v2"
 	"github.com/goccy/go-json"
 	"github.com/hibiken/asynq"
 	log "github.com/sirupsen/logrus"
@@ -17,10 +23,15 @@ const (
 	TypeAggregate = "aggregate:send"
 )
 
+// aggregateImportJobType is the DHIS2 job type of async dataValueSets imports
+const aggregateImportJobType = "DATAVALUE_IMPORT"
+
 var dhis2Client *sdk.Client
 
 // SetClient should be called from main.go after initializing the client.
 func SetClient(client *sdk.Client) {
+	metrics.InstrumentResty(client.Resty, models.DefaultInstanceName)
+	captureResponses(client.Resty)
 	dhis2Client = client
 }
 
@@ -35,7 +46,7 @@ func NewAggregateTask(aggRequest AggregateTaskPayload) (*asynq.Task, error) {
 	if err != nil {
 		return nil, err
 	}
-	return asynq.NewTask(TypeAggregate, payload, asynq.MaxRetry(3)), nil
+	return asynq.NewTask(TypeAggregate, payload, asynq.MaxRetry(maxRetries())), nil
 }
 
 func HandleAggregateTask(ctx context.Context, task *asynq.Task) error {
@@ -61,11 +72,22 @@ func (p *AggregateTaskPayload) Process(ctx context.Context) error {
 		return err
 	}
 
-	if jl.RetryCount > 0 {
-		if err := jl.IncrementRetry(); err != nil {
-			log.Printf("Failed to increment retry count: %v", err)
-		}
-	} else {
+	client, err := ClientForInstance(p.Payload.InstanceName())
+	if err != nil {
+		log.WithError(err).Error("No DHIS2 client for aggregate request")
+		return instanceClientError(jl, err)
+	}
+
+	ref, err := newImportRef(TypeAggregate, p.Payload.InstanceName(), p)
+	if err != nil {
+		return err
+	}
+	// An earlier attempt already handed the import to DHIS2, so only check for its outcome
+	if jl.AsyncJobID.Valid && jl.AsyncJobID.String != "" {
+		return enqueueImportCheck(ctx, jl, ref, jl.AsyncJobID.String)
+	}
+
+	if isFirstAttempt(ctx) {
 		dhis2Payload, marshalErr := json.Marshal(payload)
 		if marshalErr != nil {
 			log.Printf("Failed to marshal DHIS2 payload: %v", marshalErr)
@@ -76,34 +98,215 @@ func (p *AggregateTaskPayload) Process(ctx context.Context) error {
 		}
 	}
 
-	resp, err := dhis2Client.SendAggregateDataValues(ctx, &payload)
-	status := "success"
-	dhis2Resp := ""
-	errors := ""
+	if err := payload.Validate(); err != nil {
+		return finishAttempt(ctx, jl, permanentError("invalid payload: "+err.Error()), "failed", err.Error())
+	}
+	if p.Payload.UseAsync() {
+		return postAsyncAggregate(ctx, client, jl, &payload, ref)
+	}
 
-	if err != nil {
-		log.Error("Error sending aggregate data values to DHIS2: ", err)
-		status = "failed"
-		errors = err.Error()
-	} else {
-		log.Info("Successfully sent aggregate data values to DHIS2")
-		status = resp.Status
-		rp, err := json.Marshal(resp)
-		if err != nil {
-			log.Error("Error marshalling DHIS2 response: ", err)
+	res, resp, err := sendDataValueSets(ctx, client, &payload)
+	return finishSyncImport(ctx, jl, err, res, resp)
+}
+
+// responseKey holds, in a request context, where captureResponses stores the response
+type responseKey struct{}
+
+// captureResponses lets callers of SDK methods that only return the decoded body see the
+// HTTP response, by passing a context made with withResponseCapture
+func captureResponses(client *resty.Client) {
+	client.OnAfterResponse(func(_ *resty.Client, res *resty.Response) error {
+		if held, ok := res.Request.Context().Value(responseKey{}).(**resty.Response); ok {
+			*held = res
+		}
+		return nil
+	})
+}
+
+func withResponseCapture(ctx context.Context, res **resty.Response) context.Context {
+	return context.WithValue(ctx, responseKey{}, res)
+}
+
+// sendDataValueSets imports a data value set through the SDK client. The SDK neither returns
+// the HTTP response nor decodes error bodies, so the response is captured to classify the
+// attempt and the summary of a rejected import, which DHIS2 answers with 409, read from it.
+func sendDataValueSets(ctx context.Context, client *sdk.Client, payload *aggregate.DataValueSetPayload) (
+	*resty.Response, *aggregate.AggregateSummaryResponse, error) {
+	var (
+		res  *resty.Response
+		resp aggregate.AggregateSummaryResponse
+	)
+	summary, err := client.SendAggregateDataValues(withResponseCapture(ctx, &res), payload)
+	if res == nil {
+		return nil, &resp, err
+	}
+	if summary != nil {
+		resp.Response = *summary
+	}
+	if res.IsError() {
+		_ = json.Unmarshal(res.Body(), &resp)
+	}
+	return res, &resp, nil
+}
+
+// postDataValueSets posts a synchronous import of a combined data value set, which the SDK
+// client cannot send since it requires every payload to name one org unit and period. The
+// import summary is read from error responses too, as sendDataValueSets does.
+func postDataValueSets(ctx context.Context, client *sdk.Client, payload interface{}) (
+	*resty.Response, *aggregate.AggregateSummaryResponse, error) {
+	var resp aggregate.AggregateSummaryResponse
+	res, err := client.Resty.R().
+		SetContext(ctx).
+		SetBody(payload).
+		SetResult(&resp).
+		SetError(&resp).
+		Post("/dataValueSets")
+	if err == nil && res.IsSuccess() {
+		resp.Response.LogSummary()
+	}
+	return res, &resp, err
+}
+
+// finishSyncImport classifies the response to a synchronous dataValueSets import and stores
+// its outcome on the log. Conflicts of partial or rejected imports become the log's errors.
+func finishSyncImport(ctx context.Context, jl *joblog.JobLog, err error, res *resty.Response,
+	resp *aggregate.AggregateSummaryResponse) error {
+	summary := &resp.Response
+	o := classifyImportResponse(err, res, summary.Status, len(summary.Conflicts))
+
+	status, errors := summary.Status, ""
+	switch o.class {
+	case joblog.AttemptSucceeded:
+		if status == "" {
+			status = "success"
+		}
+	case joblog.AttemptPartial, joblog.AttemptPermanent:
+		if errors = importConflicts(summary.Conflicts); errors == "" {
+			errors = o.reason
+		}
+		if status == "" {
 			status = "failed"
-			errors = err.Error()
-		} else {
-			dhis2Resp = string(rp)
 		}
+	case joblog.AttemptRetryable:
+		status, errors = "failed", o.reason
+	}
+	if summary.Status != "" {
+		ic := summary.ImportCount
+		metrics.ImportCounts("aggregate", int(ic.Imported), int(ic.Updated), int(ic.Ignored), int(ic.Deleted))
+	}
+
+	if config.MustGet().Config.API.SaveResponse == "true" && err == nil {
+		_ = jl.UpdateResponse(res.String())
+	}
+	log.WithFields(log.Fields{"LogID": jl.ID, "ImportResponse": summary}).Info("Aggregate Import Response")
+	return finishAttempt(ctx, jl, o, status, errors)
+}
+
+// importConflicts lists the conflicts of an import summary
+func importConflicts(conflicts []schema.ImportConflict) string {
+	var messages []string
+	for _, c := range conflicts {
+		var object, value string
+		if c.Object != nil {
+			object = *c.Object
+		}
+		if c.Value != nil {
+			value = *c.Value
+		}
+		messages = append(messages, fmt.Sprintf("%s: %s", object, value))
 	}
+	return strings.Join(messages, "; ")
+}
 
-	_ = jl.UpdateStatusAndErrors(status, errors)
+// postAsyncAggregate posts any dataValueSets body with async=true, records the job DHIS2
+// started on the log and leaves its outcome to an aggregate:import_check task, so that no
+// worker is held while DHIS2 imports.
+func postAsyncAggregate(ctx context.Context, client *sdk.Client, jl *joblog.JobLog, payload interface{}, ref importRef) error {
+	res, err := client.Resty.R().
+		SetContext(ctx).
+		SetBody(payload).
+		SetQueryParam("async", "true").
+		Post("/dataValueSets")
+	if err != nil {
+		log.Error("Error sending async aggregate data values to DHIS2: ", err)
+		return finishAttempt(ctx, jl, retryableError(err), "failed", err.Error())
+	}
 
-	if config.MustGet().Config.API.SaveResponse == "true" && dhis2Resp != "" {
-		_ = jl.UpdateResponse(dhis2Resp)
+	var summary models.ImportJobSummary
+	if err := json.Unmarshal(res.Body(), &summary); err != nil || res.IsError() || summary.Response.ID == "" {
+		o := permanentError("DHIS2 did not start an import job")
+		if res.IsError() {
+			o = classifyHTTPStatus(res)
+		}
+		return finishAttempt(ctx, jl, o, "failed",
+			fmt.Sprintf("DHIS2 did not start an import job: %s %s", res.Status(), res.String()))
+	}
+	if err := jl.UpdateAsyncJob(summary.Response.ID); err != nil {
+		log.Printf("Failed to record async job on submission log: %v", err)
+		return err
 	}
+	log.WithFields(log.Fields{"LogID": jl.ID, "JobID": summary.Response.ID}).Info("DHIS2 accepted async aggregate import")
+	return enqueueImportCheck(ctx, jl, ref, summary.Response.ID)
+}
 
-	log.WithFields(log.Fields{"ImportResponse": resp}).Info("Aggregate Import Response")
-	return nil
+// finishAggregateImport stores the summary of a completed async import on the log and
+// returns how the import went. A job that ends in an ERROR without conflicts is forgotten,
+// so that the import can be sent again.
+func finishAggregateImport(jl *joblog.JobLog, jobID string, summary *models.AsyncJobImportSummary) (outcome, string) {
+	var conflicts []string
+	for _, c := range summary.ImportConflicts {
+		conflicts = append(conflicts, fmt.Sprintf("%s: %s", c.Object, c.Value))
+	}
+	errors := strings.Join(conflicts, "; ")
+	o := classifyImportStatus(summary.Status, len(summary.ImportConflicts))
+	if o.class == joblog.AttemptRetryable {
+		_ = jl.UpdateAsyncJob("")
+		errors = o.reason
+	}
+
+	if config.MustGet().Config.API.SaveResponse == "true" {
+		if rp, err := json.Marshal(summary); err == nil {
+			_ = jl.UpdateResponse(string(rp))
+		}
+	}
+	log.WithFields(log.Fields{"LogID": jl.ID, "JobID": jobID, "Status": summary.Status}).Info(
+		"Async aggregate import completed")
+	ic := summary.ImportCount
+	metrics.ImportCounts("aggregate", ic.Imported, ic.Updated, ic.Ignored, ic.Deleted)
+	return o, errors
+}
+
+func aggregateImportCompleted(ctx context.Context, client *sdk.Client, jobID string) (bool, error) {
+	var statuses []models.AsyncJobStatus
+	res, err := client.Resty.R().
+		SetContext(ctx).
+		SetResult(&statuses).
+		Get(fmt.Sprintf("/system/tasks/%s/%s", aggregateImportJobType, jobID))
+	if err != nil {
+		return false, err
+	}
+	if res.IsError() {
+		return false, fmt.Errorf("system/tasks returned %s", res.Status())
+	}
+	for _, st := range statuses {
+		if st.Completed {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func aggregateImportSummary(ctx context.Context, client *sdk.Client, jobID string) (*models.AsyncJobImportSummary, error) {
+	var summary models.AsyncJobImportSummary
+	res, err := client.Resty.R().
+		SetContext(ctx).
+		SetResult(&summary).
+		Get(fmt.Sprintf("/system/taskSummaries/%s/%s", aggregateImportJobType, jobID))
+	if err != nil {
+		return nil, err
+	}
+	if res.IsError() {
+		return nil, fmt.Errorf("system/taskSummaries returned %s", res.Status())
+	}
+	return &summary, nil
 }
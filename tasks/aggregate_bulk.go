@@ -0,0 +1,124 @@
+package tasks
+
+import (
+	"context"
+	"dhis2gw/db"
+	"dhis2gw/joblog"
+	"dhis2gw/models"
+
+	sdk "github.com/HISP-Uganda/go-dhis2-sdk"
+	"github.com/goccy/go-json"
+	"github.com/hibiken/asynq"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	TypeAggregateBulk = "aggregate:bulk"
+)
+
+// BulkAggregateTaskPayload is one group of a bulk request, imported into DHIS2 as a single
+// combined dataValueSets payload. All blocks target the same instance.
+type BulkAggregateTaskPayload struct {
+	LogID   int64                     `json:"log_id"`
+	BatchID int64                     `json:"batch_id"`
+	Blocks  []models.AggregateRequest `json:"blocks"`
+}
+
+func NewBulkAggregateTask(p BulkAggregateTaskPayload) (*asynq.Task, error) {
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return asynq.NewTask(TypeAggregateBulk, payload, asynq.MaxRetry(maxRetries())), nil
+}
+
+func HandleBulkAggregateTask(ctx context.Context, task *asynq.Task) error {
+	var p BulkAggregateTaskPayload
+	if err := json.Unmarshal(task.Payload(), &p); err != nil {
+		return err
+	}
+	return p.Process(ctx)
+}
+
+func (p *BulkAggregateTaskPayload) Process(ctx context.Context) error {
+	jl, err := joblog.Load(db.GetDB(), p.LogID)
+	if err != nil {
+		log.Printf("Failed to load job log: %v", err)
+		return err
+	}
+	if len(p.Blocks) == 0 {
+		_ = jl.UpdateStatusAndErrors("failed", "bulk group has no blocks")
+		return nil
+	}
+	first := &p.Blocks[0]
+
+	client, err := ClientForInstance(first.InstanceName())
+	if err != nil {
+		log.WithError(err).Error("No DHIS2 client for bulk aggregate request")
+		return instanceClientError(jl, err)
+	}
+
+	payload, err := models.CombineAggregateRequests(p.Blocks)
+	if err != nil {
+		log.WithError(err).Error("Failed to convert bulk aggregate blocks")
+		return err
+	}
+	ref, err := newImportRef(TypeAggregateBulk, first.InstanceName(), p)
+	if err != nil {
+		return err
+	}
+	// an async import completes the data sets from its import check, once DHIS2 has imported it
+	ref.Registrations = &payload.Registrations
+	if jl.AsyncJobID.Valid && jl.AsyncJobID.String != "" {
+		return enqueueImportCheck(ctx, jl, ref, jl.AsyncJobID.String)
+	}
+	if len(payload.DataValues) == 0 {
+		_ = jl.UpdateStatusAndErrors("failed", "no data values left after conversion")
+		return nil
+	}
+
+	if isFirstAttempt(ctx) {
+		if dhis2Payload, err := json.Marshal(payload); err == nil {
+			if err := jl.UpdateDhis2Payload(string(dhis2Payload)); err != nil {
+				log.Printf("Failed to update submission log with DHIS2 payload: %v", err)
+			}
+		}
+	}
+
+	if first.UseAsync() {
+		return postAsyncAggregate(ctx, client, jl, payload, ref)
+	}
+
+	log.WithFields(log.Fields{"LogID": jl.ID, "BatchID": p.BatchID, "Blocks": len(p.Blocks)}).Info("Sending bulk aggregate import")
+	res, resp, err := postDataValueSets(ctx, client, payload)
+	if valuesTaken(classifyImportResponse(err, res, resp.Response.Status, len(resp.Response.Conflicts))) {
+		completeDataSets(ctx, client, jl, payload.Registrations)
+	}
+	return finishSyncImport(ctx, jl, err, res, resp)
+}
+
+// valuesTaken reports whether DHIS2 imported the values of an attempt, if only some of them
+func valuesTaken(o outcome) bool {
+	return o.class == joblog.AttemptSucceeded || o.class == joblog.AttemptPartial
+}
+
+// completeDataSets registers the data set of each block complete once DHIS2 has taken the
+// values. A failed registration leaves the imported values in place, so it is only logged.
+func completeDataSets(ctx context.Context, client *sdk.Client, jl *joblog.JobLog,
+	regs models.CompleteDataSetRegistrations) {
+	if len(regs.CompleteDataSetRegistrations) == 0 {
+		return
+	}
+	logger := log.WithFields(log.Fields{"LogID": jl.ID, "Registrations": len(regs.CompleteDataSetRegistrations)})
+	res, err := client.Resty.R().
+		SetContext(ctx).
+		SetBody(regs).
+		Post("/completeDataSetRegistrations")
+	if err != nil {
+		logger.WithError(err).Warn("Failed to register bulk data sets complete")
+		return
+	}
+	if res.IsError() {
+		logger.WithField("Response", res.String()).Warnf("DHIS2 refused data set completion: %s", res.Status())
+	}
+}
@@ -0,0 +1,174 @@
+package tasks
+
+import (
+	"context"
+	"dhis2gw/config"
+	"dhis2gw/db"
+	"dhis2gw/joblog"
+	"dhis2gw/models"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/hibiken/asynq"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	TypeAggregateImportCheck = "aggregate:import_check"
+)
+
+// importCheckTimeout is how long we wait for DHIS2 to complete an async import before giving up
+const importCheckTimeout = 24 * time.Hour
+
+var taskClient *asynq.Client
+
+// SetTaskClient should be called by every process that runs workers, so that handlers can
+// enqueue follow-up tasks.
+func SetTaskClient(client *asynq.Client) {
+	taskClient = client
+}
+
+// importRef is the task that started an async import, so that it can be enqueued again when
+// DHIS2 fails the job in a way that a new import can get past
+type importRef struct {
+	Instance string          `json:"instance"`
+	Type     string          `json:"type"`
+	Payload  json.RawMessage `json:"payload"`
+	// Registrations are the data sets to complete once the import succeeds, for bulk imports
+	Registrations *models.CompleteDataSetRegistrations `json:"registrations,omitempty"`
+}
+
+func newImportRef(taskType, instance string, payload interface{}) (importRef, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return importRef{}, err
+	}
+	return importRef{Instance: instance, Type: taskType, Payload: raw}, nil
+}
+
+// AggregateImportCheckPayload follows up on an async dataValueSets import. The check runs
+// every dhis2_job_status_check_interval seconds until DHIS2 reports the job as completed.
+type AggregateImportCheckPayload struct {
+	LogID   int64     `json:"log_id"`
+	JobID   string    `json:"job_id"`
+	Started time.Time `json:"started"`
+	Import  importRef `json:"import"`
+}
+
+func NewAggregateImportCheckTask(p AggregateImportCheckPayload) (*asynq.Task, error) {
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return asynq.NewTask(TypeAggregateImportCheck, payload, asynq.MaxRetry(maxRetries())), nil
+}
+
+func HandleAggregateImportCheckTask(ctx context.Context, task *asynq.Task) error {
+	var p AggregateImportCheckPayload
+	if err := json.Unmarshal(task.Payload(), &p); err != nil {
+		return fmt.Errorf("%v: %w", err, asynq.SkipRetry)
+	}
+	return p.Process(ctx)
+}
+
+func (p *AggregateImportCheckPayload) Process(ctx context.Context) error {
+	jl, err := joblog.Load(db.GetDB(), p.LogID)
+	if err != nil {
+		log.Printf("Failed to load job log: %v", err)
+		return err
+	}
+	logger := log.WithFields(log.Fields{"LogID": jl.ID, "JobID": p.JobID})
+	if jl.Status != "pending" || jl.AsyncJobID.String != p.JobID {
+		logger.Info("Submission no longer waits for this import job")
+		return nil
+	}
+
+	client, err := ClientForInstance(p.Import.Instance)
+	if err != nil {
+		logger.WithError(err).Error("No DHIS2 client for async import check")
+		return instanceClientError(jl, err)
+	}
+
+	completed, err := aggregateImportCompleted(ctx, client, p.JobID)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to check DHIS2 import job status")
+	}
+	if !completed {
+		if time.Since(p.Started) > importCheckTimeout {
+			reason := "DHIS2 did not complete import job " + p.JobID + " in time"
+			recordAttempt(jl, permanentError(reason), "failed", reason, false)
+			return nil
+		}
+		return p.enqueueAgain(ctx)
+	}
+
+	summary, err := aggregateImportSummary(ctx, client, p.JobID)
+	if err != nil {
+		logger.WithError(err).Error("Failed to fetch DHIS2 import summary")
+		return p.enqueueAgain(ctx)
+	}
+	o, errs := finishAggregateImport(jl, p.JobID, summary)
+	if valuesTaken(o) && p.Import.Registrations != nil {
+		completeDataSets(ctx, client, jl, *p.Import.Registrations)
+	}
+	if o.class != joblog.AttemptRetryable {
+		recordAttempt(jl, o, summary.Status, errs, false)
+		return nil
+	}
+
+	// DHIS2 failed the job itself, so import again if the submission has retries left
+	attempts := jl.AttemptCount() + 1
+	left := maxRetries() + 1 - attempts
+	recordAttempt(jl, o, summary.Status, errs, left > 0)
+	if left <= 0 {
+		return nil
+	}
+	retry := asynq.NewTask(p.Import.Type, p.Import.Payload, asynq.MaxRetry(left-1))
+	if _, err := enqueue(ctx, retry, asynq.ProcessIn(RetryDelay(attempts-1, errors.New(o.reason), retry))); err != nil {
+		logger.WithError(err).Error("Failed to enqueue new import after failed DHIS2 job")
+		_ = jl.UpdateStatusAndErrors("failed", err.Error())
+	}
+	return nil
+}
+
+func (p *AggregateImportCheckPayload) enqueueAgain(ctx context.Context) error {
+	task, err := NewAggregateImportCheckTask(*p)
+	if err != nil {
+		return err
+	}
+	_, err = enqueue(ctx, task, asynq.ProcessIn(importCheckInterval()))
+	return err
+}
+
+// enqueueImportCheck hands an accepted async import over to an aggregate:import_check task
+// and completes the task that started it
+func enqueueImportCheck(ctx context.Context, jl *joblog.JobLog, ref importRef, jobID string) error {
+	p := AggregateImportCheckPayload{LogID: jl.ID, JobID: jobID, Started: time.Now(), Import: ref}
+	if err := p.enqueueAgain(ctx); err != nil {
+		log.WithError(err).WithFields(log.Fields{"LogID": jl.ID, "JobID": jobID}).Error(
+			"Failed to enqueue DHIS2 import job check")
+		return err
+	}
+	return nil
+}
+
+// enqueue adds a follow-up task to the queue of the task being handled
+func enqueue(ctx context.Context, task *asynq.Task, opts ...asynq.Option) (*asynq.TaskInfo, error) {
+	if taskClient == nil {
+		return nil, errors.New("no asynq client to enqueue follow-up tasks")
+	}
+	if queue, ok := asynq.GetQueueName(ctx); ok {
+		opts = append(opts, asynq.Queue(queue))
+	}
+	return taskClient.Enqueue(task, opts...)
+}
+
+func importCheckInterval() time.Duration {
+	interval := time.Duration(config.MustGet().Config.Server.Dhis2JobStatusCheckInterval) * time.Second
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	return interval
+}
@@ -0,0 +1,141 @@
+package tasks
+
+import (
+	"dhis2gw/clients"
+	"dhis2gw/config"
+	"dhis2gw/joblog"
+	"dhis2gw/metrics"
+	"dhis2gw/models"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	sdk "github.com/HISP-Uganda/go-dhis2-sdk"
+	"github.com/go-resty/resty/v2"
+	"github.com/hibiken/asynq"
+)
+
+// instanceConf holds what is needed to build an SDK client for a DHIS2 instance
+type instanceConf struct {
+	URL        string
+	AuthMethod string
+	Username   string
+	Password   string
+	AuthToken  string
+	Suspended  bool
+}
+
+// key changes whenever the server definition does, so stale pooled clients get rebuilt
+func (c instanceConf) key() string {
+	return strings.Join([]string{c.URL, c.AuthMethod, c.Username, c.Password, c.AuthToken}, "|")
+}
+
+type pooledClient struct {
+	client *sdk.Client
+	key    string
+}
+
+var (
+	clientPool   = make(map[string]pooledClient)
+	clientPoolMu sync.Mutex
+)
+
+// ErrInstanceSuspended is returned for DHIS2 instances whose server is suspended in the registry
+var ErrInstanceSuspended = errors.New("DHIS2 instance is suspended")
+
+// ClientForInstance returns the SDK client for the named DHIS2 instance. The default
+// instance uses the client passed to SetClient, other instances are looked up by server
+// name in the servers table and then in the conf.d server configs. The servers table is read
+// on every call, so registry changes made through the API apply to running workers too.
+func ClientForInstance(name string) (*sdk.Client, error) {
+	if name == "" || name == models.DefaultInstanceName {
+		if dhis2Client == nil {
+			return nil, errors.New("default DHIS2 client is not configured")
+		}
+		return dhis2Client, nil
+	}
+
+	conf, ok := lookupInstance(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown DHIS2 instance %q", name)
+	}
+	if conf.Suspended {
+		return nil, fmt.Errorf("%w: %s", ErrInstanceSuspended, name)
+	}
+
+	clientPoolMu.Lock()
+	defer clientPoolMu.Unlock()
+	if pc, ok := clientPool[name]; ok && pc.key == conf.key() {
+		return pc.client, nil
+	}
+	client, err := newInstanceClient(name, conf)
+	if err != nil {
+		return nil, fmt.Errorf("instance %q: %w", name, err)
+	}
+	clientPool[name] = pooledClient{client: client, key: conf.key()}
+	return client, nil
+}
+
+// instanceClientError marks the submission failed when no client can be had for its instance.
+// Tasks for suspended instances are archived, so that they can be requeued once it is resumed.
+func instanceClientError(jl *joblog.JobLog, err error) error {
+	_ = jl.UpdateStatusAndErrors("failed", err.Error())
+	if errors.Is(err, ErrInstanceSuspended) {
+		return fmt.Errorf("%w: %w", err, asynq.SkipRetry)
+	}
+	return nil
+}
+
+// KnownInstance reports whether name is the default instance or a configured server
+func KnownInstance(name string) bool {
+	if name == "" || name == models.DefaultInstanceName {
+		return true
+	}
+	_, ok := lookupInstance(name)
+	return ok
+}
+
+// lookupInstance reads the server from the database rather than the server cache, which is
+// only reloaded in the process that served the registry change
+func lookupInstance(name string) (instanceConf, bool) {
+	if srv, err := models.GetServerByName(name); err == nil {
+		return instanceConf{
+			URL:        srv.URL(),
+			AuthMethod: srv.AuthMethod(),
+			Username:   srv.Username(),
+			Password:   srv.Password(),
+			AuthToken:  srv.AuthToken(),
+			Suspended:  srv.Suspended(),
+		}, true
+	}
+	if sc, ok := config.MustGet().ServerConfigs[name]; ok {
+		return instanceConf{
+			URL:        sc.URL,
+			AuthMethod: sc.AuthMethod,
+			Username:   sc.Username,
+			Password:   sc.Password,
+			AuthToken:  sc.AuthToken,
+		}, true
+	}
+	return instanceConf{}, false
+}
+
+func newInstanceClient(name string, conf instanceConf) (*sdk.Client, error) {
+	baseURL, err := clients.GetDHIS2BaseURL(conf.URL)
+	if err != nil {
+		return nil, err
+	}
+	client := sdk.NewClient(baseURL+"/api", conf.Username, conf.Password)
+	if conf.AuthMethod == "Token" {
+		// resty lets basic auth win over any Authorization header, so start from a clean client
+		client.Resty = resty.New().
+			SetBaseURL(baseURL+"/api").
+			SetHeader("Content-Type", "application/json").
+			SetAuthScheme("ApiToken").
+			SetAuthToken(conf.AuthToken)
+	}
+	metrics.InstrumentResty(client.Resty, name)
+	captureResponses(client.Resty)
+	return client, nil
+}
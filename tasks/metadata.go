@@ -0,0 +1,46 @@
+package tasks
+
+import (
+	"fmt"
+	"sync"
+)
+
+// dataSetPeriodTypes caches data set period types per instance; they practically never change
+var (
+	dataSetPeriodTypes   = make(map[string]string)
+	dataSetPeriodTypesMu sync.RWMutex
+)
+
+// DataSetPeriodType returns the periodType of a data set on the named DHIS2 instance
+func DataSetPeriodType(instance, dataSet string) (string, error) {
+	key := instance + "|" + dataSet
+	dataSetPeriodTypesMu.RLock()
+	periodType, ok := dataSetPeriodTypes[key]
+	dataSetPeriodTypesMu.RUnlock()
+	if ok {
+		return periodType, nil
+	}
+
+	client, err := ClientForInstance(instance)
+	if err != nil {
+		return "", err
+	}
+	var ds struct {
+		PeriodType string `json:"periodType"`
+	}
+	res, err := client.Resty.R().
+		SetQueryParam("fields", "periodType").
+		SetResult(&ds).
+		Get("/dataSets/" + dataSet)
+	if err != nil {
+		return "", fmt.Errorf("fetch data set %s: %w", dataSet, err)
+	}
+	if res.IsError() {
+		return "", fmt.Errorf("fetch data set %s: %s", dataSet, res.Status())
+	}
+
+	dataSetPeriodTypesMu.Lock()
+	dataSetPeriodTypes[key] = ds.PeriodType
+	dataSetPeriodTypesMu.Unlock()
+	return ds.PeriodType, nil
+}
@@ -0,0 +1,168 @@
+package tasks
+
+import (
+	"context"
+	"dhis2gw/config"
+	"dhis2gw/joblog"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/hibiken/asynq"
+	log "github.com/sirupsen/logrus"
+)
+
+// statusRetrying is the log status of a submission waiting for its next attempt
+const statusRetrying = "retrying"
+
+// outcome is the classification of one attempt at importing a submission into DHIS2
+type outcome struct {
+	class        string
+	reason       string
+	httpStatus   int
+	importStatus string
+}
+
+// maxRetries is the configured number of retries of a failed import
+func maxRetries() int {
+	if n := config.MustGet().Config.Server.MaxRetries; n > 0 {
+		return n
+	}
+	return 3
+}
+
+// RetryDelay is the asynq RetryDelayFunc of the workers. The delay doubles with every retry
+// from the configured base delay up to the maximum, and is spread over its upper half so
+// that the tasks failed by one DHIS2 outage do not all come back at the same moment.
+func RetryDelay(n int, _ error, _ *asynq.Task) time.Duration {
+	cfg := config.MustGet().Config.Server
+	base, ceiling := cfg.RetryBaseDelay, cfg.RetryMaxDelay
+	if base <= 0 {
+		base = 30 * time.Second
+	}
+	if ceiling < base {
+		ceiling = base
+	}
+	delay := ceiling
+	if n < 32 {
+		if d := base << uint(n); d > 0 && d < ceiling {
+			delay = d
+		}
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// retryableError classifies an error that kept the request from getting a response
+func retryableError(err error) outcome {
+	reason := "network error: " + err.Error()
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		reason = "timeout: " + err.Error()
+	}
+	return outcome{class: joblog.AttemptRetryable, reason: reason}
+}
+
+func permanentError(reason string) outcome {
+	return outcome{class: joblog.AttemptPermanent, reason: reason}
+}
+
+// classifyHTTPStatus classifies an error response without a usable import summary
+func classifyHTTPStatus(res *resty.Response) outcome {
+	o := outcome{class: joblog.AttemptPermanent, httpStatus: res.StatusCode(), reason: "DHIS2 returned " + res.Status()}
+	code := res.StatusCode()
+	if code == 408 || code == 429 || code >= 500 {
+		o.class = joblog.AttemptRetryable
+	}
+	return o
+}
+
+// classifyImportStatus classifies the status of an import summary. An ERROR without
+// conflicts is a failure inside DHIS2, e.g. a lock timeout, that a later attempt can get past.
+func classifyImportStatus(status string, conflicts int) outcome {
+	o := outcome{class: joblog.AttemptSucceeded, importStatus: status}
+	switch strings.ToUpper(status) {
+	case "WARNING":
+		o.class, o.reason = joblog.AttemptPartial, fmt.Sprintf("imported with %d conflicts", conflicts)
+	case "ERROR":
+		if conflicts > 0 {
+			o.class, o.reason = joblog.AttemptPermanent, fmt.Sprintf("rejected with %d conflicts", conflicts)
+		} else {
+			o.class, o.reason = joblog.AttemptRetryable, "DHIS2 reported an import error without conflicts"
+		}
+	}
+	return o
+}
+
+// classifyImportResponse classifies a synchronous import. DHIS2 answers imports it rejects
+// with 409 and the import summary, so a 409 with a summary is judged by the summary, except
+// that it is never retried.
+func classifyImportResponse(err error, res *resty.Response, status string, conflicts int) outcome {
+	switch {
+	case err != nil:
+		return retryableError(err)
+	case res.StatusCode() == 409 && status != "":
+		o := classifyImportStatus(status, conflicts)
+		if o.class == joblog.AttemptRetryable {
+			o.class = joblog.AttemptPermanent
+		}
+		o.httpStatus = res.StatusCode()
+		return o
+	case res.IsError():
+		return classifyHTTPStatus(res)
+	}
+	o := classifyImportStatus(status, conflicts)
+	o.httpStatus = res.StatusCode()
+	return o
+}
+
+// finishAttempt records an attempt on the log, sets the log's status and errors, and tells
+// asynq what to do next: retryable attempts are returned as errors so that asynq retries them
+// until the retries run out, permanent ones skip the remaining retries, and successful or
+// partial imports complete the task.
+func finishAttempt(ctx context.Context, jl *joblog.JobLog, o outcome, status, errs string) error {
+	retried, _ := asynq.GetRetryCount(ctx)
+	maxRetry, _ := asynq.GetMaxRetry(ctx)
+	recordAttempt(jl, o, status, errs, o.class == joblog.AttemptRetryable && retried < maxRetry)
+	switch o.class {
+	case joblog.AttemptRetryable:
+		return errors.New(o.reason)
+	case joblog.AttemptPermanent:
+		return fmt.Errorf("%s: %w", o.reason, asynq.SkipRetry)
+	}
+	return nil
+}
+
+// recordAttempt appends the attempt to the log's attempts and sets the log's status and
+// errors. Attempts are numbered across tasks, since an async import is resolved by a
+// follow-up task and may be enqueued again from there.
+func recordAttempt(jl *joblog.JobLog, o outcome, status, errs string, willRetry bool) {
+	attempt := joblog.Attempt{
+		Number:       jl.AttemptCount() + 1,
+		Class:        o.class,
+		Reason:       o.reason,
+		HTTPStatus:   o.httpStatus,
+		ImportStatus: o.importStatus,
+		WillRetry:    willRetry,
+	}
+	if err := jl.RecordAttempt(attempt); err != nil {
+		log.WithError(err).WithField("LogID", jl.ID).Error("Failed to record import attempt")
+	}
+	if attempt.WillRetry {
+		status = statusRetrying
+	}
+	_ = jl.UpdateStatusAndErrors(status, errs)
+
+	log.WithFields(log.Fields{
+		"LogID": jl.ID, "Attempt": attempt.Number, "Class": o.class, "Reason": o.reason, "WillRetry": attempt.WillRetry,
+	}).Info("Import attempt finished")
+}
+
+// isFirstAttempt reports whether the task runs for the first time
+func isFirstAttempt(ctx context.Context) bool {
+	retried, _ := asynq.GetRetryCount(ctx)
+	return retried == 0
+}
@@ -0,0 +1,101 @@
+package tasks
+
+import (
+	"fmt"
+
+	"github.com/goccy/go-json"
+)
+
+// PayloadSummary describes what a queued task submits, for browsing failed tasks without
+// decoding their payloads. A bulk group lists every data set, org unit and period of its blocks,
+// and summarizes each block on its own in Blocks.
+type PayloadSummary struct {
+	LogID    int64    `json:"log_id" example:"1035"`
+	BatchID  int64    `json:"batch_id,omitempty" example:"12"`
+	Blocks   int      `json:"blocks,omitempty" example:"40"`
+	DataSets []string `json:"dataSets,omitempty" example:"pKxY5g6WgDm"`
+	OrgUnits []string `json:"orgUnits,omitempty" example:"g8xY5g6WgXl"`
+	Periods  []string `json:"periods,omitempty" example:"202401"`
+	Sources  []string `json:"sources,omitempty" example:"default"`
+
+	BlockSummaries []PayloadSummary `json:"-"`
+}
+
+// SummarizePayload decodes the payload of a task of the given type
+func SummarizePayload(taskType string, payload []byte) (PayloadSummary, error) {
+	var s PayloadSummary
+	switch taskType {
+	case TypeAggregate:
+		var p AggregateTaskPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return s, err
+		}
+		s.LogID = p.LogID
+		s.DataSets = appendUnique(nil, p.Payload.DataSet)
+		s.OrgUnits = appendUnique(nil, p.Payload.OrgUnit, p.Payload.OrgUnitCode)
+		s.Periods = appendUnique(nil, p.Payload.Period)
+		s.Sources = appendUnique(nil, p.Payload.SourceName())
+	case TypeAggregateBulk:
+		var p BulkAggregateTaskPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return s, err
+		}
+		s.LogID, s.BatchID, s.Blocks = p.LogID, p.BatchID, len(p.Blocks)
+		for i := range p.Blocks {
+			b := &p.Blocks[i]
+			s.DataSets = appendUnique(s.DataSets, b.DataSet)
+			s.OrgUnits = appendUnique(s.OrgUnits, b.OrgUnit, b.OrgUnitCode)
+			s.Periods = appendUnique(s.Periods, b.Period)
+			s.Sources = appendUnique(s.Sources, b.SourceName())
+			s.BlockSummaries = append(s.BlockSummaries, PayloadSummary{
+				DataSets: appendUnique(nil, b.DataSet),
+				OrgUnits: appendUnique(nil, b.OrgUnit, b.OrgUnitCode),
+				Periods:  appendUnique(nil, b.Period),
+				Sources:  appendUnique(nil, b.SourceName()),
+			})
+		}
+	case TypeAggregateImportCheck:
+		var p AggregateImportCheckPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return s, err
+		}
+		s.LogID = p.LogID
+		if p.Import.Type != TypeAggregateImportCheck {
+			inner, err := SummarizePayload(p.Import.Type, p.Import.Payload)
+			if err != nil {
+				return s, err
+			}
+			inner.LogID = p.LogID
+			s = inner
+		}
+	case TypeTracker:
+		var p TrackerTaskPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return s, err
+		}
+		s.LogID = p.LogID
+		s.OrgUnits = p.Payload.OrgUnits()
+	default:
+		return s, fmt.Errorf("unknown task type %s", taskType)
+	}
+	return s, nil
+}
+
+func appendUnique(list []string, values ...string) []string {
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		found := false
+		for _, have := range list {
+			if have == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, v)
+		}
+	}
+	return list
+}
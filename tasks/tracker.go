@@ -0,0 +1,136 @@
+package tasks
+
+import (
+	"context"
+	"dhis2gw/config"
+	"dhis2gw/db"
+	"dhis2gw/joblog"
+	"dhis2gw/metrics"
+	"dhis2gw/models"
+	goerrors "errors"
+
+	"github.com/HISP-Uganda/go-dhis2-sdk/tracker"
+	"github.com/go-resty/resty/v2"
+	"github.com/goccy/go-json"
+	"github.com/hibiken/asynq"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	TypeTracker = "tracker:send"
+)
+
+type TrackerTaskPayload struct {
+	LogID   int64 `json:"log_id"`
+	Payload models.TrackerRequest
+}
+
+func NewTrackerTask(trackerRequest TrackerTaskPayload) (*asynq.Task, error) {
+	payload, err := json.Marshal(trackerRequest)
+	if err != nil {
+		return nil, err
+	}
+	return asynq.NewTask(TypeTracker, payload, asynq.MaxRetry(maxRetries())), nil
+}
+
+func HandleTrackerTask(ctx context.Context, task *asynq.Task) error {
+	var trackerRequest TrackerTaskPayload
+	if err := json.Unmarshal(task.Payload(), &trackerRequest); err != nil {
+		return err
+	}
+
+	if err := trackerRequest.Process(ctx); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p *TrackerTaskPayload) Process(ctx context.Context) error {
+	payload := p.Payload.ToDHIS2TrackerPayload()
+
+	jl, err := joblog.Load(db.GetDB(), p.LogID)
+	if err != nil {
+		log.Printf("Failed to load job log: %v", err)
+		return err
+	}
+
+	if isFirstAttempt(ctx) {
+		dhis2Payload, marshalErr := json.Marshal(payload)
+		if marshalErr != nil {
+			log.Printf("Failed to marshal DHIS2 payload: %v", marshalErr)
+			return marshalErr
+		}
+		if err := jl.UpdateDhis2Payload(string(dhis2Payload)); err != nil {
+			log.Printf("Failed to update submission log with DHIS2 payload: %v", err)
+		}
+	}
+
+	resp, res, err := dhis2Client.SendTrackerPayload(ctx, &payload, p.Payload.ImportParams())
+	o := classifyTrackerResponse(err, res, resp)
+	status := "success"
+	dhis2Resp := ""
+	errors := ""
+
+	if err != nil {
+		log.Error("Error sending tracker payload to DHIS2: ", err)
+		status = "failed"
+		errors = err.Error()
+	} else if resp != nil && resp.SyncResp != nil {
+		log.Info("Successfully sent tracker payload to DHIS2")
+		status = string(resp.SyncResp.Status)
+	}
+
+	// DHIS2 returns an import report alongside validation errors, so keep it either way
+	if resp != nil && resp.SyncResp != nil {
+		rp, marshalErr := json.Marshal(resp.SyncResp)
+		if marshalErr != nil {
+			log.Error("Error marshalling DHIS2 response: ", marshalErr)
+		} else {
+			dhis2Resp = string(rp)
+		}
+		stats := resp.SyncResp.GetStats()
+		metrics.ImportCounts("tracker", int(stats.Created), int(stats.Updated), int(stats.Ignored), int(stats.Deleted))
+	}
+	if errors == "" && o.class != joblog.AttemptSucceeded {
+		errors = o.reason
+	}
+
+	if config.MustGet().Config.API.SaveResponse == "true" && dhis2Resp != "" {
+		_ = jl.UpdateResponse(dhis2Resp)
+	}
+
+	log.WithFields(log.Fields{"ImportReport": dhis2Resp}).Info("Tracker Import Response")
+	return finishAttempt(ctx, jl, o, status, errors)
+}
+
+// classifyTrackerResponse classifies a synchronous tracker import. The SDK reports validation
+// errors as an error next to the import report, and error statuses with the response only.
+func classifyTrackerResponse(err error, res *resty.Response, resp *tracker.TrackerResponse) outcome {
+	if res == nil || res.RawResponse == nil {
+		if err == nil {
+			err = goerrors.New("no response from DHIS2")
+		}
+		return retryableError(err)
+	}
+	if resp != nil && resp.SyncResp != nil {
+		report := resp.SyncResp
+		problems := 0
+		if report.ValidationReport != nil {
+			problems = len(report.ValidationReport.ErrorReports)
+			if report.Status == "WARNING" {
+				problems = len(report.ValidationReport.WarningReports)
+			}
+		}
+		o := classifyImportStatus(string(report.Status), problems)
+		o.httpStatus = res.StatusCode()
+		return o
+	}
+	if res.IsError() {
+		return classifyHTTPStatus(res)
+	}
+	if err != nil {
+		return outcome{class: joblog.AttemptPermanent, httpStatus: res.StatusCode(), reason: err.Error()}
+	}
+	return outcome{class: joblog.AttemptSucceeded, httpStatus: res.StatusCode()}
+}
@@ -1,5 +1,7 @@
 package utils
 
+import "sort"
+
 func Queues(prefix string) map[string]int {
 	p := ""
 	if prefix != "" {
@@ -19,3 +21,14 @@ func GetDefaultQueue(prefix string) string {
 	}
 	return "default"
 }
+
+// QueueNames returns the names of the queues returned by Queues, sorted
+func QueueNames(prefix string) []string {
+	queues := Queues(prefix)
+	names := make([]string, 0, len(queues))
+	for name := range queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
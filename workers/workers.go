@@ -3,8 +3,10 @@ package workers
 import (
 	"dhis2gw/config"
 	"dhis2gw/db"
+	"dhis2gw/metrics"
 	"dhis2gw/models"
 	"dhis2gw/tasks"
+	"dhis2gw/utils"
 	"github.com/hibiken/asynq"
 	log "github.com/sirupsen/logrus"
 )
@@ -40,23 +42,35 @@ func main() {
 	}
 
 	srv := asynq.NewServer(
-		asynq.RedisClientOpt{Addr: cfg.Server.RedisAddress},
+		asynq.RedisClientOpt{Addr: cfg.Server.RedisAddress, DB: cfg.Server.RedisDB},
 		asynq.Config{
 			// Specify how many concurrent workers to use
 			Concurrency: cfg.Server.MaxConcurrent,
-			// Optionally, specify multiple queues with different priority.
-			Queues: map[string]int{
-				"critical": 6,
-				"default":  3,
-				"low":      1,
-			},
+			// Same queues the API enqueues into
+			Queues: utils.Queues(cfg.Server.QueuePrefix),
+			// Back off exponentially between retries of failed DHIS2 imports
+			RetryDelayFunc: tasks.RetryDelay,
 			// See the godoc for other configuration options
 		},
 	)
 
+	// Handlers enqueue follow-up tasks, e.g. checks of async DHIS2 imports
+	client := asynq.NewClient(asynq.RedisClientOpt{Addr: cfg.Server.RedisAddress, DB: cfg.Server.RedisDB})
+	defer func() { _ = client.Close() }()
+	tasks.SetTaskClient(client)
+
 	mux := asynq.NewServeMux()
 
 	mux.HandleFunc(tasks.TypeAggregate, tasks.HandleAggregateTask)
+	mux.HandleFunc(tasks.TypeAggregateBulk, tasks.HandleBulkAggregateTask)
+	mux.HandleFunc(tasks.TypeAggregateImportCheck, tasks.HandleAggregateImportCheckTask)
+	mux.HandleFunc(tasks.TypeTracker, tasks.HandleTrackerTask)
+	mux.Use(metrics.TaskMiddleware)
+
+	metrics.RegisterQueueCollector(
+		asynq.NewInspector(asynq.RedisClientOpt{Addr: cfg.Server.RedisAddress, DB: cfg.Server.RedisDB}),
+		utils.QueueNames(cfg.Server.QueuePrefix))
+	metrics.ListenAndServe(cfg.Server.MetricsAddress)
 
 	if err := srv.Run(mux); err != nil {
 		log.Fatalf("could not run server: %v", err)